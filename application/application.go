@@ -0,0 +1,39 @@
+package application
+
+import (
+	"consensus_layer/blockchain"
+	"fmt"
+)
+
+// Application is the state machine that consumes the ordered blocks of the consensus layer.
+// Finalized blocks are executed in order: BeginBlock, DeliverTx for every transaction, EndBlock and Commit.
+type Application interface {
+	// CheckTx validates a transaction before it's accepted by the mempool, it must not change the state
+	CheckTx(tx blockchain.Transaction) error
+	BeginBlock(header blockchain.BlockHeader) error
+	// DeliverTx executes a transaction, a failed transaction doesn't stop the execution of the block
+	DeliverTx(tx blockchain.Transaction) error
+	EndBlock(height uint64) error
+	// Commit persists the state changed by the block and returns the new app hash
+	Commit() (blockchain.SHA256Type, error)
+	// AppHash returns the hash of the last committed state
+	AppHash() blockchain.SHA256Type
+	// Height returns the height of the last committed block, the node replays the blocks above it on startup
+	Height() uint64
+}
+
+// ExecuteBlock runs a finalized block through the application and returns the new app hash
+func ExecuteBlock(app Application, block *blockchain.SignedBlock) (blockchain.SHA256Type, error) {
+	if err := app.BeginBlock(block.Header); err != nil {
+		return blockchain.SHA256Type{}, err
+	}
+	for i := range block.Transactions {
+		if err := app.DeliverTx(block.Transactions[i]); err != nil {
+			fmt.Println("transaction ", i, " of block ", block.Header.Height, " failed: ", err)
+		}
+	}
+	if err := app.EndBlock(block.Header.Height); err != nil {
+		return blockchain.SHA256Type{}, err
+	}
+	return app.Commit()
+}
@@ -0,0 +1,114 @@
+package application
+
+import (
+	"consensus_layer/blockchain"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KVStore is an in-memory key-value application, the payload of a transaction is "key=value"
+type KVStore struct {
+	committed 	map[string]string
+	pending 	map[string]string // changes of the block being executed
+	height 		uint64
+	appHash 	blockchain.SHA256Type
+	mutex 		sync.RWMutex
+}
+
+func NewKVStore() *KVStore {
+	return &KVStore{
+		committed: 	make(map[string]string, 0),
+		pending: 	make(map[string]string, 0),
+	}
+}
+
+// application interface
+var _ Application = (*KVStore)(nil)
+
+func parsePayload(payload []byte) (string, string, error) {
+	pair := strings.SplitN(string(payload), "=", 2)
+	if len(pair) != 2 || pair[0] == "" {
+		return "", "", fmt.Errorf("payload should be key=value")
+	}
+	return pair[0], pair[1], nil
+}
+
+func (kv *KVStore) CheckTx(tx blockchain.Transaction) error {
+	_, _, err := parsePayload(tx.Payload)
+	return err
+}
+
+func (kv *KVStore) BeginBlock(header blockchain.BlockHeader) error {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	if header.Height != kv.height + 1 {
+		return fmt.Errorf("expected block %d, got %d", kv.height + 1, header.Height)
+	}
+	kv.pending = make(map[string]string, 0)
+	return nil
+}
+
+func (kv *KVStore) DeliverTx(tx blockchain.Transaction) error {
+	key, value, err := parsePayload(tx.Payload)
+	if err != nil {
+		return err
+	}
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	kv.pending[key] = value
+	return nil
+}
+
+func (kv *KVStore) EndBlock(height uint64) error {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	kv.height = height
+	return nil
+}
+
+func (kv *KVStore) Commit() (blockchain.SHA256Type, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	for key, value := range kv.pending {
+		kv.committed[key] = value
+	}
+	kv.pending = make(map[string]string, 0)
+	// hash the pairs in key order so every node gets the same app hash
+	keys := make([]string, 0, len(kv.committed))
+	for key := range kv.committed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, 2 * len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key, kv.committed[key])
+	}
+	appHash, err := blockchain.Digest(pairs)
+	if err != nil {
+		return blockchain.SHA256Type{}, err
+	}
+	kv.appHash = appHash
+	return appHash, nil
+}
+
+func (kv *KVStore) AppHash() blockchain.SHA256Type {
+	kv.mutex.RLock()
+	defer kv.mutex.RUnlock()
+	return kv.appHash
+}
+
+func (kv *KVStore) Height() uint64 {
+	kv.mutex.RLock()
+	defer kv.mutex.RUnlock()
+	return kv.height
+}
+
+// Query returns the committed value of the key
+func (kv *KVStore) Query(key string) (string, bool) {
+	kv.mutex.RLock()
+	defer kv.mutex.RUnlock()
+	value, ok := kv.committed[key]
+	return value, ok
+}
@@ -0,0 +1,55 @@
+package application
+
+import (
+	"testing"
+	"consensus_layer/blockchain"
+	"consensus_layer/crypto"
+)
+
+func newTestBlock(t *testing.T, height uint64, payloads ...string) *blockchain.SignedBlock {
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	block := &blockchain.SignedBlock{}
+	block.Header.Height = height
+	for i, payload := range payloads {
+		tx := blockchain.Transaction{
+			Payload: 	[]byte(payload),
+			Nonce: 		uint64(i + 1),
+		}
+		if err := tx.Sign(privateKey); err != nil {
+			t.Fatal(err)
+		}
+		block.Transactions = append(block.Transactions, tx)
+	}
+	block.Seal()
+	return block
+}
+
+func TestKVStore(t *testing.T) {
+	kv1, kv2 := NewKVStore(), NewKVStore()
+	blocks := []*blockchain.SignedBlock{
+		newTestBlock(t, 1, "a=1", "b=2", "invalid"),
+		newTestBlock(t, 2, "a=3"),
+	}
+	for _, block := range blocks {
+		hash1, err := ExecuteBlock(kv1, block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash2, _ := ExecuteBlock(kv2, block)
+		if hash1 != hash2 || hash1 != kv1.AppHash() {
+			t.Fatal("app hash should be deterministic")
+		}
+	}
+	if value, _ := kv1.Query("a"); value != "3" {
+		t.Fatal("a should be 3")
+	}
+	if value, _ := kv1.Query("b"); value != "2" {
+		t.Fatal("b should be 2")
+	}
+	if _, err := ExecuteBlock(kv1, newTestBlock(t, 4, "c=1")); err == nil {
+		t.Fatal("blocks should be executed in order")
+	}
+	if err := kv1.CheckTx(blocks[0].Transactions[2]); err == nil {
+		t.Fatal("invalid payload should be rejected")
+	}
+}
@@ -0,0 +1,103 @@
+package blockchain
+
+import (
+	"consensus_layer/crypto"
+	"fmt"
+)
+
+// CalculateId hashes the canonical serialization of the header with the id field zeroed
+func (header BlockHeader) CalculateId() (SHA256Type, error) {
+	header.Id = SHA256Type{}
+	return Digest(header)
+}
+
+func (block *SignedBlock) transactionIds() []SHA256Type {
+	ids := make([]SHA256Type, len(block.Transactions))
+	for i := range block.Transactions {
+		ids[i] = block.Transactions[i].Id()
+	}
+	return ids
+}
+
+func (block *SignedBlock) CalculateTransactionRoot() SHA256Type {
+	return MerkleRoot(block.transactionIds())
+}
+
+// TransactionProof builds the proof that the transaction with txId is included in the block
+func (block *SignedBlock) TransactionProof(txId SHA256Type) (*MerkleProof, error) {
+	ids := block.transactionIds()
+	for i, id := range ids {
+		if id == txId {
+			return NewMerkleTree(ids).Proof(i, id)
+		}
+	}
+	return nil, fmt.Errorf("transaction isn't included in the block")
+}
+
+// VerifyTransactionProof checks that the proof includes the transaction with txId under the
+// transaction root of the header, the leaf is derived from txId rather than trusted from the proof
+func (header BlockHeader) VerifyTransactionProof(txId SHA256Type, proof *MerkleProof) bool {
+	if proof.Leaf != txId {
+		return false
+	}
+	return proof.Verify(header.TransactionRoot)
+}
+
+// Seal commits the block header to the transactions and sets its id
+func (block *SignedBlock) Seal() error {
+	block.Header.TransactionRoot = block.CalculateTransactionRoot()
+	id, err := block.Header.CalculateId()
+	if err != nil {
+		return err
+	}
+	block.Header.Id = id
+	return nil
+}
+
+// Validate checks that the header id and the transaction root match the content of the block
+func (block *SignedBlock) Validate() error {
+	if block.CalculateTransactionRoot() != block.Header.TransactionRoot {
+		return fmt.Errorf("transaction root doesn't match the transactions")
+	}
+	id, err := block.Header.CalculateId()
+	if err != nil {
+		return err
+	}
+	if id != block.Header.Id {
+		return fmt.Errorf("block id doesn't match the header")
+	}
+	for i := range block.Transactions {
+		if !block.Transactions[i].Verify() {
+			return fmt.Errorf("transaction %d has an invalid signature", i)
+		}
+	}
+	return nil
+}
+
+// Digest is the hash that the sender signs, the signature itself is excluded
+func (tx *Transaction) Digest() SHA256Type {
+	digest, _ := SigningDigest(tx)
+	return digest
+}
+
+// Id is the hash of the whole signed transaction, it's used as the merkle leaf
+func (tx *Transaction) Id() SHA256Type {
+	id, _ := Digest(*tx)
+	return id
+}
+
+func (tx *Transaction) Sign(privateKey *crypto.PrivateKey) error {
+	tx.Sender = *privateKey.PublicKey()
+	digest := tx.Digest()
+	sig, err := privateKey.Sign(digest[:])
+	if err != nil {
+		return err
+	}
+	tx.Signature = sig
+	return nil
+}
+
+func (tx *Transaction) Verify() bool {
+	digest := tx.Digest()
+	return tx.Signature.Verify(tx.Sender, digest[:])
+}
@@ -0,0 +1,131 @@
+package blockchain
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"consensus_layer/crypto"
+	"consensus_layer/serializer"
+)
+
+func newTestTransaction(t *testing.T, privateKey *crypto.PrivateKey, nonce uint64) Transaction {
+	tx := Transaction{
+		Payload: 	[]byte("payload"),
+		Nonce: 		nonce,
+	}
+	if err := tx.Sign(privateKey); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestTransactionSignature(t *testing.T) {
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	tx := newTestTransaction(t, privateKey, 1)
+	if !tx.Verify() {
+		t.Fatal("signature should be valid")
+	}
+	tx.Nonce = 2
+	if tx.Verify() {
+		t.Fatal("signature shouldn't cover a modified transaction")
+	}
+}
+
+func TestBlockCoversTransactions(t *testing.T) {
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	block := newTestBlock(1, SHA256Type{})
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		block.Transactions = append(block.Transactions, newTestTransaction(t, privateKey, nonce))
+	}
+	emptyId := block.Header.Id
+	if err := block.Seal(); err != nil {
+		t.Fatal(err)
+	}
+	if block.Header.Id == emptyId {
+		t.Fatal("block id should depend on the transactions")
+	}
+	if err := block.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	block.Transactions = block.Transactions[:2]
+	if err := block.Validate(); err == nil {
+		t.Fatal("removing a transaction should invalidate the block")
+	}
+}
+
+func TestMerkleRoot(t *testing.T) {
+	if MerkleRoot(nil) != (SHA256Type{}) {
+		t.Fatal("root of no leaves should be zero")
+	}
+	a, b, c := SHA256Type{1}, SHA256Type{2}, SHA256Type{3}
+	if MerkleRoot([]SHA256Type{a, b, c}) == MerkleRoot([]SHA256Type{a, b, c, c}) {
+		t.Fatal("duplicating the last leaf should change the root")
+	}
+	if MerkleRoot([]SHA256Type{a, b}) == MerkleRoot([]SHA256Type{b, a}) {
+		t.Fatal("root should depend on the order of leaves")
+	}
+}
+
+func TestTransactionProof(t *testing.T) {
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	for count := 1; count <= 7; count++ {
+		block := newTestBlock(1, SHA256Type{})
+		for nonce := 1; nonce <= count; nonce++ {
+			block.Transactions = append(block.Transactions, newTestTransaction(t, privateKey, uint64(nonce)))
+		}
+		block.Seal()
+		for i := range block.Transactions {
+			txId := block.Transactions[i].Id()
+			proof, err := block.TransactionProof(txId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !block.Header.VerifyTransactionProof(txId, proof) {
+				t.Fatalf("proof of transaction %d/%d should be valid", i, count)
+			}
+			other := block.Transactions[(i + 1) % count].Id()
+			if count > 1 && block.Header.VerifyTransactionProof(other, proof) {
+				t.Fatal("proof of another transaction should be invalid")
+			}
+			// the proof doesn't hold for another position
+			for leafCount := uint32(1); leafCount <= 8; leafCount++ {
+				for index := uint32(0); index < leafCount; index++ {
+					if index == uint32(i) && leafCount == uint32(count) {
+						continue
+					}
+					moved := *proof
+					moved.Index, moved.LeafCount = index, leafCount
+					if block.Header.VerifyTransactionProof(txId, &moved) {
+						t.Fatalf("proof of transaction %d/%d shouldn't hold at %d/%d", i, count, index, leafCount)
+					}
+				}
+			}
+			proof.Leaf = SHA256Type{}
+			if block.Header.VerifyTransactionProof(txId, proof) {
+				t.Fatal("proof of another leaf should be invalid")
+			}
+		}
+	}
+}
+
+func TestMaxBlockOverhead(t *testing.T) {
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	block := &SignedBlock{}
+	block.Header.Height = 1 << 62
+	block.Header.AppHeight = 1 << 62
+	block.Header.Producer = strings.Repeat("p", MaxProducerAddressLength)
+	block.Header.Timestamp = time.Now().UTC()
+	block.Seal()
+	signed, err := SignHeader(block.Header, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.SignedHeader = signed
+	data, err := serializer.MarshalBinary(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > MaxBlockOverhead {
+		t.Fatalf("empty block takes %d bytes, more than the overhead of %d", len(data), MaxBlockOverhead)
+	}
+}
@@ -0,0 +1,241 @@
+package blockchain
+
+import (
+	"os"
+	"fmt"
+	"io"
+	"sync"
+	"path/filepath"
+	"encoding/binary"
+	"hash/crc32"
+	"consensus_layer/crypto"
+	"consensus_layer/serializer"
+)
+
+const blockLogName = "blocks.log"
+// every record is prefixed with the length and crc32 checksum of the serialized block,
+// followed by the crc32 checksum of those 8 bytes so a damaged length is never trusted
+const recordHeaderSize = 12
+
+// a crash while appending leaves these at the end of the log
+var (
+	errIncompleteHeader = fmt.Errorf("incomplete record header")
+	errIncompleteRecord = fmt.Errorf("incomplete record")
+)
+
+// BlockLog is an append-only on-disk store of signed blocks.
+// Blocks are indexed by height and by id in memory; the indexes are rebuilt from the log on startup.
+type BlockLog struct {
+	file 		*os.File
+	offsets 	[]int64 // offsets[height-1] is the position of the block record
+	ids 		map[SHA256Type]uint64 // [block id]height
+	topId 		SHA256Type
+	nonces 		map[string]uint64 // [sender public key]last nonce of the sender
+	size 		int64
+	mutex 		sync.RWMutex
+}
+
+// OpenBlockLog opens the block log in dir, creating it if it doesn't exist.
+// A partially written record left by a crash at the end of the log is truncated away,
+// a damaged record followed by other records is an error.
+func OpenBlockLog(dir string) (*BlockLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, blockLogName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	bl := &BlockLog{
+		file: 		file,
+		offsets: 	make([]int64, 0),
+		ids: 		make(map[SHA256Type]uint64, 0),
+		nonces: 	make(map[string]uint64, 0),
+	}
+	if err := bl.scan(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return bl, nil
+}
+
+// scan verifies every record of the log and rebuilds the indexes
+func (bl *BlockLog) scan() error {
+	offset := int64(0)
+	for {
+		block, size, err := bl.readRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// only the last record can be torn by a crash, anything else is real damage
+			torn := err == errIncompleteHeader || err == errIncompleteRecord || (size > 0 && offset + size == bl.fileSize())
+			if !torn {
+				return fmt.Errorf("block log is corrupted at offset %d: %s", offset, err)
+			}
+			fmt.Println("truncating block log at offset ", offset, ": ", err)
+			break
+		}
+		if err := bl.checkLink(block); err != nil {
+			return fmt.Errorf("block log is inconsistent at height %d: %s", block.Header.Height, err)
+		}
+		bl.index(block, offset)
+		offset += size
+	}
+	if err := bl.file.Truncate(offset); err != nil {
+		return err
+	}
+	bl.size = offset
+	return nil
+}
+
+// readRecord returns the block at offset and the size of its record. The size is
+// also returned with the errors of a record whose header is valid.
+func (bl *BlockLog) readRecord(offset int64) (*SignedBlock, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	n, err := bl.file.ReadAt(header, offset)
+	if n == 0 && err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if n != recordHeaderSize {
+		return nil, 0, errIncompleteHeader
+	}
+	if crc32.ChecksumIEEE(header[:8]) != binary.BigEndian.Uint32(header[8:]) {
+		return nil, 0, fmt.Errorf("invalid record header checksum")
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	size := int64(length) + recordHeaderSize
+	if int64(length) > bl.fileSize() - offset - recordHeaderSize {
+		return nil, size, errIncompleteRecord
+	}
+	data := make([]byte, length)
+	if _, err := bl.file.ReadAt(data, offset + recordHeaderSize); err != nil {
+		return nil, size, err
+	}
+	if crc32.ChecksumIEEE(data) != checksum {
+		return nil, size, fmt.Errorf("invalid checksum")
+	}
+	block := &SignedBlock{}
+	if err := serializer.UnmarshalBinary(data, block); err != nil {
+		return nil, size, err
+	}
+	return block, size, nil
+}
+
+func (bl *BlockLog) fileSize() int64 {
+	info, err := bl.file.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// the next block must extend the top block of the log
+func (bl *BlockLog) checkLink(block *SignedBlock) error {
+	header := block.Header
+	if header.Height != uint64(len(bl.offsets)) + 1 {
+		return fmt.Errorf("expected height %d, got %d", len(bl.offsets) + 1, header.Height)
+	}
+	if header.PreviousId != bl.topId {
+		return fmt.Errorf("previous id doesn't match the top block")
+	}
+	if _, ok := bl.ids[header.Id]; ok {
+		return fmt.Errorf("duplicated block id")
+	}
+	return nil
+}
+
+func (bl *BlockLog) index(block *SignedBlock, offset int64) {
+	header := block.Header
+	bl.offsets = append(bl.offsets, offset)
+	bl.ids[header.Id] = header.Height
+	bl.topId = header.Id
+	for i := range block.Transactions {
+		bl.nonces[string(block.Transactions[i].Sender.Data)] = block.Transactions[i].Nonce
+	}
+}
+
+// Append writes the block to the end of the log and syncs it to disk
+func (bl *BlockLog) Append(block *SignedBlock) error {
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+	if err := bl.checkLink(block); err != nil {
+		return err
+	}
+	data, err := serializer.MarshalBinary(block)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, recordHeaderSize, recordHeaderSize + len(data))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	binary.BigEndian.PutUint32(record[8:], crc32.ChecksumIEEE(record[:8]))
+	record = append(record, data...)
+	if _, err := bl.file.WriteAt(record, bl.size); err != nil {
+		// drop whatever was partially written
+		bl.file.Truncate(bl.size)
+		return err
+	}
+	if err := bl.file.Sync(); err != nil {
+		bl.file.Truncate(bl.size)
+		return err
+	}
+	bl.index(block, bl.size)
+	bl.size += int64(len(record))
+	return nil
+}
+
+// TopBlockHeight returns the height of the last block, 0 if the log is empty
+func (bl *BlockLog) TopBlockHeight() uint64 {
+	bl.mutex.RLock()
+	defer bl.mutex.RUnlock()
+	return uint64(len(bl.offsets))
+}
+
+// TopBlockId returns the id of the last block, zero if the log is empty
+func (bl *BlockLog) TopBlockId() SHA256Type {
+	bl.mutex.RLock()
+	defer bl.mutex.RUnlock()
+	return bl.topId
+}
+
+func (bl *BlockLog) ReadBlockByHeight(height uint64) (*SignedBlock, error) {
+	bl.mutex.RLock()
+	defer bl.mutex.RUnlock()
+	if height == 0 || height > uint64(len(bl.offsets)) {
+		return nil, fmt.Errorf("block at height %d doesn't exist", height)
+	}
+	block, _, err := bl.readRecord(bl.offsets[height-1])
+	return block, err
+}
+
+func (bl *BlockLog) ReadBlockById(id SHA256Type) (*SignedBlock, error) {
+	bl.mutex.RLock()
+	height, ok := bl.ids[id]
+	bl.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("block doesn't exist")
+	}
+	return bl.ReadBlockByHeight(height)
+}
+
+// LastNonce returns the nonce of the last transaction of the sender in the log, 0 if it has none
+func (bl *BlockLog) LastNonce(sender crypto.PublicKey) uint64 {
+	bl.mutex.RLock()
+	defer bl.mutex.RUnlock()
+	return bl.nonces[string(sender.Data)]
+}
+
+func (bl *BlockLog) HasBlock(id SHA256Type) bool {
+	bl.mutex.RLock()
+	defer bl.mutex.RUnlock()
+	_, ok := bl.ids[id]
+	return ok
+}
+
+func (bl *BlockLog) Close() error {
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+	return bl.file.Close()
+}
@@ -0,0 +1,173 @@
+package blockchain
+
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func newTestBlock(height uint64, previousId SHA256Type) *SignedBlock {
+	block := &SignedBlock{}
+	block.Header.Height = height
+	block.Header.PreviousId = previousId
+	block.Header.Producer = "producer"
+	block.Header.Timestamp = time.Unix(int64(1500000000 + height), 0).UTC()
+	block.Seal()
+	return block
+}
+
+func TestBlockLog(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "blocklog")
+	defer os.RemoveAll(dir)
+	bl, err := OpenBlockLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousId := SHA256Type{}
+	ids := make([]SHA256Type, 0)
+	for height := uint64(1); height <= 3; height++ {
+		block := newTestBlock(height, previousId)
+		if err := bl.Append(block); err != nil {
+			t.Fatal(err)
+		}
+		previousId = block.Header.Id
+		ids = append(ids, previousId)
+	}
+	if err := bl.Append(newTestBlock(5, previousId)); err == nil {
+		t.Fatal("a gap in heights should be rejected")
+	}
+	if err := bl.Append(newTestBlock(4, SHA256Type{})); err == nil {
+		t.Fatal("a block that doesn't extend the top block should be rejected")
+	}
+	bl.Close()
+
+	bl, err = OpenBlockLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bl.Close()
+	if bl.TopBlockHeight() != 3 || bl.TopBlockId() != ids[2] {
+		t.Fatal("top block should be restored")
+	}
+	block, err := bl.ReadBlockById(ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Header.Height != 2 || block.Header.PreviousId != ids[0] {
+		t.Fatal("wrong block")
+	}
+	if !block.Header.Timestamp.Equal(time.Unix(1500000002, 0)) {
+		t.Fatal("timestamp should be the same")
+	}
+	if _, err := bl.ReadBlockByHeight(4); err == nil {
+		t.Fatal("block 4 doesn't exist")
+	}
+}
+
+func TestBlockLogTruncatesCorruptedTail(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "blocklog")
+	defer os.RemoveAll(dir)
+	bl, _ := OpenBlockLog(dir)
+	block1 := newTestBlock(1, SHA256Type{})
+	block2 := newTestBlock(2, block1.Header.Id)
+	bl.Append(block1)
+	bl.Append(block2)
+	bl.Close()
+
+	// simulate a crash in the middle of writing the second record
+	path := filepath.Join(dir, blockLogName)
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size() - 3)
+
+	bl, err := OpenBlockLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bl.Close()
+	if bl.TopBlockHeight() != 1 || bl.TopBlockId() != block1.Header.Id {
+		t.Fatal("the incomplete block should be dropped")
+	}
+	if err := bl.Append(block2); err != nil {
+		t.Fatal(err)
+	}
+	if bl.TopBlockHeight() != 2 {
+		t.Fatal("block 2 should be appended again")
+	}
+}
+
+func TestBlockLogRejectsCorruptedRecord(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "blocklog")
+	defer os.RemoveAll(dir)
+	bl, _ := OpenBlockLog(dir)
+	block1 := newTestBlock(1, SHA256Type{})
+	block2 := newTestBlock(2, block1.Header.Id)
+	bl.Append(block1)
+	bl.Append(block2)
+	bl.Close()
+
+	// damage the first record, the second one is intact so this isn't a torn write
+	path := filepath.Join(dir, blockLogName)
+	data, _ := ioutil.ReadFile(path)
+	data[recordHeaderSize] ^= 1
+	ioutil.WriteFile(path, data, 0644)
+
+	if _, err := OpenBlockLog(dir); err == nil {
+		t.Fatal("a corrupted record in the middle of the log should be an error")
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(len(data)) {
+		t.Fatal("the log shouldn't be truncated")
+	}
+}
+
+func TestBlockLogTruncatesTornHeader(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "blocklog")
+	defer os.RemoveAll(dir)
+	bl, _ := OpenBlockLog(dir)
+	block1 := newTestBlock(1, SHA256Type{})
+	bl.Append(block1)
+	bl.Append(newTestBlock(2, block1.Header.Id))
+	bl.Close()
+
+	// simulate a crash after the first bytes of the third record header
+	path := filepath.Join(dir, blockLogName)
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.Write([]byte{0, 0, 1})
+	file.Close()
+
+	bl, err := OpenBlockLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bl.Close()
+	if bl.TopBlockHeight() != 2 {
+		t.Fatal("the torn header should be dropped and the blocks kept")
+	}
+}
+
+func TestBlockLogRejectsCorruptedLength(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "blocklog")
+	defer os.RemoveAll(dir)
+	bl, _ := OpenBlockLog(dir)
+	parent := SHA256Type{}
+	for height := uint64(1); height <= 3; height++ {
+		block := newTestBlock(height, parent)
+		bl.Append(block)
+		parent = block.Header.Id
+	}
+	bl.Close()
+
+	// a length running past the end of the log must not pass for a torn tail
+	path := filepath.Join(dir, blockLogName)
+	data, _ := ioutil.ReadFile(path)
+	data[0] = 0x7f
+	ioutil.WriteFile(path, data, 0644)
+
+	if _, err := OpenBlockLog(dir); err == nil {
+		t.Fatal("a corrupted length should be an error")
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(len(data)) {
+		t.Fatal("the log shouldn't be truncated")
+	}
+}
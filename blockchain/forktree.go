@@ -0,0 +1,351 @@
+package blockchain
+
+import (
+	"fmt"
+	"sync"
+)
+
+type ForkChoice byte
+
+const (
+	LongestChain ForkChoice = iota // the branch with the highest head wins
+	HighestCommitted // the branch with the highest committed block wins, ties are broken by length
+)
+
+// ReorgEvent describes a change of the head of the tree.
+// Detached blocks are ordered from the old head down to the fork point,
+// attached blocks from the fork point up to the new head.
+// Extending the current head produces an event without detached blocks.
+type ReorgEvent struct {
+	Detached []*SignedBlock
+	Attached []*SignedBlock
+}
+
+// ReorgFunc is called with the events in the order of the changes, from the goroutine of the tree
+type ReorgFunc func(ReorgEvent)
+
+type blockNode struct {
+	block 		*SignedBlock // nil for the root
+	id 			SHA256Type
+	height 		uint64
+	parent 		*blockNode
+	children 	[]*blockNode
+	committed 	bool
+}
+
+// ForkTree keeps the reversible blocks on top of a root block (normally the last irreversible block)
+// and tracks every branch by PreviousId.
+type ForkTree struct {
+	root 		*blockNode
+	head 		*blockNode
+	nodes 		map[SHA256Type]*blockNode
+	forkChoice 	ForkChoice
+	onReorg 	ReorgFunc
+	events 		[]ReorgEvent // queued under the lock so they are delivered in the order of the changes
+	eventReady 	*sync.Cond
+	closed 		bool
+	mutex 		sync.Mutex
+}
+
+func NewForkTree(rootId SHA256Type, rootHeight uint64, forkChoice ForkChoice, onReorg ReorgFunc) *ForkTree {
+	root := &blockNode{
+		id: 		rootId,
+		height: 	rootHeight,
+		children: 	make([]*blockNode, 0),
+		committed: 	true,
+	}
+	tree := &ForkTree{
+		root: 		root,
+		head: 		root,
+		nodes: 		make(map[SHA256Type]*blockNode, 0),
+		forkChoice: forkChoice,
+		onReorg: 	onReorg,
+	}
+	tree.nodes[rootId] = root
+	tree.eventReady = sync.NewCond(&tree.mutex)
+	if onReorg != nil {
+		go tree.deliver()
+	}
+	return tree
+}
+
+// Close stops the delivery of the events, the ones not delivered yet are dropped
+func (tree *ForkTree) Close() {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	tree.closed = true
+	tree.eventReady.Broadcast()
+}
+
+// AddBlock links the block to its parent and re-applies the fork choice rule
+func (tree *ForkTree) AddBlock(block *SignedBlock) error {
+	tree.mutex.Lock()
+	header := block.Header
+	if _, ok := tree.nodes[header.Id]; ok {
+		tree.mutex.Unlock()
+		return fmt.Errorf("block already exists")
+	}
+	parent, ok := tree.nodes[header.PreviousId]
+	if !ok {
+		tree.mutex.Unlock()
+		return fmt.Errorf("previous block is unknown")
+	}
+	if header.Height != parent.height + 1 {
+		tree.mutex.Unlock()
+		return fmt.Errorf("expected height %d, got %d", parent.height + 1, header.Height)
+	}
+	node := &blockNode{
+		block: 		block,
+		id: 		header.Id,
+		height: 	header.Height,
+		parent: 	parent,
+		children: 	make([]*blockNode, 0),
+	}
+	parent.children = append(parent.children, node)
+	tree.nodes[node.id] = node
+	tree.updateHead()
+	tree.mutex.Unlock()
+	return nil
+}
+
+// MarkCommitted marks the block and its ancestors as committed, which may change the head
+// when the fork choice rule is HighestCommitted
+func (tree *ForkTree) MarkCommitted(id SHA256Type) error {
+	tree.mutex.Lock()
+	node, ok := tree.nodes[id]
+	if !ok {
+		tree.mutex.Unlock()
+		return fmt.Errorf("block doesn't exist")
+	}
+	for ; node != nil && !node.committed; node = node.parent {
+		node.committed = true
+	}
+	tree.updateHead()
+	tree.mutex.Unlock()
+	return nil
+}
+
+// Prune makes the block with id the new root and drops every branch that doesn't descend from it.
+// If the head was on a dropped branch, the best branch of the new root becomes the head.
+// The blocks between the old root and the new root are returned in ascending order.
+func (tree *ForkTree) Prune(id SHA256Type) ([]*SignedBlock, error) {
+	tree.mutex.Lock()
+	newRoot, ok := tree.nodes[id]
+	if !ok {
+		tree.mutex.Unlock()
+		return nil, fmt.Errorf("block doesn't exist")
+	}
+	if !tree.isAncestor(newRoot, tree.head) {
+		best := newRoot
+		for _, leaf := range tree.leaves(newRoot, make([]*blockNode, 0)) {
+			if tree.isBetter(leaf, best) {
+				best = leaf
+			}
+		}
+		tree.queue(tree.reorg(tree.head, best))
+		tree.head = best
+	}
+	pruned := make([]*SignedBlock, 0)
+	for node := newRoot; node != tree.root; node = node.parent {
+		pruned = append([]*SignedBlock{node.block}, pruned...)
+	}
+	tree.nodes = make(map[SHA256Type]*blockNode, 0)
+	tree.index(newRoot)
+	newRoot.parent = nil
+	newRoot.committed = true
+	tree.root = newRoot
+	tree.mutex.Unlock()
+	return pruned, nil
+}
+
+// Branch returns the blocks between the root and the block with id in ascending order
+func (tree *ForkTree) Branch(id SHA256Type) ([]*SignedBlock, error) {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	node, ok := tree.nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("block doesn't exist")
+	}
+	branch := make([]*SignedBlock, 0)
+	for ; node != tree.root; node = node.parent {
+		branch = append([]*SignedBlock{node.block}, branch...)
+	}
+	return branch, nil
+}
+
+// IsAncestor reports whether the block with id is the block with ancestorId or descends from it,
+// it's false when either block isn't in the tree
+func (tree *ForkTree) IsAncestor(ancestorId SHA256Type, id SHA256Type) bool {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	ancestor, ok := tree.nodes[ancestorId]
+	if !ok {
+		return false
+	}
+	node, ok := tree.nodes[id]
+	return ok && tree.isAncestor(ancestor, node)
+}
+
+func (tree *ForkTree) index(node *blockNode) {
+	tree.nodes[node.id] = node
+	for _, child := range node.children {
+		tree.index(child)
+	}
+}
+
+func (tree *ForkTree) Head() *SignedBlock {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	return tree.head.block
+}
+
+func (tree *ForkTree) HeadId() SHA256Type {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	return tree.head.id
+}
+
+func (tree *ForkTree) HeadHeight() uint64 {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	return tree.head.height
+}
+
+// HeadBranchBlock returns the block at height on the branch of the head
+func (tree *ForkTree) HeadBranchBlock(height uint64) (*SignedBlock, error) {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	node := tree.head
+	for node != nil && node.height > height {
+		node = node.parent
+	}
+	if node == nil || node.height != height || node.block == nil {
+		return nil, fmt.Errorf("block at height %d doesn't exist", height)
+	}
+	return node.block, nil
+}
+
+func (tree *ForkTree) RootId() SHA256Type {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	return tree.root.id
+}
+
+func (tree *ForkTree) HasBlock(id SHA256Type) bool {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	_, ok := tree.nodes[id]
+	return ok
+}
+
+func (tree *ForkTree) GetBlock(id SHA256Type) (*SignedBlock, error) {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	node, ok := tree.nodes[id]
+	if !ok || node.block == nil {
+		return nil, fmt.Errorf("block doesn't exist")
+	}
+	return node.block, nil
+}
+
+// updateHead finds the best leaf according to the fork choice rule and queues the event of the change.
+// The current head is kept unless another branch is strictly better.
+func (tree *ForkTree) updateHead() {
+	best := tree.head
+	for _, leaf := range tree.leaves(tree.root, make([]*blockNode, 0)) {
+		if tree.isBetter(leaf, best) {
+			best = leaf
+		}
+	}
+	if best == tree.head {
+		return
+	}
+	tree.queue(tree.reorg(tree.head, best))
+	tree.head = best
+}
+
+func (tree *ForkTree) leaves(node *blockNode, leaves []*blockNode) []*blockNode {
+	if len(node.children) == 0 {
+		return append(leaves, node)
+	}
+	for _, child := range node.children {
+		leaves = tree.leaves(child, leaves)
+	}
+	return leaves
+}
+
+func (tree *ForkTree) isBetter(a *blockNode, b *blockNode) bool {
+	if tree.forkChoice == HighestCommitted {
+		ca, cb := highestCommitted(a), highestCommitted(b)
+		if ca != cb {
+			return ca > cb
+		}
+	}
+	return a.height > b.height
+}
+
+func highestCommitted(node *blockNode) uint64 {
+	for ; node != nil; node = node.parent {
+		if node.committed {
+			return node.height
+		}
+	}
+	return 0
+}
+
+func (tree *ForkTree) isAncestor(ancestor *blockNode, node *blockNode) bool {
+	for ; node != nil; node = node.parent {
+		if node == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// reorg collects the blocks to detach from the old head and to attach up to the new head
+func (tree *ForkTree) reorg(oldHead *blockNode, newHead *blockNode) ReorgEvent {
+	event := ReorgEvent{
+		Detached: make([]*SignedBlock, 0),
+		Attached: make([]*SignedBlock, 0),
+	}
+	a, b := oldHead, newHead
+	for a != b {
+		if a.height >= b.height {
+			event.Detached = append(event.Detached, a.block)
+			a = a.parent
+		} else {
+			event.Attached = append([]*SignedBlock{b.block}, event.Attached...)
+			b = b.parent
+		}
+	}
+	return event
+}
+
+// queue adds the event for delivery, it's called with the lock held
+func (tree *ForkTree) queue(event ReorgEvent) {
+	if tree.onReorg == nil {
+		return
+	}
+	tree.events = append(tree.events, event)
+	tree.eventReady.Signal()
+}
+
+// deliver calls onReorg with the queued events one at a time. The callback runs outside of
+// the lock and off the stack of the caller that changed the tree, so it may use the tree.
+func (tree *ForkTree) deliver() {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	for {
+		for len(tree.events) == 0 && !tree.closed {
+			tree.eventReady.Wait()
+		}
+		if tree.closed {
+			return
+		}
+		event := tree.events[0]
+		tree.events = tree.events[1:]
+		tree.mutex.Unlock()
+		tree.onReorg(event)
+		tree.mutex.Lock()
+	}
+}
@@ -0,0 +1,166 @@
+package blockchain
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestBranchBlock(parent *SignedBlock, producer string) *SignedBlock {
+	block := &SignedBlock{}
+	block.Header.Height = parent.Header.Height + 1
+	block.Header.PreviousId = parent.Header.Id
+	block.Header.Producer = producer
+	block.Seal()
+	return block
+}
+
+// newTestTree returns a tree whose events are sent to the channel
+func newTestTree(forkChoice ForkChoice) (*ForkTree, chan ReorgEvent) {
+	events := make(chan ReorgEvent, 100)
+	tree := NewForkTree(SHA256Type{}, 0, forkChoice, func(event ReorgEvent) {
+		events <- event
+	})
+	return tree, events
+}
+
+func nextEvent(t *testing.T, events chan ReorgEvent) ReorgEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("event should be delivered")
+	}
+	return ReorgEvent{}
+}
+
+func TestForkTreeLongestChain(t *testing.T) {
+	tree, events := newTestTree(LongestChain)
+	defer tree.Close()
+	root := &SignedBlock{}
+	a1 := newTestBranchBlock(root, "a")
+	a2 := newTestBranchBlock(a1, "a")
+	b2 := newTestBranchBlock(a1, "b")
+	b3 := newTestBranchBlock(b2, "b")
+	for _, block := range []*SignedBlock{a1, a2, b2} {
+		if err := tree.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nextEvent(t, events)
+	nextEvent(t, events)
+	if tree.HeadId() != a2.Header.Id || len(events) != 0 {
+		t.Fatal("a block of the same height shouldn't replace the head")
+	}
+	tree.AddBlock(b3)
+	if tree.HeadId() != b3.Header.Id {
+		t.Fatal("the longer branch should become the head")
+	}
+	event := nextEvent(t, events)
+	if len(event.Detached) != 1 || event.Detached[0] != a2 {
+		t.Fatal("a2 should be detached")
+	}
+	if len(event.Attached) != 2 || event.Attached[0] != b2 || event.Attached[1] != b3 {
+		t.Fatal("b2 and b3 should be attached in order")
+	}
+	if !tree.IsAncestor(a1.Header.Id, b3.Header.Id) || tree.IsAncestor(a2.Header.Id, b3.Header.Id) {
+		t.Fatal("b3 should descend from a1 and not from a2")
+	}
+	orphan := newTestBranchBlock(newTestBranchBlock(b3, "c"), "c")
+	if err := tree.AddBlock(orphan); err == nil {
+		t.Fatal("a block with an unknown parent should be rejected")
+	}
+}
+
+func TestForkTreeHighestCommitted(t *testing.T) {
+	tree, events := newTestTree(HighestCommitted)
+	defer tree.Close()
+	root := &SignedBlock{}
+	a1 := newTestBranchBlock(root, "a")
+	a2 := newTestBranchBlock(a1, "a")
+	a3 := newTestBranchBlock(a2, "a")
+	b2 := newTestBranchBlock(a1, "b")
+	for _, block := range []*SignedBlock{a1, a2, a3, b2} {
+		tree.AddBlock(block)
+	}
+	if tree.HeadId() != a3.Header.Id {
+		t.Fatal("a3 should be the head")
+	}
+	for i := 0; i < 3; i++ {
+		nextEvent(t, events)
+	}
+	tree.MarkCommitted(b2.Header.Id)
+	if tree.HeadId() != b2.Header.Id {
+		t.Fatal("the branch with the highest committed block should become the head")
+	}
+	if last := nextEvent(t, events); len(last.Detached) != 2 || len(last.Attached) != 1 {
+		t.Fatal("a3 and a2 should be replaced by b2")
+	}
+	pruned, err := tree.Prune(b2.Header.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 2 || pruned[0] != a1 || pruned[1] != b2 {
+		t.Fatal("a1 and b2 should become irreversible")
+	}
+	if tree.HasBlock(a3.Header.Id) || tree.RootId() != b2.Header.Id {
+		t.Fatal("other branches should be dropped")
+	}
+}
+
+func TestForkTreePruneSwitchesBranch(t *testing.T) {
+	tree, events := newTestTree(LongestChain)
+	defer tree.Close()
+	root := &SignedBlock{}
+	a1 := newTestBranchBlock(root, "a")
+	a2 := newTestBranchBlock(a1, "a")
+	b1 := newTestBranchBlock(root, "b")
+	for _, block := range []*SignedBlock{a1, a2, b1} {
+		tree.AddBlock(block)
+	}
+	pruned, err := tree.Prune(b1.Header.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 1 || tree.HeadId() != b1.Header.Id {
+		t.Fatal("the pruned branch should be replaced by b1")
+	}
+	nextEvent(t, events)
+	nextEvent(t, events)
+	if last := nextEvent(t, events); len(last.Detached) != 2 || len(last.Attached) != 1 || last.Attached[0] != b1 {
+		t.Fatal("a2 and a1 should be detached")
+	}
+}
+
+func TestForkTreeEventOrder(t *testing.T) {
+	tree, events := newTestTree(LongestChain)
+	defer tree.Close()
+	root := &SignedBlock{}
+	var wg sync.WaitGroup
+	for _, producer := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(producer string) {
+			defer wg.Done()
+			parent := root
+			for i := 0; i < 20; i++ {
+				block := newTestBranchBlock(parent, producer)
+				tree.AddBlock(block)
+				parent = block
+			}
+		}(producer)
+	}
+	wg.Wait()
+	// every event starts from the head the previous event ended on
+	head := SHA256Type{}
+	for head != tree.HeadId() {
+		event := nextEvent(t, events)
+		from := event.Attached[0].Header.PreviousId
+		if len(event.Detached) > 0 {
+			from = event.Detached[0].Header.Id
+		}
+		if from != head {
+			t.Fatal("events should be delivered in the order of the changes")
+		}
+		head = event.Attached[len(event.Attached) - 1].Header.Id
+	}
+}
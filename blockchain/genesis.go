@@ -0,0 +1,148 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"fmt"
+	"os"
+	"time"
+	"consensus_layer/crypto"
+)
+
+const DefaultBlockInterval = 3000 // milliseconds
+const DefaultMaxBlockTransactions = 1000 // limits of the blocks of the chains created by NewGenesis
+const DefaultMaxBlockBytes = 1024 * 1024
+const MaxProducerAddressLength = 256
+
+// MaxBlockOverhead bounds the serialized size of a block beyond its transactions: the signed header,
+// whose producer address is at most MaxProducerAddressLength, and the length of the transaction list
+const MaxBlockOverhead = 1024
+
+type GenesisProducer struct {
+	Address 	string `json:"address"`
+	PublicKey 	string `json:"public_key"`
+}
+
+type ConsensusParams struct {
+	BlockInterval 			uint32 `json:"block_interval_ms"`
+	MaxBlockTransactions 	uint32 `json:"max_block_transactions"`
+	MaxBlockBytes 			uint32 `json:"max_block_bytes"`
+}
+
+// Genesis is the initial state that every node of a chain agrees on
+type Genesis struct {
+	ChainName 	string 				`json:"chain_name"`
+	Timestamp 	time.Time 			`json:"timestamp"`
+	Producers 	[]GenesisProducer 	`json:"producers"`
+	Consensus 	ConsensusParams 	`json:"consensus"`
+}
+
+// NewGenesis creates the genesis of a new chain whose producers get fresh keys. The private keys
+// are returned by producer address, each of them must only be given to its producer.
+func NewGenesis(chainName string, producers []string, timestamp time.Time) (*Genesis, map[string]*crypto.PrivateKey, error) {
+	genesis := &Genesis{
+		ChainName: 	chainName,
+		Timestamp: 	timestamp.UTC().Truncate(time.Second),
+		Producers: 	make([]GenesisProducer, 0, len(producers)),
+		Consensus: 	ConsensusParams{
+			BlockInterval: 			DefaultBlockInterval,
+			MaxBlockTransactions: 	DefaultMaxBlockTransactions,
+			MaxBlockBytes: 			DefaultMaxBlockBytes,
+		},
+	}
+	keys := make(map[string]*crypto.PrivateKey, len(producers))
+	for _, address := range producers {
+		privateKey, err := crypto.NewRandomPrivateKey()
+		if err != nil {
+			return nil, nil, err
+		}
+		keys[address] = privateKey
+		genesis.Producers = append(genesis.Producers, GenesisProducer{
+			Address: 	address,
+			PublicKey: 	privateKey.PublicKey().String(),
+		})
+	}
+	if err := genesis.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return genesis, keys, nil
+}
+
+// Save writes the genesis to a new file at path, an existing genesis is never overwritten
+func (genesis *Genesis) Save(path string) error {
+	data, err := json.MarshalIndent(genesis, "", "    ")
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func LoadGenesis(path string) (*Genesis, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGenesis(data)
+}
+
+func ParseGenesis(data []byte) (*Genesis, error) {
+	genesis := &Genesis{}
+	if err := json.Unmarshal(data, genesis); err != nil {
+		return nil, err
+	}
+	if genesis.Consensus.BlockInterval == 0 {
+		genesis.Consensus.BlockInterval = DefaultBlockInterval
+	}
+	if err := genesis.Validate(); err != nil {
+		return nil, err
+	}
+	return genesis, nil
+}
+
+func (genesis *Genesis) Validate() error {
+	if genesis.ChainName == "" {
+		return fmt.Errorf("chain name is empty")
+	}
+	if genesis.Timestamp.IsZero() {
+		return fmt.Errorf("genesis timestamp is missing")
+	}
+	if len(genesis.Producers) == 0 {
+		return fmt.Errorf("producer set is empty")
+	}
+	addresses := make(map[string]bool, 0)
+	for _, producer := range genesis.Producers {
+		if producer.Address == "" {
+			return fmt.Errorf("producer address is empty")
+		}
+		if len(producer.Address) > MaxProducerAddressLength {
+			return fmt.Errorf("producer address %s is longer than %d bytes", producer.Address, MaxProducerAddressLength)
+		}
+		if addresses[producer.Address] {
+			return fmt.Errorf("duplicated producer %s", producer.Address)
+		}
+		addresses[producer.Address] = true
+		if _, err := crypto.NewPublicKey(producer.PublicKey); err != nil {
+			return fmt.Errorf("invalid public key of producer %s: %s", producer.Address, err)
+		}
+	}
+	return nil
+}
+
+// ChainId is the digest of the canonical serialization of the genesis,
+// so it doesn't depend on the formatting of the file
+func (genesis *Genesis) ChainId() SHA256Type {
+	chainId, _ := Digest(*genesis)
+	return chainId
+}
+
+func (genesis *Genesis) BlockInterval() time.Duration {
+	return time.Duration(genesis.Consensus.BlockInterval) * time.Millisecond
+}
@@ -0,0 +1,81 @@
+package blockchain
+
+import (
+	"testing"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+	"consensus_layer/crypto"
+)
+
+func newTestGenesisJSON(publicKey string, indent string) []byte {
+	return []byte(fmt.Sprintf(`{
+%[2]s"chain_name": "testnet",
+%[2]s"timestamp": "2018-06-01T00:00:00Z",
+%[2]s"producers": [{"address": "producer1", "public_key": "%[1]s"}],
+%[2]s"consensus": {"block_interval_ms": 500}
+}`, publicKey, indent))
+}
+
+func TestGenesis(t *testing.T) {
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	publicKey := privateKey.PublicKey().String()
+	genesis1, err := ParseGenesis(newTestGenesisJSON(publicKey, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis2, err := ParseGenesis(newTestGenesisJSON(publicKey, "    "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if genesis1.ChainId() != genesis2.ChainId() {
+		t.Fatal("chain id shouldn't depend on formatting")
+	}
+	if genesis1.BlockInterval().Seconds() != 0.5 {
+		t.Fatal("block interval should be 500ms")
+	}
+	otherKey, _ := crypto.NewRandomPrivateKey()
+	genesis3, _ := ParseGenesis(newTestGenesisJSON(otherKey.PublicKey().String(), ""))
+	if genesis3.ChainId() == genesis1.ChainId() {
+		t.Fatal("chain id should depend on the producer set")
+	}
+	if _, err := ParseGenesis(newTestGenesisJSON("invalid", "")); err == nil {
+		t.Fatal("invalid public key should be rejected")
+	}
+}
+
+func TestNewGenesis(t *testing.T) {
+	dir, err := ioutil.TempDir("", "genesis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	genesis, keys, err := NewGenesis("mychain", []string{"producer1", "producer2"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "genesis.json")
+	if err := genesis.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := genesis.Save(path); err == nil {
+		t.Fatal("existing genesis shouldn't be overwritten")
+	}
+	loaded, err := LoadGenesis(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ChainId() != genesis.ChainId() || len(loaded.Producers) != 2 {
+		t.Fatal("saved genesis should be loaded unchanged")
+	}
+	for _, producer := range loaded.Producers {
+		if key := keys[producer.Address]; key == nil || key.PublicKey().String() != producer.PublicKey {
+			t.Fatal("every producer should get the key matching its public key")
+		}
+	}
+	if _, _, err := NewGenesis("mychain", []string{"producer1", "producer1"}, time.Now()); err == nil {
+		t.Fatal("duplicated producer should be rejected")
+	}
+}
@@ -0,0 +1,149 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// prefixes separate leaves from inner nodes so that an inner node can't be passed off as a leaf
+const (
+	merkleLeafPrefix byte = 0
+	merkleNodePrefix byte = 1
+	merkleRootPrefix byte = 2
+)
+
+func merkleLeaf(hash SHA256Type) SHA256Type {
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, hash[:]...))
+}
+
+func merkleNode(left SHA256Type, right SHA256Type) SHA256Type {
+	buf := make([]byte, 0, 1 + len(left) + len(right))
+	buf = append(buf, merkleNodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+	return sha256.Sum256(buf)
+}
+
+// merkleRoot commits the top of the tree to its number of leaves. The leaf count fixes the shape of
+// the tree, so the sibling path of a proof also fixes the position of its leaf.
+func merkleRoot(leafCount uint32, top SHA256Type) SHA256Type {
+	buf := make([]byte, 1 + 4, 1 + 4 + len(top))
+	buf[0] = merkleRootPrefix
+	binary.BigEndian.PutUint32(buf[1:], leafCount)
+	buf = append(buf, top[:]...)
+	return sha256.Sum256(buf)
+}
+
+// MerkleTree keeps every level of the tree so that inclusion proofs can be produced.
+// The last node of a level with an odd number of nodes is promoted to the next level unchanged.
+type MerkleTree struct {
+	levels [][]SHA256Type // levels[0] are the hashed leaves, the last level is the top the root commits to
+}
+
+// MerkleProof proves that Leaf is the Index-th of LeafCount leaves of a merkle tree, the root
+// binds all three
+type MerkleProof struct {
+	Index 		uint32
+	LeafCount 	uint32
+	Leaf 		SHA256Type
+	Siblings 	[]SHA256Type // from the bottom level to the top
+}
+
+func NewMerkleTree(hashes []SHA256Type) *MerkleTree {
+	tree := &MerkleTree{
+		levels: make([][]SHA256Type, 0),
+	}
+	if len(hashes) == 0 {
+		return tree
+	}
+	level := make([]SHA256Type, len(hashes))
+	for i, hash := range hashes {
+		level[i] = merkleLeaf(hash)
+	}
+	tree.levels = append(tree.levels, level)
+	for len(level) > 1 {
+		next := make([]SHA256Type, 0, (len(level) + 1) / 2)
+		for i := 0; i < len(level); i += 2 {
+			if i + 1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, merkleNode(level[i], level[i+1]))
+			}
+		}
+		tree.levels = append(tree.levels, next)
+		level = next
+	}
+	return tree
+}
+
+// Root returns the root of the tree, the root of an empty tree is the zero hash
+func (tree *MerkleTree) Root() SHA256Type {
+	if len(tree.levels) == 0 {
+		return SHA256Type{}
+	}
+	return merkleRoot(uint32(tree.LeafCount()), tree.levels[len(tree.levels)-1][0])
+}
+
+func (tree *MerkleTree) LeafCount() int {
+	if len(tree.levels) == 0 {
+		return 0
+	}
+	return len(tree.levels[0])
+}
+
+// Proof builds the inclusion proof of the leaf at index, leaf is the unhashed leaf value
+func (tree *MerkleTree) Proof(index int, leaf SHA256Type) (*MerkleProof, error) {
+	if index < 0 || index >= tree.LeafCount() {
+		return nil, fmt.Errorf("leaf %d doesn't exist", index)
+	}
+	if tree.levels[0][index] != merkleLeaf(leaf) {
+		return nil, fmt.Errorf("leaf %d doesn't match", index)
+	}
+	proof := &MerkleProof{
+		Index: 		uint32(index),
+		LeafCount: 	uint32(tree.LeafCount()),
+		Leaf: 		leaf,
+		Siblings: 	make([]SHA256Type, 0),
+	}
+	for _, level := range tree.levels[:len(tree.levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof.Siblings = append(proof.Siblings, level[sibling])
+		}
+		index /= 2
+	}
+	return proof, nil
+}
+
+// Verify recomputes the root from the leaf, its position and its siblings and compares it with root
+func (proof *MerkleProof) Verify(root SHA256Type) bool {
+	if proof.Index >= proof.LeafCount {
+		return false
+	}
+	hash := merkleLeaf(proof.Leaf)
+	index, count := proof.Index, proof.LeafCount
+	siblings := proof.Siblings
+	for count > 1 {
+		// the last node of an odd level is promoted without a sibling
+		if !(index == count - 1 && count % 2 == 1) {
+			if len(siblings) == 0 {
+				return false
+			}
+			if index % 2 == 0 {
+				hash = merkleNode(hash, siblings[0])
+			} else {
+				hash = merkleNode(siblings[0], hash)
+			}
+			siblings = siblings[1:]
+		}
+		index /= 2
+		count = (count + 1) / 2
+	}
+	return len(siblings) == 0 && merkleRoot(proof.LeafCount, hash) == root
+}
+
+// MerkleRoot computes the root of the merkle tree whose leaves are the given hashes
+func MerkleRoot(hashes []SHA256Type) SHA256Type {
+	return NewMerkleTree(hashes).Root()
+}
@@ -0,0 +1,70 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"reflect"
+	"fmt"
+	"consensus_layer/serializer"
+	"consensus_layer/crypto"
+)
+
+var signatureType = reflect.TypeOf(crypto.Signature{})
+
+// Digest is the sha256 hash of the canonical binary serialization of v
+func Digest(v interface{}) (SHA256Type, error) {
+	buf, err := serializer.MarshalBinary(v)
+	if err != nil {
+		return SHA256Type{}, err
+	}
+	return sha256.Sum256(buf), nil
+}
+
+// SigningDigest is the digest of a signed struct with its own signature fields zeroed.
+// Only top-level fields are zeroed, signatures of nested messages stay covered.
+func SigningDigest(v interface{}) (SHA256Type, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return SHA256Type{}, fmt.Errorf("wrong type: %s", rv.Type().String())
+	}
+	unsigned := reflect.New(rv.Type()).Elem()
+	unsigned.Set(rv)
+	for i := 0; i < unsigned.NumField(); i++ {
+		field := unsigned.Field(i)
+		if field.Type() == signatureType && field.CanSet() {
+			field.Set(reflect.Zero(signatureType))
+		}
+	}
+	return Digest(unsigned.Interface())
+}
+
+// SignHeader derives the id of the header and signs it with the producer's private key
+func SignHeader(header BlockHeader, privateKey *crypto.PrivateKey) (SignedHeader, error) {
+	id, err := header.CalculateId()
+	if err != nil {
+		return SignedHeader{}, err
+	}
+	header.Id = id
+	sig, err := privateKey.Sign(id[:])
+	if err != nil {
+		return SignedHeader{}, err
+	}
+	return SignedHeader{
+		Header: 	header,
+		Signature: 	sig,
+	}, nil
+}
+
+// Verify checks that the id matches the header and that it was signed by the producer
+func (signedHeader *SignedHeader) Verify(producerKey crypto.PublicKey) error {
+	id, err := signedHeader.Header.CalculateId()
+	if err != nil {
+		return err
+	}
+	if id != signedHeader.Header.Id {
+		return fmt.Errorf("block id doesn't match the header")
+	}
+	if !signedHeader.Signature.Verify(producerKey, id[:]) {
+		return fmt.Errorf("invalid signature of producer %s", signedHeader.Header.Producer)
+	}
+	return nil
+}
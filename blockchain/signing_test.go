@@ -0,0 +1,58 @@
+package blockchain
+
+import (
+	"testing"
+	"consensus_layer/crypto"
+)
+
+type signedMessage struct {
+	Term 		uint64
+	Nested 		[]Commit
+	Signature 	crypto.Signature
+}
+
+func TestSigningDigest(t *testing.T) {
+	message := signedMessage{
+		Term: 		1,
+		Nested: 	[]Commit{{Signature: crypto.Signature{Data: []byte{1}}}},
+		Signature: 	crypto.Signature{Data: []byte{2}},
+	}
+	digest1, err := SigningDigest(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message.Signature = crypto.Signature{}
+	digest2, _ := SigningDigest(&message)
+	if digest1 != digest2 {
+		t.Fatal("the signature of the message shouldn't be covered")
+	}
+	message.Nested[0].Signature = crypto.Signature{}
+	digest3, _ := SigningDigest(message)
+	if digest3 == digest2 {
+		t.Fatal("signatures of nested messages should be covered")
+	}
+}
+
+func TestSignHeader(t *testing.T) {
+	producerKey, _ := crypto.NewRandomPrivateKey()
+	otherKey, _ := crypto.NewRandomPrivateKey()
+	header := newTestBlock(1, SHA256Type{}).Header
+	header.Id = SHA256Type{}
+	signedHeader, err := SignHeader(header, producerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signedHeader.Header.Id == (SHA256Type{}) {
+		t.Fatal("id should be derived")
+	}
+	if err := signedHeader.Verify(*producerKey.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+	if err := signedHeader.Verify(*otherKey.PublicKey()); err == nil {
+		t.Fatal("header isn't signed by this key")
+	}
+	signedHeader.Header.Height = 2
+	if err := signedHeader.Verify(*producerKey.PublicKey()); err == nil {
+		t.Fatal("id should cover the height")
+	}
+}
@@ -20,6 +20,9 @@ type BlockHeader struct {
 	PreviousId SHA256Type
 	Producer string
 	Timestamp time.Time
+	TransactionRoot SHA256Type // merkle root of the transactions in the block
+	AppHash SHA256Type // application state hash after executing the block at AppHeight
+	AppHeight uint64 // last irreversible block of the producer when it produced the block
 }
 
 type SignedHeader struct {
@@ -28,13 +31,21 @@ type SignedHeader struct {
 }
 
 type SignedBlock struct {
-	signedHeader SignedHeader
-	//transactions []Transaction
+	SignedHeader
+	Transactions []Transaction
+}
+
+type Transaction struct {
+	Payload []byte
+	Sender crypto.PublicKey
+	Nonce uint64
+	Signature crypto.Signature
 }
 
 type Commit struct {
 	Type CommitType
 	BlockId SHA256Type
+	Height uint64 // height of the block, a producer signs each phase of a height only once
 	Committer string
 	Timestamp time.Time
 	Signature crypto.Signature
@@ -0,0 +1,442 @@
+package consensus
+
+import (
+	"consensus_layer/network"
+	"consensus_layer/blockchain"
+	"consensus_layer/crypto"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+type FinalizeFunc func(blockId blockchain.SHA256Type) error
+
+// MaxCommitLookahead is how far above the last irreversible block commits are kept,
+// each producer can vote for one block per height and phase below it
+const MaxCommitLookahead = 1024
+
+// CommitManager runs the two commit phases of a block.
+// A producer pre-commits every block that becomes part of its head branch,
+// commits a block once more than 2/3 of the producers pre-committed it,
+// and the block is finalized once more than 2/3 of the producers committed it.
+// A producer signs each phase of a height for one block only and never goes back to a lower
+// height. Once it committed a block it only signs the blocks that descend from it until a
+// block at that height is finalized, so two conflicting blocks can't both reach a quorum of
+// commits while less than 1/3 of the producers are faulty. The locks are saved before a
+// commit is sent so they survive restarts.
+type CommitManager struct {
+	signer 		network.SignFunc
+	address 	string
+	producers 	[]Producer
+	broadcast 	network.BroadcastFunc
+	finalize 	FinalizeFunc
+	votes 		map[blockchain.CommitType]map[uint64]map[blockchain.SHA256Type]map[string]blockchain.Commit // [type][height][block id][committer]
+	locks 		map[blockchain.CommitType]commitLock // highest block this producer signed in each phase
+	lockPath 	string // the locks are only kept in memory without it
+	chain 		Chain
+	committed 	map[uint64]blockchain.SHA256Type // blocks with a quorum of commits that couldn't be finalized yet
+	evidence 	[]blockchain.Commit // quorum of commits of the highest committed block, served to the peers that sync
+	finalizedHeight uint64
+	mutex 		sync.Mutex
+}
+
+// commitLock is the block a producer signed at its highest height
+type commitLock struct {
+	height 	uint64
+	blockId blockchain.SHA256Type
+}
+
+// savedLock is the form of a commit lock on disk
+type savedLock struct {
+	Type 	blockchain.CommitType
+	Height 	uint64
+	BlockId blockchain.SHA256Type
+}
+
+// Chain tells how the reversible blocks are linked
+type Chain interface {
+	IsAncestor(ancestorId blockchain.SHA256Type, id blockchain.SHA256Type) bool
+}
+
+func NewCommitManager(signer network.SignFunc, address string, broadcast network.BroadcastFunc, finalize FinalizeFunc) *CommitManager {
+	cm := &CommitManager{
+		signer: 	signer,
+		address: 	address,
+		broadcast: 	broadcast,
+		finalize: 	finalize,
+		votes: 		make(map[blockchain.CommitType]map[uint64]map[blockchain.SHA256Type]map[string]blockchain.Commit, 0),
+		locks: 		make(map[blockchain.CommitType]commitLock, 0),
+		committed: 	make(map[uint64]blockchain.SHA256Type, 0),
+	}
+	for _, commitType := range []blockchain.CommitType{blockchain.PreCommitment, blockchain.Commitment} {
+		cm.votes[commitType] = make(map[uint64]map[blockchain.SHA256Type]map[string]blockchain.Commit, 0)
+	}
+	return cm
+}
+
+// commit manager inherit base manager interface
+var _ network.BaseManager = (*CommitManager)(nil)
+
+func (cm *CommitManager) SetProducers(producers []Producer) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.producers = producers
+}
+
+// SetChain sets the blocks the commit lock is checked against, without it no block is signed
+// while the producer is locked on a committed block
+func (cm *CommitManager) SetChain(chain Chain) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.chain = chain
+}
+
+// LoadLocks restores the locks saved in the file at path and saves them there from now on
+func (cm *CommitManager) LoadLocks(path string) error {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.lockPath = path
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	locks := make([]savedLock, 0)
+	if err := json.Unmarshal(data, &locks); err != nil {
+		return err
+	}
+	for _, lock := range locks {
+		cm.locks[lock.Type] = commitLock{height: lock.Height, blockId: lock.BlockId}
+	}
+	return nil
+}
+
+// saveLocks writes the locks to disk and syncs them, it's called with the mutex held
+func (cm *CommitManager) saveLocks() error {
+	if cm.lockPath == "" {
+		return nil
+	}
+	locks := make([]savedLock, 0, len(cm.locks))
+	for commitType, lock := range cm.locks {
+		locks = append(locks, savedLock{Type: commitType, Height: lock.height, BlockId: lock.blockId})
+	}
+	data, err := json.Marshal(locks)
+	if err != nil {
+		return err
+	}
+	tmp := cm.lockPath + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cm.lockPath)
+}
+
+func (cm *CommitManager) MessageTypes() []network.MessageType {
+	return []network.MessageType{network.Commit}
+}
+
+func (cm *CommitManager) Start() {}
+
+func (cm *CommitManager) Stop() {}
+
+func (cm *CommitManager) PeerConnected(conn *network.Connection) {}
+
+func (cm *CommitManager) PeerDisconnected(conn *network.Connection) {}
+
+func (cm *CommitManager) Receive(conn *network.Connection, message network.Message) error {
+	payload, err := network.DecodeMessage(message)
+	if err != nil {
+		fmt.Println(err)
+		err = network.Misbehavior(network.MalformedMessage, "%s", err)
+		conn.Misbehave(err)
+		return err
+	}
+	switch packet := payload.(type) {
+	case blockchain.Commit:
+		if err := cm.receivedCommit(packet); err != nil {
+			fmt.Println("commit is rejected: ", err)
+			conn.Misbehave(err)
+			return err
+		}
+	default:
+		break
+	}
+	return nil
+}
+
+func (cm *CommitManager) Send(conn *network.Connection, messageType network.MessageType) {
+	switch messageType {
+	case network.Commit:
+		fmt.Println("commit")
+	default:
+		break
+	}
+}
+
+// PreCommit is called when a block becomes part of the head branch of this node
+func (cm *CommitManager) PreCommit(blockId blockchain.SHA256Type, height uint64) {
+	cm.mutex.Lock()
+	commit, ok := cm.sign(blockchain.PreCommitment, blockId, height)
+	cm.mutex.Unlock()
+	if ok {
+		cm.receivedCommit(commit)
+		cm.broadcast(commit)
+	}
+}
+
+// sign creates the commit of this node. A phase is signed only above the height this producer
+// last signed in it, after a reorg the blocks at or below that height keep the vote they had.
+// Neither phase is signed for a block that doesn't descend from the block this producer
+// committed last, unless that block is already irreversible.
+func (cm *CommitManager) sign(commitType blockchain.CommitType, blockId blockchain.SHA256Type, height uint64) (blockchain.Commit, bool) {
+	if cm.producerKey(cm.address) == nil || height <= cm.finalizedHeight {
+		return blockchain.Commit{}, false
+	}
+	if lock, ok := cm.locks[commitType]; ok && height <= lock.height {
+		if height == lock.height && blockId != lock.blockId {
+			fmt.Println("block ", height, " isn't signed, this producer is locked on another block at that height")
+		}
+		return blockchain.Commit{}, false
+	}
+	if lock, ok := cm.locks[blockchain.Commitment]; ok && lock.height > cm.finalizedHeight {
+		if cm.chain == nil || !cm.chain.IsAncestor(lock.blockId, blockId) {
+			fmt.Println("block ", height, " isn't signed, it doesn't descend from the committed block ", lock.height)
+			return blockchain.Commit{}, false
+		}
+	}
+	commit := blockchain.Commit{
+		Type: 		commitType,
+		BlockId: 	blockId,
+		Height: 	height,
+		Committer: 	cm.address,
+		Timestamp: 	time.Now(),
+		Signature: 	crypto.Signature{},
+	}
+	previous, locked := cm.locks[commitType]
+	cm.locks[commitType] = commitLock{height: height, blockId: blockId}
+	if err := cm.saveLocks(); err != nil {
+		fmt.Println("block ", height, " isn't signed, the commit lock can't be saved: ", err)
+		if locked {
+			cm.locks[commitType] = previous
+		} else {
+			delete(cm.locks, commitType)
+		}
+		return blockchain.Commit{}, false
+	}
+	hash, _ := blockchain.SigningDigest(commit)
+	commit.Signature = cm.signer(hash)
+	return commit, true
+}
+
+func (cm *CommitManager) receivedCommit(commit blockchain.Commit) error {
+	cm.mutex.Lock()
+	if commit.Height <= cm.finalizedHeight {
+		cm.mutex.Unlock()
+		return nil
+	}
+	if err := cm.verifyCommit(commit); err != nil {
+		cm.mutex.Unlock()
+		return err
+	}
+	if commit.Height > cm.finalizedHeight + MaxCommitLookahead {
+		cm.mutex.Unlock()
+		return fmt.Errorf("commit of height %d is too far above the last irreversible block %d", commit.Height, cm.finalizedHeight)
+	}
+	blocks, ok := cm.votes[commit.Type][commit.Height]
+	if !ok {
+		blocks = make(map[blockchain.SHA256Type]map[string]blockchain.Commit, 0)
+		cm.votes[commit.Type][commit.Height] = blocks
+	}
+	for id, votes := range blocks {
+		if _, ok := votes[commit.Committer]; ok && id != commit.BlockId {
+			// anyone may relay both signatures, so only the committer is at fault
+			cm.mutex.Unlock()
+			return fmt.Errorf("%s signed two blocks at height %d", commit.Committer, commit.Height)
+		}
+	}
+	votes, ok := blocks[commit.BlockId]
+	if !ok {
+		votes = make(map[string]blockchain.Commit, 0)
+		blocks[commit.BlockId] = votes
+	}
+	if _, ok := votes[commit.Committer]; ok {
+		cm.mutex.Unlock()
+		return nil
+	}
+	votes[commit.Committer] = commit
+	if !cm.hasQuorum(len(votes)) {
+		cm.mutex.Unlock()
+		return nil
+	}
+	switch commit.Type {
+	case blockchain.PreCommitment:
+		next, ok := cm.sign(blockchain.Commitment, commit.BlockId, commit.Height)
+		cm.mutex.Unlock()
+		if ok {
+			cm.broadcast(next)
+			return cm.receivedCommit(next)
+		}
+		return nil
+	case blockchain.Commitment:
+		quorum := make([]blockchain.Commit, 0, len(votes))
+		for _, vote := range votes {
+			quorum = append(quorum, vote)
+		}
+		cm.committedBlock(quorum)
+		cm.mutex.Unlock()
+		if err := cm.tryFinalize(commit.BlockId, commit.Height); err != nil {
+			fmt.Println("committed block ", commit.Height, " isn't finalized yet: ", err)
+		}
+		return nil
+	}
+	cm.mutex.Unlock()
+	return nil
+}
+
+// committedBlock records the block of a quorum of commits until it's finalized
+func (cm *CommitManager) committedBlock(quorum []blockchain.Commit) {
+	commit := quorum[0]
+	cm.committed[commit.Height] = commit.BlockId
+	if len(cm.evidence) == 0 || commit.Height > cm.evidence[0].Height {
+		cm.evidence = quorum
+	}
+}
+
+// tryFinalize finalizes a committed block, it stays committed until it can be finalized
+func (cm *CommitManager) tryFinalize(blockId blockchain.SHA256Type, height uint64) error {
+	if err := cm.finalize(blockId); err != nil {
+		return err
+	}
+	cm.Finalized(height)
+	return nil
+}
+
+// Evidence returns the commits proving that the highest committed block known to this node is irreversible
+func (cm *CommitManager) Evidence() []blockchain.Commit {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	return cm.evidence
+}
+
+// VerifyEvidence checks that the commits are a quorum of commitments of one block and returns that block.
+// The block is recorded as committed, Retry finalizes it once it's known, and the evidence is served to the peers.
+func (cm *CommitManager) VerifyEvidence(commits []blockchain.Commit) (blockchain.SHA256Type, uint64, error) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	if len(commits) == 0 {
+		return blockchain.SHA256Type{}, 0, fmt.Errorf("no commit evidence")
+	}
+	if len(commits) > len(cm.producers) {
+		return blockchain.SHA256Type{}, 0, network.Misbehavior(network.OversizedMessage, "%d commits for %d producers", len(commits), len(cm.producers))
+	}
+	first := commits[0]
+	committers := make(map[string]bool, 0)
+	for _, commit := range commits {
+		if commit.Type != blockchain.Commitment || commit.BlockId != first.BlockId || commit.Height != first.Height {
+			return blockchain.SHA256Type{}, 0, network.Misbehavior(network.ProtocolViolation, "commit evidence mixes several blocks")
+		}
+		if committers[commit.Committer] {
+			return blockchain.SHA256Type{}, 0, network.Misbehavior(network.ProtocolViolation, "commit evidence counts %s twice", commit.Committer)
+		}
+		if err := cm.verifyCommit(commit); err != nil {
+			return blockchain.SHA256Type{}, 0, err
+		}
+		committers[commit.Committer] = true
+	}
+	if !cm.hasQuorum(len(committers)) {
+		return blockchain.SHA256Type{}, 0, network.Misbehavior(network.ProtocolViolation, "%d commits aren't a quorum", len(committers))
+	}
+	if first.Height > cm.finalizedHeight {
+		cm.committedBlock(commits)
+	}
+	return first.BlockId, first.Height, nil
+}
+
+// Retry finalizes the committed blocks that arrived since their quorum was reached,
+// the highest one first as it finalizes its ancestors too
+func (cm *CommitManager) Retry() {
+	cm.mutex.Lock()
+	heights := make([]uint64, 0, len(cm.committed))
+	for height := range cm.committed {
+		heights = append(heights, height)
+	}
+	cm.mutex.Unlock()
+	sort.Slice(heights, func(i, j int) bool {
+		return heights[i] > heights[j]
+	})
+	for _, height := range heights {
+		cm.mutex.Lock()
+		blockId, ok := cm.committed[height]
+		cm.mutex.Unlock()
+		if ok && cm.tryFinalize(blockId, height) == nil {
+			return
+		}
+	}
+}
+
+// Finalized forgets the votes up to the last irreversible block
+func (cm *CommitManager) Finalized(height uint64) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	if height <= cm.finalizedHeight {
+		return
+	}
+	cm.finalizedHeight = height
+	for _, commitType := range []blockchain.CommitType{blockchain.PreCommitment, blockchain.Commitment} {
+		for h := range cm.votes[commitType] {
+			if h <= height {
+				delete(cm.votes[commitType], h)
+			}
+		}
+	}
+	for h := range cm.committed {
+		if h <= height {
+			delete(cm.committed, h)
+		}
+	}
+}
+
+func (cm *CommitManager) verifyCommit(commit blockchain.Commit) error {
+	if commit.Type != blockchain.PreCommitment && commit.Type != blockchain.Commitment {
+		return network.Misbehavior(network.MalformedMessage, "unknown commit type %d", commit.Type)
+	}
+	pub := cm.producerKey(commit.Committer)
+	if pub == nil {
+		return network.Misbehavior(network.InvalidSignature, "%s isn't a producer", commit.Committer)
+	}
+	if !verifySignature(commit, commit.Signature, pub) {
+		return network.Misbehavior(network.InvalidSignature, "invalid signature of %s", commit.Committer)
+	}
+	return nil
+}
+
+func (cm *CommitManager) hasQuorum(votes int) bool {
+	return len(cm.producers) > 0 && votes > len(cm.producers) * 2/3
+}
+
+func (cm *CommitManager) producerKey(address string) *crypto.PublicKey {
+	for _, p := range cm.producers {
+		if p.Address == address {
+			return p.PublicKey
+		}
+	}
+	return nil
+}
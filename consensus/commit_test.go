@@ -0,0 +1,317 @@
+package consensus
+
+import (
+	"testing"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"consensus_layer/blockchain"
+	"consensus_layer/crypto"
+	"consensus_layer/network"
+)
+
+func newTestProducers(t *testing.T, n int) ([]Producer, []*crypto.PrivateKey) {
+	producers := make([]Producer, n)
+	keys := make([]*crypto.PrivateKey, n)
+	for i := 0; i < n; i++ {
+		privateKey, err := crypto.NewRandomPrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = privateKey
+		producers[i] = Producer{
+			Address: 	fmt.Sprintf("producer%d", i),
+			PublicKey: 	privateKey.PublicKey(),
+		}
+	}
+	return producers, keys
+}
+
+func newTestSigner(privateKey *crypto.PrivateKey) func(hash blockchain.SHA256Type) crypto.Signature {
+	return func(hash blockchain.SHA256Type) crypto.Signature {
+		sig, _ := privateKey.Sign(hash[:])
+		return sig
+	}
+}
+
+func TestCommitQuorum(t *testing.T) {
+	producers, keys := newTestProducers(t, 4)
+	managers := make([]*CommitManager, len(producers))
+	finalized := make([]int, len(producers))
+	online := 3
+	for i := range producers {
+		i := i
+		broadcast := func(packet interface{}) {
+			for j := 0; j < online; j++ {
+				if j != i {
+					managers[j].receivedCommit(packet.(blockchain.Commit))
+				}
+			}
+		}
+		finalize := func(blockId blockchain.SHA256Type) error {
+			finalized[i]++
+			return nil
+		}
+		managers[i] = NewCommitManager(newTestSigner(keys[i]), producers[i].Address, broadcast, finalize)
+		managers[i].SetProducers(producers)
+	}
+	blockId := blockchain.SHA256Type{1}
+	managers[0].PreCommit(blockId, 1)
+	managers[1].PreCommit(blockId, 1)
+	for i := 0; i < online; i++ {
+		if finalized[i] != 0 {
+			t.Fatal("2 of 4 pre-commits shouldn't be enough")
+		}
+	}
+	managers[2].PreCommit(blockId, 1)
+	for i := 0; i < online; i++ {
+		if finalized[i] != 1 {
+			t.Fatalf("producer %d should finalize the block once", i)
+		}
+	}
+
+	forged := blockchain.Commit{
+		Type: 		blockchain.Commitment,
+		BlockId: 	blockchain.SHA256Type{2},
+		Height: 	2,
+		Committer: 	producers[1].Address,
+	}
+	hash, _ := blockchain.SigningDigest(forged)
+	forged.Signature, _ = keys[3].Sign(hash[:])
+	if err := managers[0].receivedCommit(forged); err == nil {
+		t.Fatal("a commit signed by another producer should be rejected")
+	}
+}
+
+func TestCommitLock(t *testing.T) {
+	producers, keys := newTestProducers(t, 4)
+	sent := make([]blockchain.Commit, 0)
+	broadcast := func(packet interface{}) {
+		sent = append(sent, packet.(blockchain.Commit))
+	}
+	finalize := func(blockId blockchain.SHA256Type) error {
+		return nil
+	}
+	cm := NewCommitManager(newTestSigner(keys[0]), producers[0].Address, broadcast, finalize)
+	cm.SetProducers(producers)
+
+	cm.PreCommit(blockchain.SHA256Type{1}, 5)
+	// after a reorg, the conflicting blocks at or below the locked height keep no vote
+	cm.PreCommit(blockchain.SHA256Type{2}, 5)
+	cm.PreCommit(blockchain.SHA256Type{3}, 4)
+	if len(sent) != 1 || sent[0].BlockId != (blockchain.SHA256Type{1}) || sent[0].Height != 5 {
+		t.Fatal("producer should pre-commit one block per height")
+	}
+	cm.PreCommit(blockchain.SHA256Type{4}, 6)
+	if len(sent) != 2 {
+		t.Fatal("producer should pre-commit above the locked height")
+	}
+
+	signed := func(key int, blockId blockchain.SHA256Type, height uint64) blockchain.Commit {
+		commit := blockchain.Commit{
+			Type: 		blockchain.PreCommitment,
+			BlockId: 	blockId,
+			Height: 	height,
+			Committer: 	producers[key].Address,
+		}
+		hash, _ := blockchain.SigningDigest(commit)
+		commit.Signature, _ = keys[key].Sign(hash[:])
+		return commit
+	}
+	if err := cm.receivedCommit(signed(1, blockchain.SHA256Type{7}, 7)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.receivedCommit(signed(1, blockchain.SHA256Type{7}, 7)); err != nil {
+		t.Fatal("the same commit received twice should be accepted")
+	}
+	err := cm.receivedCommit(signed(1, blockchain.SHA256Type{8}, 7))
+	if err == nil {
+		t.Fatal("a second block signed at the same height should be rejected")
+	}
+	if _, ok := err.(*network.MisbehaviorError); ok {
+		t.Fatal("the relaying peer shouldn't be penalized for the equivocation of a producer")
+	}
+	if len(cm.votes[blockchain.PreCommitment][7][blockchain.SHA256Type{8}]) != 0 {
+		t.Fatal("equivocating commit shouldn't be counted")
+	}
+}
+
+// testChain links the blocks of a test by their parent ids
+type testChain map[blockchain.SHA256Type]blockchain.SHA256Type
+
+func (chain testChain) IsAncestor(ancestorId blockchain.SHA256Type, id blockchain.SHA256Type) bool {
+	for {
+		if id == ancestorId {
+			return true
+		}
+		parent, ok := chain[id]
+		if !ok {
+			return false
+		}
+		id = parent
+	}
+}
+
+func TestCommitLockReorg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	producers, keys := newTestProducers(t, 4)
+	// a5 <- d6 <- e7 and c5 <- b6 fork from the last irreversible block
+	a5, b6, c5, d6, e7 := blockchain.SHA256Type{5, 1}, blockchain.SHA256Type{6, 2}, blockchain.SHA256Type{5, 3}, blockchain.SHA256Type{6, 4}, blockchain.SHA256Type{7, 5}
+	chain := testChain{b6: c5, d6: a5, e7: d6}
+	sent := make([]blockchain.Commit, 0)
+	newManager := func() *CommitManager {
+		finalize := func(blockId blockchain.SHA256Type) error {
+			return fmt.Errorf("block hasn't arrived")
+		}
+		cm := NewCommitManager(newTestSigner(keys[0]), producers[0].Address, func(packet interface{}) {
+			sent = append(sent, packet.(blockchain.Commit))
+		}, finalize)
+		cm.SetProducers(producers)
+		cm.SetChain(chain)
+		if err := cm.LoadLocks(filepath.Join(dir, "locks.json")); err != nil {
+			t.Fatal(err)
+		}
+		return cm
+	}
+	preCommit := func(key int, blockId blockchain.SHA256Type, height uint64) blockchain.Commit {
+		commit := blockchain.Commit{
+			Type: 		blockchain.PreCommitment,
+			BlockId: 	blockId,
+			Height: 	height,
+			Committer: 	producers[key].Address,
+		}
+		hash, _ := blockchain.SigningDigest(commit)
+		commit.Signature, _ = keys[key].Sign(hash[:])
+		return commit
+	}
+	cm := newManager()
+	cm.PreCommit(a5, 5)
+	cm.receivedCommit(preCommit(1, a5, 5))
+	cm.receivedCommit(preCommit(2, a5, 5))
+	if len(sent) != 2 || sent[1].Type != blockchain.Commitment || sent[1].BlockId != a5 {
+		t.Fatal("producer should commit a5 once it has a quorum of pre-commits")
+	}
+	// the head moves to the other branch
+	cm.PreCommit(b6, 6)
+	for i := 1; i < len(producers); i++ {
+		cm.receivedCommit(preCommit(i, b6, 6))
+	}
+	if len(sent) != 2 {
+		t.Fatal("producer shouldn't sign a block that doesn't descend from its committed block")
+	}
+	cm.PreCommit(d6, 6)
+	if len(sent) != 3 || sent[2].BlockId != d6 {
+		t.Fatal("producer should pre-commit a descendant of its committed block")
+	}
+
+	// the locks survive a restart
+	sent = sent[:0]
+	cm = newManager()
+	cm.PreCommit(c5, 5)
+	cm.PreCommit(b6, 6)
+	cm.PreCommit(d6, 6)
+	if len(sent) != 0 {
+		t.Fatal("restarted producer shouldn't sign again at the heights it signed")
+	}
+	cm.PreCommit(e7, 7)
+	if len(sent) != 1 || sent[0].BlockId != e7 {
+		t.Fatal("restarted producer should pre-commit above its locks")
+	}
+}
+
+func TestCommitRetry(t *testing.T) {
+	producers, keys := newTestProducers(t, 4)
+	arrived := false
+	finalized := 0
+	finalize := func(blockId blockchain.SHA256Type) error {
+		if !arrived {
+			return fmt.Errorf("block hasn't arrived")
+		}
+		finalized++
+		return nil
+	}
+	cm := NewCommitManager(newTestSigner(keys[0]), producers[0].Address, func(packet interface{}) {}, finalize)
+	cm.SetProducers(producers)
+	blockId := blockchain.SHA256Type{1}
+	for i := 1; i < len(producers); i++ {
+		commit := blockchain.Commit{
+			Type: 		blockchain.Commitment,
+			BlockId: 	blockId,
+			Height: 	3,
+			Committer: 	producers[i].Address,
+		}
+		hash, _ := blockchain.SigningDigest(commit)
+		commit.Signature, _ = keys[i].Sign(hash[:])
+		if err := cm.receivedCommit(commit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if finalized != 0 {
+		t.Fatal("missing block shouldn't be finalized")
+	}
+	arrived = true
+	cm.Retry()
+	if finalized != 1 {
+		t.Fatal("committed block should be finalized once it arrives")
+	}
+	cm.Retry()
+	if finalized != 1 || len(cm.votes[blockchain.Commitment]) != 0 {
+		t.Fatal("finalized block should be forgotten")
+	}
+	commit := blockchain.Commit{
+		Type: 		blockchain.PreCommitment,
+		BlockId: 	blockId,
+		Height: 	3 + MaxCommitLookahead + 1,
+		Committer: 	producers[1].Address,
+	}
+	hash, _ := blockchain.SigningDigest(commit)
+	commit.Signature, _ = keys[1].Sign(hash[:])
+	if err := cm.receivedCommit(commit); err == nil {
+		t.Fatal("commit too far above the last irreversible block should be rejected")
+	}
+}
+
+func TestCommitEvidence(t *testing.T) {
+	producers, keys := newTestProducers(t, 4)
+	finalized := make([]blockchain.SHA256Type, 0)
+	finalize := func(blockId blockchain.SHA256Type) error {
+		finalized = append(finalized, blockId)
+		return nil
+	}
+	cm := NewCommitManager(newTestSigner(keys[0]), producers[0].Address, func(packet interface{}) {}, finalize)
+	cm.SetProducers(producers)
+	commits := make([]blockchain.Commit, 0)
+	for i := 1; i < len(producers); i++ {
+		commit := blockchain.Commit{
+			Type: 		blockchain.Commitment,
+			BlockId: 	blockchain.SHA256Type{1},
+			Height: 	2000,
+			Committer: 	producers[i].Address,
+		}
+		hash, _ := blockchain.SigningDigest(commit)
+		commit.Signature, _ = keys[i].Sign(hash[:])
+		commits = append(commits, commit)
+	}
+	if _, _, err := cm.VerifyEvidence(commits[:2]); err == nil {
+		t.Fatal("2 of 4 commits shouldn't be evidence")
+	}
+	if _, _, err := cm.VerifyEvidence(append(commits[:2:2], commits[1])); err == nil {
+		t.Fatal("evidence counting a producer twice should be rejected")
+	}
+	id, height, err := cm.VerifyEvidence(commits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm.Retry()
+	if id != (blockchain.SHA256Type{1}) || height != 2000 || len(finalized) != 1 {
+		t.Fatal("evidence should finalize its block")
+	}
+	if len(cm.Evidence()) != 3 {
+		t.Fatal("evidence should be kept for the peers")
+	}
+}
@@ -4,25 +4,29 @@ import (
 	"consensus_layer/network"
 	"fmt"
 	"consensus_layer/crypto"
-	"crypto/sha256"
+	"consensus_layer/blockchain"
+	"sync"
 )
 
 type ElectionManager struct {
 	role Role
 	term uint64
 	signer network.SignFunc
+	broadcast network.BroadcastFunc
 	address string
 	producers []Producer
 	voteCounter map[network.MessageType]TermVote
 	newTerms []RequestNewTerm
 	grantVotes []GrantVote
+	mutex sync.Mutex // the messages of the peers are received from their read loops
 }
 
-func NewElectionManager(signer network.SignFunc, address string) *ElectionManager {
+func NewElectionManager(signer network.SignFunc, address string, broadcast network.BroadcastFunc) *ElectionManager {
 	em := &ElectionManager{
 		role: Follower,
 		term: 0,
 		signer: signer,
+		broadcast: broadcast,
 		address: address,
 		voteCounter: make(map[network.MessageType]TermVote, 0),
 	}
@@ -33,34 +37,77 @@ func NewElectionManager(signer network.SignFunc, address string) *ElectionManage
 // election manager inherit base manager interface
 var _ network.BaseManager = (*ElectionManager)(nil)
 
-func (em *ElectionManager) Receive(conn *network.Connection, message network.Message) {
-	messageType := message.Header.Type
-	switch messageType {
-	case network.RequestNewTerm:
+func (em *ElectionManager) SetProducers(producers []Producer) {
+	em.producers = producers
+}
+
+// Role returns the role of this producer in the current term
+func (em *ElectionManager) Role() Role {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	return em.role
+}
+
+func (em *ElectionManager) Term() uint64 {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	return em.term
+}
+
+// StartNewTerm asks the producers to move to the next term
+func (em *ElectionManager) StartNewTerm() {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	em.sendNewTermRequest()
+}
+
+func (em *ElectionManager) MessageTypes() []network.MessageType {
+	return []network.MessageType{network.RequestNewTerm, network.RequestVote, network.GrantVote}
+}
+
+func (em *ElectionManager) Start() {}
+
+func (em *ElectionManager) Stop() {}
+
+func (em *ElectionManager) PeerConnected(conn *network.Connection) {}
+
+func (em *ElectionManager) PeerDisconnected(conn *network.Connection) {}
+
+func (em *ElectionManager) Receive(conn *network.Connection, message network.Message) error {
+	payload, err := network.DecodeMessage(message)
+	if err != nil {
+		fmt.Println(err)
+		err = network.Misbehavior(network.MalformedMessage, "%s", err)
+		conn.Misbehave(err)
+		return err
+	}
+	em.mutex.Lock()
+	switch packet := payload.(type) {
+	case RequestNewTerm:
 		fmt.Println("receive new term request")
-		newTerm := RequestNewTerm{}
-		network.UnmarshalBinary(message.Payload, &newTerm)
-		em.receivedNewTerm(conn, newTerm)
-	case network.RequestVote:
+		err = em.receivedNewTerm(packet)
+	case RequestVote:
 		fmt.Println("receive vote request")
-		voteRequest := RequestVote{}
-		network.UnmarshalBinary(message.Payload, &voteRequest)
-		em.receivedVoteRequest(conn, voteRequest)
-	case network.GrantVote:
+		err = em.receivedVoteRequest(packet)
+	case GrantVote:
 		fmt.Println("receive vote response")
-		grantVote := GrantVote{}
-		network.UnmarshalBinary(message.Payload, &grantVote)
-		em.receivedGrantVote(conn, grantVote)
+		err = em.receivedGrantVote(packet)
 	default:
 		break
 	}
+	em.mutex.Unlock()
+	if err != nil {
+		fmt.Println(err)
+		conn.Misbehave(err)
+	}
+	return err
 }
 
-func (em *ElectionManager) receivedNewTerm(conn *network.Connection, newTerm RequestNewTerm) {
+func (em *ElectionManager) receivedNewTerm(newTerm RequestNewTerm) error {
 	if em.role == Follower {
 		// signature is invalid
 		if !em.verifyNewTerm(newTerm) {
-			return
+			return network.Misbehavior(network.InvalidSignature, "new term request of %s", newTerm.Sender)
 		}
 		em.newTerms = append(em.newTerms, newTerm)
 		producerIndex := int(newTerm.Term) % len(em.producers)
@@ -69,33 +116,34 @@ func (em *ElectionManager) receivedNewTerm(conn *network.Connection, newTerm Req
 		}
 		if em.voteCounter[network.RequestNewTerm][newTerm.Term] > uint32(len(em.producers)) * 2/3 {
 			em.becomeCandidate(newTerm.Term)
-			em.sendVoteRequest(conn)
+			em.sendVoteRequest()
 		}
 	}
+	return nil
 }
 
-func (em *ElectionManager) receivedVoteRequest(conn *network.Connection, voteRequest RequestVote) {
+func (em *ElectionManager) receivedVoteRequest(voteRequest RequestVote) error {
 	if em.term >= voteRequest.Term {
 		fmt.Println("the term of vote request should be higher than the local term")
-		return
+		return nil
 	} else {
 		if !em.validateVoteRequest(voteRequest) {
-			fmt.Println("vote request is invalid")
-			return
+			return network.Misbehavior(network.InvalidSignature, "vote request of %s", voteRequest.Candidate)
 		}
 		em.role = Follower
-		em.sendGrantVote(conn, voteRequest.Term)
+		em.sendGrantVote(voteRequest.Term)
 	}
+	return nil
 }
 
-func (em *ElectionManager) receivedGrantVote(conn *network.Connection, grantVote GrantVote) {
+func (em *ElectionManager) receivedGrantVote(grantVote GrantVote) error {
 	if em.role == Candidate {
 		if grantVote.Term != em.term {
-			return
+			return nil
 		}
 		// signature is invalid
 		if !em.verifyGrantNode(grantVote) {
-			return
+			return network.Misbehavior(network.InvalidSignature, "vote of %s", grantVote.Sender)
 		}
 		em.grantVotes = append(em.grantVotes, grantVote)
 		if len(em.grantVotes) > len(em.producers) * 2/3 {
@@ -103,16 +151,11 @@ func (em *ElectionManager) receivedGrantVote(conn *network.Connection, grantVote
 			em.becomeLeader()
 		}
 	}
+	return nil
 }
 
 func (em *ElectionManager) validateVoteRequest(voteRequest RequestVote) bool {
-	var candidatePub *crypto.PublicKey = nil
-	for _, p := range em.producers {
-		if p.Address == voteRequest.Candidate {
-			candidatePub = p.PublicKey
-			break
-		}
-	}
+	candidatePub := em.producerKey(voteRequest.Candidate)
 	if candidatePub == nil {
 		return false
 	}
@@ -129,58 +172,42 @@ func (em *ElectionManager) validateVoteRequest(voteRequest RequestVote) bool {
 	return true
 }
 
-func (em *ElectionManager) verifySignatureOfVoteRequest(voteRequest RequestVote, candidatePub *crypto.PublicKey) bool {
-	voteRequestWithoutSignature := RequestVote{
-		voteRequest.Term,
-		voteRequest.Candidate,
-		voteRequest.SignedNewTerms,
-		crypto.Signature{},
+func (em *ElectionManager) producerKey(address string) *crypto.PublicKey {
+	for _, p := range em.producers {
+		if p.Address == address {
+			return p.PublicKey
+		}
 	}
-	buf, _ := network.MarshalBinary(voteRequestWithoutSignature)
-	hash := sha256.Sum256(buf)
-	return voteRequest.Signature.Verify(*candidatePub, hash[:])
+	return nil
 }
 
-func (em *ElectionManager) verifyNewTerm(newTerm RequestNewTerm) bool {
-	var senderPub *crypto.PublicKey = nil
-	for _, p := range em.producers {
-		if p.Address == newTerm.Sender {
-			senderPub = p.PublicKey
-			break
-		}
+// verifySignature checks the signature of a signed message against the digest of the message without it
+func verifySignature(message interface{}, signature crypto.Signature, pub *crypto.PublicKey) bool {
+	hash, err := blockchain.SigningDigest(message)
+	if err != nil {
+		return false
 	}
+	return signature.Verify(*pub, hash[:])
+}
+
+func (em *ElectionManager) verifySignatureOfVoteRequest(voteRequest RequestVote, candidatePub *crypto.PublicKey) bool {
+	return verifySignature(voteRequest, voteRequest.Signature, candidatePub)
+}
+
+func (em *ElectionManager) verifyNewTerm(newTerm RequestNewTerm) bool {
+	senderPub := em.producerKey(newTerm.Sender)
 	if senderPub == nil {
 		return false
 	}
-	newTermWithoutSignature := RequestNewTerm{
-		newTerm.Term,
-		newTerm.Sender,
-		crypto.Signature{},
-	}
-	buf, _ := network.MarshalBinary(newTermWithoutSignature)
-	hash := sha256.Sum256(buf)
-	return newTerm.Signature.Verify(*senderPub, hash[:])
+	return verifySignature(newTerm, newTerm.Signature, senderPub)
 }
 
 func (em *ElectionManager) verifyGrantNode(grandVote GrantVote) bool {
-	var senderPub *crypto.PublicKey = nil
-	for _, p := range em.producers {
-		if p.Address == grandVote.Sender {
-			senderPub = p.PublicKey
-			break
-		}
-	}
+	senderPub := em.producerKey(grandVote.Sender)
 	if senderPub == nil {
 		return false
 	}
-	grantVoteWithoutSignature := GrantVote{
-		grandVote.Term,
-		grandVote.Sender,
-		crypto.Signature{},
-	}
-	buf, _ := network.MarshalBinary(grantVoteWithoutSignature)
-	hash := sha256.Sum256(buf)
-	return grandVote.Signature.Verify(*senderPub, hash[:])
+	return verifySignature(grandVote, grandVote.Signature, senderPub)
 }
 
 func (em *ElectionManager) becomeCandidate(term uint64) {
@@ -205,20 +232,20 @@ func (em *ElectionManager) Send(conn *network.Connection, messageType network.Me
 	}
 }
 
-func (em *ElectionManager) sendNewTermRequest(conn *network.Connection) {
+// the messages of the election are gossiped to every producer
+func (em *ElectionManager) sendNewTermRequest() {
 	newTerm := RequestNewTerm{
 		em.term + 1,
 		em.address,
 		crypto.Signature{},
 	}
-	buf, _ := network.MarshalBinary(newTerm)
-	hash := sha256.Sum256(buf)
+	hash, _ := blockchain.SigningDigest(newTerm)
 	sig := em.signer(hash)
 	newTerm.Signature = sig
-	conn.Send(newTerm)
+	em.broadcast(newTerm)
 }
 
-func (em *ElectionManager) sendVoteRequest(conn *network.Connection) {
+func (em *ElectionManager) sendVoteRequest() {
 	signedNewTerms := make([]RequestNewTerm, 0)
 	for _, newTerm := range em.newTerms {
 		if newTerm.Term == em.term { // term of candidate
@@ -231,22 +258,20 @@ func (em *ElectionManager) sendVoteRequest(conn *network.Connection) {
 		SignedNewTerms:	signedNewTerms,
 		Signature: crypto.Signature{},
 	}
-	buf, _ := network.MarshalBinary(requestVote)
-	hash := sha256.Sum256(buf)
+	hash, _ := blockchain.SigningDigest(requestVote)
 	sig := em.signer(hash)
 	requestVote.Signature = sig
-	conn.Send(requestVote)
+	em.broadcast(requestVote)
 }
 
-func (em *ElectionManager) sendGrantVote(conn *network.Connection, term uint64) {
+func (em *ElectionManager) sendGrantVote(term uint64) {
 	grantVote := GrantVote{
 		term,
 		em.address,
 		crypto.Signature{},
 	}
-	buf, _ := network.MarshalBinary(grantVote)
-	hash := sha256.Sum256(buf)
+	hash, _ := blockchain.SigningDigest(grantVote)
 	sig := em.signer(hash)
 	grantVote.Signature = sig
-	conn.Send(grantVote)
+	em.broadcast(grantVote)
 }
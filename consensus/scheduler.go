@@ -0,0 +1,98 @@
+package consensus
+
+import (
+	"consensus_layer/blockchain"
+	"fmt"
+	"time"
+)
+
+// blocks from slightly faster clocks are still accepted
+const MaxClockDrift = 500 * time.Millisecond
+
+// Scheduler splits the time since genesis into fixed slots and assigns them to the producers in round robin
+type Scheduler struct {
+	genesisTime time.Time
+	interval 	time.Duration
+	producers 	[]Producer
+}
+
+func NewScheduler(genesisTime time.Time, interval time.Duration, producers []Producer) *Scheduler {
+	return &Scheduler{
+		genesisTime: 	genesisTime,
+		interval: 		interval,
+		producers: 		producers,
+	}
+}
+
+func (s *Scheduler) Interval() time.Duration {
+	return s.interval
+}
+
+// Slot returns the slot that t falls into, slot 0 starts at genesis
+func (s *Scheduler) Slot(t time.Time) (uint64, error) {
+	if t.Before(s.genesisTime) {
+		return 0, fmt.Errorf("%s is before genesis", t)
+	}
+	return uint64(t.Sub(s.genesisTime) / s.interval), nil
+}
+
+func (s *Scheduler) SlotTime(slot uint64) time.Time {
+	return s.genesisTime.Add(time.Duration(slot) * s.interval)
+}
+
+func (s *Scheduler) ProducerAt(slot uint64) Producer {
+	return s.producers[int(slot % uint64(len(s.producers)))]
+}
+
+// NextSlot returns the first slot of the producer that starts at or after now
+func (s *Scheduler) NextSlot(address string, now time.Time) (uint64, error) {
+	index := -1
+	for i, p := range s.producers {
+		if p.Address == address {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return 0, fmt.Errorf("%s isn't a producer", address)
+	}
+	slot := uint64(0)
+	if now.After(s.genesisTime) {
+		slot = uint64((now.Sub(s.genesisTime) + s.interval - 1) / s.interval)
+	}
+	n := uint64(len(s.producers))
+	offset := (uint64(index) + n - slot % n) % n
+	return slot + offset, nil
+}
+
+// EarlyBlockError rejects a block whose slot hasn't started on the local clock,
+// a node whose clock is slightly ahead may accept it
+type EarlyBlockError struct {
+	Slot uint64
+}
+
+func (err *EarlyBlockError) Error() string {
+	return fmt.Sprintf("slot %d hasn't started yet", err.Slot)
+}
+
+// ValidateHeader checks that the block is produced by the producer of its slot,
+// in a later slot than its parent and not in a slot that hasn't started yet
+func (s *Scheduler) ValidateHeader(header blockchain.BlockHeader, parent *blockchain.BlockHeader, now time.Time) error {
+	slot, err := s.Slot(header.Timestamp)
+	if err != nil {
+		return err
+	}
+	if !header.Timestamp.Equal(s.SlotTime(slot)) {
+		return fmt.Errorf("timestamp %s isn't the start of a slot", header.Timestamp)
+	}
+	if header.Timestamp.After(now.Add(MaxClockDrift)) {
+		return &EarlyBlockError{Slot: slot}
+	}
+	if expected := s.ProducerAt(slot).Address; header.Producer != expected {
+		return fmt.Errorf("slot %d belongs to %s, not %s", slot, expected, header.Producer)
+	}
+	if parent != nil && !header.Timestamp.After(parent.Timestamp) {
+		return fmt.Errorf("block should be produced in a later slot than its parent")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+	"consensus_layer/blockchain"
+)
+
+func TestScheduler(t *testing.T) {
+	producers, _ := newTestProducers(t, 3)
+	genesisTime := time.Unix(1500000000, 0).UTC()
+	s := NewScheduler(genesisTime, time.Second, producers)
+	now := genesisTime.Add(4500 * time.Millisecond)
+	slot, _ := s.Slot(now)
+	if slot != 4 || s.ProducerAt(slot).Address != producers[1].Address {
+		t.Fatal("slot 4 should belong to producer 1")
+	}
+	next, _ := s.NextSlot(producers[1].Address, now)
+	if next != 7 {
+		t.Fatal("the next slot of producer 1 should be 7")
+	}
+	next, _ = s.NextSlot(producers[2].Address, now)
+	if next != 5 {
+		t.Fatal("the next slot of producer 2 should be 5")
+	}
+	if _, err := s.NextSlot("unknown", now); err == nil {
+		t.Fatal("unknown address isn't a producer")
+	}
+
+	parent := blockchain.BlockHeader{Timestamp: s.SlotTime(2), Producer: producers[2].Address}
+	header := blockchain.BlockHeader{Timestamp: s.SlotTime(4), Producer: producers[1].Address}
+	if err := s.ValidateHeader(header, &parent, now); err != nil {
+		t.Fatal(err)
+	}
+	header.Producer = producers[0].Address
+	if err := s.ValidateHeader(header, &parent, now); err == nil {
+		t.Fatal("producer 0 doesn't own slot 4")
+	}
+	header = blockchain.BlockHeader{Timestamp: s.SlotTime(4).Add(time.Millisecond), Producer: producers[1].Address}
+	if err := s.ValidateHeader(header, &parent, now); err == nil {
+		t.Fatal("timestamp should be the start of the slot")
+	}
+	header = blockchain.BlockHeader{Timestamp: s.SlotTime(7), Producer: producers[1].Address}
+	if _, ok := s.ValidateHeader(header, &parent, now).(*EarlyBlockError); !ok {
+		t.Fatal("slot 7 hasn't started")
+	}
+	header = blockchain.BlockHeader{Timestamp: s.SlotTime(1), Producer: producers[1].Address}
+	if err := s.ValidateHeader(header, &parent, now); err == nil {
+		t.Fatal("block should be later than its parent")
+	}
+}
@@ -2,8 +2,16 @@ package consensus
 
 import (
 	"consensus_layer/crypto"
+	"consensus_layer/blockchain"
+	"consensus_layer/network"
 )
 
+func init() {
+	network.RegisterMessage(network.RequestNewTerm, RequestNewTerm{})
+	network.RegisterMessage(network.RequestVote, RequestVote{})
+	network.RegisterMessage(network.GrantVote, GrantVote{})
+}
+
 type Role uint8
 
 const (
@@ -36,4 +44,20 @@ type Producer struct {
 	PublicKey *crypto.PublicKey
 }
 
-type TermVote map[uint64]uint32 // [term]vote
\ No newline at end of file
+type TermVote map[uint64]uint32 // [term]vote
+
+// GenesisProducers builds the initial producer set from the genesis
+func GenesisProducers(genesis *blockchain.Genesis) ([]Producer, error) {
+	producers := make([]Producer, 0, len(genesis.Producers))
+	for _, p := range genesis.Producers {
+		publicKey, err := crypto.NewPublicKey(p.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		producers = append(producers, Producer{
+			Address: 	p.Address,
+			PublicKey: 	publicKey,
+		})
+	}
+	return producers, nil
+}
\ No newline at end of file
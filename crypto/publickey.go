@@ -13,10 +13,13 @@ type PublicKey struct {
 
 func NewPublicKey(pubString string) (*PublicKey, error) {
 	decode := base58.Decode(pubString)
+	if len(decode) <= 4 {
+		return nil, fmt.Errorf("invalid public key")
+	}
 	checkSum := make([]byte, 4)
 	copy(checkSum, decode[len(decode)-4:])
 	data := decode[:len(decode)-4]
-	if bytes.Equal(calculateCheckSum(data), checkSum) {
+	if !bytes.Equal(calculateCheckSum(data), checkSum) {
 		return nil, fmt.Errorf("invalid checksum")
 	}
 	return &PublicKey{data}, nil
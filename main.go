@@ -3,13 +3,29 @@ package main
 import (
 	"flag"
 	nm "consensus_layer/node" // node manager
+	"consensus_layer/blockchain"
+	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 )
 
 func main() {
+	if len(os.Args) > 2 && os.Args[1] == "genesis" && os.Args[2] == "init" {
+		if err := initGenesis(os.Args[3:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
 	var (
 		address = flag.String("address", "", "address of your node")
 		target = flag.String("target", "", "address of target peer")
+		dataDir = flag.String("data", "data", "directory where the node stores its data")
+		genesisFile = flag.String("genesis", "genesis.json", "genesis file of the chain, created by genesis init")
+		producer = flag.String("producer", "", "producer address of your node")
+		privateKey = flag.String("key", "", "WIF private key of your node")
 	)
 	flag.Parse()
 	fmt.Println("address, target: ", *address, *target)
@@ -17,8 +33,65 @@ func main() {
 		*address = "0.0.0.0:2000"
 		*target = "localhost:2001"
 	}
-	node := nm.NewNode(*address, []string{*target})
+	if _, err := os.Stat(*genesisFile); os.IsNotExist(err) {
+		fmt.Println("genesis file ", *genesisFile, " doesn't exist, create one with: genesis init")
+		return
+	}
+	node, err := nm.NewNode(nm.Config{
+		P2PAddress: 	*address,
+		Targets: 		[]string{*target},
+		DataDir: 		*dataDir,
+		GenesisFile: 	*genesisFile,
+		ProducerAddress: *producer,
+		PrivateKey: 	*privateKey,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 	done := make(chan struct{})
 	node.Start()
 	<- done
-}
\ No newline at end of file
+}
+
+// initGenesis creates the genesis of a new chain with fresh producer keys. The private keys are
+// written to a file only their owner can read, they are never part of the genesis.
+func initGenesis(args []string) error {
+	flags := flag.NewFlagSet("genesis init", flag.ExitOnError)
+	var (
+		chainName = flags.String("chain", "testnet", "name of the chain")
+		producers = flags.String("producers", "producer1", "comma separated addresses of the producers")
+		genesisFile = flags.String("genesis", "genesis.json", "genesis file to create")
+		keysFile = flags.String("keys", "producer_keys.json", "file to create with the WIF private key of every producer")
+	)
+	flags.Parse(args)
+	genesis, keys, err := blockchain.NewGenesis(*chainName, strings.Split(*producers, ","), time.Now())
+	if err != nil {
+		return err
+	}
+	wifs := make(map[string]string, len(keys))
+	for address, key := range keys {
+		wifs[address] = key.String()
+	}
+	data, err := json.MarshalIndent(wifs, "", "    ")
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(*keysFile, os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := genesis.Save(*genesisFile); err != nil {
+		os.Remove(*keysFile)
+		return err
+	}
+	fmt.Println("created ", *genesisFile, " and the keys of its producers in ", *keysFile)
+	return nil
+}
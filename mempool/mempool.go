@@ -0,0 +1,329 @@
+package mempool
+
+import (
+	"consensus_layer/blockchain"
+	"consensus_layer/crypto"
+	"consensus_layer/serializer"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const DefaultMaxCount = 10000
+const DefaultMaxBytes = 64 * 1024 * 1024
+const DefaultMaxTransactionBytes = 1024 * 1024
+
+type Config struct {
+	MaxCount 				int // maximum number of pending transactions
+	MaxBytes 				int // maximum total size of pending transactions
+	MaxTransactionBytes 	int // maximum size of a single transaction
+}
+
+type entry struct {
+	tx 		blockchain.Transaction
+	id 		blockchain.SHA256Type
+	size 	int
+	added 	time.Time
+}
+
+// Chain is the irreversible chain the nonces of the senders continue
+type Chain interface {
+	LastNonce(sender crypto.PublicKey) uint64
+}
+
+// Mempool holds the pending transactions until a leader packs them into a block.
+// Transactions of a sender are kept ordered by nonce, the nonces of a sender follow each
+// other on the chain starting at 1.
+type Mempool struct {
+	config 			Config
+	entries 		map[blockchain.SHA256Type]*entry
+	senders 		map[string][]*entry // [sender public key]entries ordered by nonce
+	committedNonces map[string]uint64 // [sender public key]highest nonce included by a committed block
+	included 		map[blockchain.SHA256Type]bool // transactions of the head branch above the last committed block
+	headNonces 		map[string]map[uint64]bool // [sender public key]nonces of the included transactions
+	chain 			Chain
+	size 			int
+	mutex 			sync.Mutex
+}
+
+func NewMempool(config Config) *Mempool {
+	if config.MaxCount <= 0 {
+		config.MaxCount = DefaultMaxCount
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = DefaultMaxBytes
+	}
+	if config.MaxTransactionBytes <= 0 {
+		config.MaxTransactionBytes = DefaultMaxTransactionBytes
+	}
+	return &Mempool{
+		config: 			config,
+		entries: 			make(map[blockchain.SHA256Type]*entry, 0),
+		senders: 			make(map[string][]*entry, 0),
+		committedNonces: 	make(map[string]uint64, 0),
+		included: 			make(map[blockchain.SHA256Type]bool, 0),
+		headNonces: 		make(map[string]map[uint64]bool, 0),
+	}
+}
+
+// SetChain sets the chain whose nonces are used, without it only the nonces of the blocks
+// committed since the pool was created are known
+func (mp *Mempool) SetChain(chain Chain) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	mp.chain = chain
+}
+
+// Add validates the transaction and adds it to the pool.
+// When the pool is full the last transaction of the sender with the most pending transactions is evicted.
+func (mp *Mempool) Add(tx blockchain.Transaction) error {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	return mp.add(tx)
+}
+
+func (mp *Mempool) add(tx blockchain.Transaction) error {
+	buf, err := serializer.MarshalBinary(tx)
+	if err != nil {
+		return err
+	}
+	if len(buf) > mp.config.MaxTransactionBytes {
+		return fmt.Errorf("transaction is too large")
+	}
+	id := tx.Id()
+	if _, ok := mp.entries[id]; ok {
+		return fmt.Errorf("duplicated transaction")
+	}
+	if mp.included[id] {
+		return fmt.Errorf("transaction is already included by the head branch")
+	}
+	if !tx.Verify() {
+		return fmt.Errorf("invalid signature")
+	}
+	sender := string(tx.Sender.Data)
+	if tx.Nonce <= mp.lastNonce(sender) {
+		return fmt.Errorf("nonce %d is already used", tx.Nonce)
+	}
+	queue := mp.senders[sender]
+	i := sort.Search(len(queue), func(i int) bool {
+		return queue[i].tx.Nonce >= tx.Nonce
+	})
+	if i < len(queue) && queue[i].tx.Nonce == tx.Nonce {
+		return fmt.Errorf("nonce %d is already pending", tx.Nonce)
+	}
+	e := &entry{
+		tx: 	tx,
+		id: 	id,
+		size: 	len(buf),
+		added: 	time.Now(),
+	}
+	queue = append(queue, nil)
+	copy(queue[i+1:], queue[i:])
+	queue[i] = e
+	mp.senders[sender] = queue
+	mp.entries[id] = e
+	mp.size += e.size
+	for len(mp.entries) > mp.config.MaxCount || mp.size > mp.config.MaxBytes {
+		if mp.evict() == e {
+			return fmt.Errorf("mempool is full")
+		}
+	}
+	return nil
+}
+
+// evict removes the transaction with the highest nonce of the sender with the most pending transactions
+func (mp *Mempool) evict() *entry {
+	var victim *entry
+	longest := 0
+	for _, queue := range mp.senders {
+		last := queue[len(queue)-1]
+		if len(queue) > longest || (len(queue) == longest && last.added.After(victim.added)) {
+			victim, longest = last, len(queue)
+		}
+	}
+	if victim != nil {
+		mp.remove(victim.id)
+	}
+	return victim
+}
+
+func (mp *Mempool) remove(id blockchain.SHA256Type) {
+	e, ok := mp.entries[id]
+	if !ok {
+		return
+	}
+	delete(mp.entries, id)
+	mp.size -= e.size
+	sender := string(e.tx.Sender.Data)
+	queue := mp.senders[sender]
+	for i := range queue {
+		if queue[i] == e {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) == 0 {
+		delete(mp.senders, sender)
+	} else {
+		mp.senders[sender] = queue
+	}
+}
+
+// lastNonce returns the highest nonce of the sender on the head branch
+func (mp *Mempool) lastNonce(sender string) uint64 {
+	last := mp.committedNonces[sender]
+	if mp.chain != nil {
+		if nonce := mp.chain.LastNonce(crypto.PublicKey{Data: []byte(sender)}); nonce > last {
+			last = nonce
+		}
+	}
+	for nonce := range mp.headNonces[sender] {
+		if nonce > last {
+			last = nonce
+		}
+	}
+	return last
+}
+
+// dropStale removes the pending transactions of the sender whose nonce is no longer usable
+func (mp *Mempool) dropStale(sender string) {
+	last := mp.lastNonce(sender)
+	stale := make([]blockchain.SHA256Type, 0)
+	for _, e := range mp.senders[sender] {
+		if e.tx.Nonce <= last {
+			stale = append(stale, e.id)
+		}
+	}
+	for _, id := range stale {
+		mp.remove(id)
+	}
+}
+
+// include marks the transaction as part of the head branch or removes the mark
+func (mp *Mempool) include(tx *blockchain.Transaction, included bool) {
+	id, sender := tx.Id(), string(tx.Sender.Data)
+	if included {
+		mp.included[id] = true
+		if mp.headNonces[sender] == nil {
+			mp.headNonces[sender] = make(map[uint64]bool, 0)
+		}
+		mp.headNonces[sender][tx.Nonce] = true
+		return
+	}
+	delete(mp.included, id)
+	delete(mp.headNonces[sender], tx.Nonce)
+	if len(mp.headNonces[sender]) == 0 {
+		delete(mp.headNonces, sender)
+	}
+}
+
+func (mp *Mempool) Has(id blockchain.SHA256Type) bool {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	_, ok := mp.entries[id]
+	return ok
+}
+
+func (mp *Mempool) Count() int {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	return len(mp.entries)
+}
+
+func (mp *Mempool) Size() int {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	return mp.size
+}
+
+// Pending returns up to maxCount transactions whose total size doesn't exceed maxBytes.
+// Senders are served in the order their oldest pending transaction arrived, and the transactions of a sender by nonce
+// as long as they follow the last nonce of the sender on the head branch without a gap.
+func (mp *Mempool) Pending(maxCount int, maxBytes int) []blockchain.Transaction {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	queues := make([][]*entry, 0, len(mp.senders))
+	for _, queue := range mp.senders {
+		queues = append(queues, queue)
+	}
+	sort.Slice(queues, func(i, j int) bool {
+		return oldest(queues[i]).Before(oldest(queues[j]))
+	})
+	txs := make([]blockchain.Transaction, 0)
+	size := 0
+	for _, queue := range queues {
+		next := mp.lastNonce(string(queue[0].tx.Sender.Data)) + 1
+		for _, e := range queue {
+			if e.tx.Nonce != next || len(txs) >= maxCount || size + e.size > maxBytes {
+				// a later nonce can't be included without this one
+				break
+			}
+			txs = append(txs, e.tx)
+			size += e.size
+			next++
+		}
+	}
+	return txs
+}
+
+func oldest(queue []*entry) time.Time {
+	t := queue[0].added
+	for _, e := range queue[1:] {
+		if e.added.Before(t) {
+			t = e.added
+		}
+	}
+	return t
+}
+
+// Update applies a head change of the fork tree:
+// transactions of attached blocks leave the pool, transactions of detached blocks are put back
+// unless the new head branch already used their nonce
+func (mp *Mempool) Update(event blockchain.ReorgEvent) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	for _, block := range event.Detached {
+		for i := range block.Transactions {
+			mp.include(&block.Transactions[i], false)
+		}
+	}
+	senders := make(map[string]bool, 0)
+	for _, block := range event.Attached {
+		for i := range block.Transactions {
+			tx := &block.Transactions[i]
+			mp.include(tx, true)
+			mp.remove(tx.Id())
+			senders[string(tx.Sender.Data)] = true
+		}
+	}
+	for sender := range senders {
+		mp.dropStale(sender)
+	}
+	for _, block := range event.Detached {
+		for _, tx := range block.Transactions {
+			mp.add(tx)
+		}
+	}
+}
+
+// Commit drops the transactions included by a committed block,
+// pending transactions whose nonce is no longer usable are dropped as well
+func (mp *Mempool) Commit(block *blockchain.SignedBlock) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	senders := make(map[string]bool, 0)
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		mp.remove(tx.Id())
+		mp.include(tx, false)
+		sender := string(tx.Sender.Data)
+		if nonce, ok := mp.committedNonces[sender]; !ok || tx.Nonce > nonce {
+			mp.committedNonces[sender] = tx.Nonce
+		}
+		senders[sender] = true
+	}
+	for sender := range senders {
+		mp.dropStale(sender)
+	}
+}
@@ -0,0 +1,125 @@
+package mempool
+
+import (
+	"testing"
+	"consensus_layer/blockchain"
+	"consensus_layer/crypto"
+)
+
+func newTestTransaction(t *testing.T, privateKey *crypto.PrivateKey, nonce uint64) blockchain.Transaction {
+	tx := blockchain.Transaction{
+		Payload: 	[]byte("payload"),
+		Nonce: 		nonce,
+	}
+	if err := tx.Sign(privateKey); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestMempoolAdd(t *testing.T) {
+	mp := NewMempool(Config{})
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	tx := newTestTransaction(t, privateKey, 2)
+	if err := mp.Add(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Add(tx); err == nil {
+		t.Fatal("duplicated transaction should be rejected")
+	}
+	forged := tx
+	forged.Payload = []byte("forged")
+	if err := mp.Add(forged); err == nil {
+		t.Fatal("transaction with an invalid signature should be rejected")
+	}
+	other := newTestTransaction(t, privateKey, 2)
+	other.Payload = []byte("other")
+	other.Sign(privateKey)
+	if err := mp.Add(other); err == nil {
+		t.Fatal("a pending nonce shouldn't be reused")
+	}
+	mp.Add(newTestTransaction(t, privateKey, 1))
+	pending := mp.Pending(10, DefaultMaxBytes)
+	if len(pending) != 2 || pending[0].Nonce != 1 || pending[1].Nonce != 2 {
+		t.Fatal("transactions of a sender should be ordered by nonce")
+	}
+}
+
+func TestMempoolEviction(t *testing.T) {
+	mp := NewMempool(Config{MaxCount: 3})
+	alice, _ := crypto.NewRandomPrivateKey()
+	bob, _ := crypto.NewRandomPrivateKey()
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		if err := mp.Add(newTestTransaction(t, alice, nonce)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bobTx := newTestTransaction(t, bob, 1)
+	if err := mp.Add(bobTx); err != nil {
+		t.Fatal(err)
+	}
+	if mp.Count() != 3 || !mp.Has(bobTx.Id()) {
+		t.Fatal("the last transaction of the busiest sender should be evicted")
+	}
+	aliceTx := newTestTransaction(t, alice, 3)
+	if mp.Has(aliceTx.Id()) {
+		t.Fatal("alice's nonce 3 should be evicted")
+	}
+}
+
+func TestMempoolBlocks(t *testing.T) {
+	mp := NewMempool(Config{})
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	tx1 := newTestTransaction(t, privateKey, 1)
+	tx2 := newTestTransaction(t, privateKey, 2)
+	mp.Add(tx1)
+	mp.Add(tx2)
+	block := &blockchain.SignedBlock{Transactions: []blockchain.Transaction{tx1}}
+	mp.Update(blockchain.ReorgEvent{Attached: []*blockchain.SignedBlock{block}})
+	if mp.Has(tx1.Id()) || mp.Count() != 1 {
+		t.Fatal("transactions of attached blocks should leave the pool")
+	}
+	mp.Update(blockchain.ReorgEvent{Detached: []*blockchain.SignedBlock{block}})
+	if !mp.Has(tx1.Id()) {
+		t.Fatal("transactions of detached blocks should be put back")
+	}
+	mp.Commit(&blockchain.SignedBlock{Transactions: []blockchain.Transaction{tx2}})
+	if mp.Count() != 0 {
+		t.Fatal("transactions with a committed nonce should be dropped")
+	}
+	if err := mp.Add(tx1); err == nil {
+		t.Fatal("a committed nonce shouldn't be accepted")
+	}
+}
+
+func TestMempoolHeadBranch(t *testing.T) {
+	mp := NewMempool(Config{})
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	tx1 := newTestTransaction(t, privateKey, 1)
+	block := &blockchain.SignedBlock{Transactions: []blockchain.Transaction{tx1}}
+	mp.Update(blockchain.ReorgEvent{Attached: []*blockchain.SignedBlock{block}})
+	if err := mp.Add(tx1); err == nil {
+		t.Fatal("transaction of the head branch shouldn't be accepted again")
+	}
+	other := newTestTransaction(t, privateKey, 1)
+	other.Payload = []byte("other")
+	other.Sign(privateKey)
+	if err := mp.Add(other); err == nil {
+		t.Fatal("a nonce of the head branch shouldn't be reused")
+	}
+	tx3 := newTestTransaction(t, privateKey, 3)
+	if err := mp.Add(tx3); err != nil {
+		t.Fatal(err)
+	}
+	if len(mp.Pending(10, DefaultMaxBytes)) != 0 {
+		t.Fatal("a transaction after a missing nonce shouldn't be packed")
+	}
+	mp.Add(newTestTransaction(t, privateKey, 2))
+	if pending := mp.Pending(10, DefaultMaxBytes); len(pending) != 2 || pending[0].Nonce != 2 {
+		t.Fatal("transactions should follow the nonce of the head branch")
+	}
+	mp.Update(blockchain.ReorgEvent{Detached: []*blockchain.SignedBlock{block}})
+	if pending := mp.Pending(10, DefaultMaxBytes); len(pending) != 3 || pending[0].Nonce != 1 {
+		t.Fatal("transaction of a detached block should be packed again")
+	}
+}
@@ -1,6 +1,13 @@
 package network
 
+// BaseManager handles a set of message types on behalf of the node. The node routes every
+// message of the types the manager declares to it, a type can only belong to one manager.
 type BaseManager interface {
+	MessageTypes() []MessageType
+	Start() // called when the node starts
+	Stop() // called when the node stops, in the reverse order of Start
+	PeerConnected(conn *Connection) // called once the handshake of the peer is accepted
+	PeerDisconnected(conn *Connection) // called when a connected peer is gone
 	Send(conn *Connection, messageType MessageType)
-	Receive(conn *Connection, message Message)
+	Receive(conn *Connection, message Message) error // the message is rejected if an error is returned
 }
@@ -1,20 +1,30 @@
 package network
 
 import (
-	"net"
-	"bufio"
-	"strings"
+	"consensus_layer/crypto"
 	"fmt"
+	"io"
+	"sync"
+	"time"
 )
 
 type Connection struct {
-	conn 			net.Conn
-	connReader 		*bufio.Reader
+	stream 			Stream
+	queue 			*writeQueue // messages waiting for the writer goroutine
+	calls 			*rpcCalls // requests waiting for their response
 	isOpen 			bool
 	isSynchronizing bool
 	isOutgoing		bool
+	peerInfo		*HandshakeInfo // set once the handshake of the peer is accepted
+	version			uint16 // negotiated during the handshake
+	capabilities	Capability
+	latency			latency
 	onReceive		ReceiveFunc
 	onFinish		FinishFunc
+	onMisbehave		MisbehaveFunc
+	offenses		int
+	openedAt		time.Time
+	mutex			sync.Mutex
 }
 
 func newConnection() *Connection {
@@ -22,20 +32,21 @@ func newConnection() *Connection {
 		isOpen:				false,
 		isSynchronizing:	false,
 		isOutgoing: 		false,
+		queue: 				newWriteQueue(DefaultWriteQueueSize),
+		calls: 				newRPCCalls(),
+		openedAt: 			time.Now(),
 	}
 }
 
-func NewOutgoingConnection(remoteAddr string, onRecevie ReceiveFunc, onFinish FinishFunc) (*Connection, error) {
-	if !strings.Contains(remoteAddr, ":") {
-		return nil, fmt.Errorf("invalid peer address %s", remoteAddr)
-	}
-	conn, err := net.Dial(TCP, remoteAddr)
+// NewOutgoingConnection dials the peer through the transport with the key of this node
+func NewOutgoingConnection(transport Transport, remoteAddr string, privateKey *crypto.PrivateKey, onRecevie ReceiveFunc, onFinish FinishFunc) (*Connection, error) {
+	stream, err := transport.Dial(remoteAddr, privateKey)
 	if err != nil {
 		fmt.Println(err)
 		return nil, err
 	}
 	c := newConnection()
-	c.conn = conn
+	c.stream = stream
 	c.isOutgoing = true
 	c.isOpen = true
 	c.onReceive = onRecevie
@@ -43,9 +54,10 @@ func NewOutgoingConnection(remoteAddr string, onRecevie ReceiveFunc, onFinish Fi
 	return c, nil
 }
 
-func NewIncomingConnection(conn net.Conn, onRecevie ReceiveFunc, onFinish FinishFunc) *Connection {
+// NewIncomingConnection wraps a stream accepted by a listener
+func NewIncomingConnection(stream Stream, onRecevie ReceiveFunc, onFinish FinishFunc) *Connection {
 	c := newConnection()
-	c.conn = conn
+	c.stream = stream
 	c.isOutgoing = false
 	c.isOpen = true
 	c.onReceive = onRecevie
@@ -57,67 +69,151 @@ func (c *Connection) IsOutgoing() bool {
 	return c.isOutgoing
 }
 
+// OpenedAt returns when the connection was dialed or accepted
+func (c *Connection) OpenedAt() time.Time {
+	return c.openedAt
+}
+
+// IsAvailable reports whether the connection is open and not busy answering sync requests
 func (c *Connection) IsAvailable() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	return c.isOpen && !c.isSynchronizing
 }
 
+// SetSynchronizing marks the connection as busy while this node waits for sync responses from it
+func (c *Connection) SetSynchronizing(synchronizing bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.isSynchronizing = synchronizing
+}
+
+// IsEstablished reports whether the handshake of the peer has been accepted
+func (c *Connection) IsEstablished() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.peerInfo != nil
+}
+
+func (c *Connection) SetPeerInfo(info HandshakeInfo) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.peerInfo = &info
+}
+
+// PeerInfo returns the handshake info of the peer, nil before the handshake
+func (c *Connection) PeerInfo() *HandshakeInfo {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.peerInfo
+}
+
+// RemoteKey returns the static key the peer proved to own when the connection was secured
+func (c *Connection) RemoteKey() *crypto.PublicKey {
+	return c.stream.RemoteKey()
+}
+
 func (c *Connection) RemoteAddress() string {
-	return c.conn.RemoteAddr().String()
+	return c.stream.RemoteAddress()
 }
 
 func (c *Connection) LocalAddress() string {
-	return c.conn.LocalAddr().String()
+	return c.stream.LocalAddress()
 }
 
+// Send encodes a registered payload and queues it with the priority of its type
 func (c *Connection) Send(packet interface{}) error {
-	err := error(nil)
-	bytes := make([]byte, 0)
-	messageType := Handshake
-	switch packet.(type) {
-	case HandshakePacket:
-		bytes, err = MarshalBinary(packet)
-		if err != nil {
-			return err
-		}
-	}
-	message := Message{
-		Header: MessageHeader{},
-		Payload: bytes,
+	message, err := EncodeMessage(packet)
+	if err != nil {
+		return err
 	}
-	message.Header.Type = messageType
-	message.Header.Length = uint32(len(bytes))
-	data, err := MarshalBinary(message)
+	return c.SendMessage(message)
+}
+
+// SendWithPriority encodes a registered payload and queues it with the priority
+func (c *Connection) SendWithPriority(packet interface{}, priority Priority) error {
+	message, err := EncodeMessage(packet)
 	if err != nil {
 		return err
 	}
-	c.conn.Write(data)
-	return err
+	return c.enqueue(message, priority)
+}
+
+// SendMessage queues an already encoded message, it's used to relay messages unchanged
+func (c *Connection) SendMessage(message Message) error {
+	return c.enqueue(message, MessagePriority(message.Header.Type))
+}
+
+// enqueue never blocks the caller, a full queue drops the message or disconnects the peer
+func (c *Connection) enqueue(message Message, priority Priority) error {
+	err := c.queue.push(message, priority)
+	if overflow, ok := err.(*queueOverflow); ok && overflow.disconnect {
+		c.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("can not send message %d to %s: %s", message.Header.Type, c.RemoteAddress(), err)
+	}
+	return nil
+}
+
+// SetWriteQueueSize bounds the number of messages waiting in each priority queue
+func (c *Connection) SetWriteQueueSize(size int) {
+	c.queue.setSize(size)
+}
+
+// QueueStats returns the depth of the write queues and the messages they dropped
+func (c *Connection) QueueStats() QueueStats {
+	return c.queue.snapshot()
+}
+
+// writeLoop is the only writer of the stream, so a slow peer only holds up its own queue
+func (c *Connection) writeLoop() {
+	for {
+		message, ok := c.queue.pop()
+		if !ok {
+			return
+		}
+		if err := c.stream.WriteMessage(message); err != nil {
+			fmt.Println("can not write to ", c.RemoteAddress(), ": ", err)
+			c.Close()
+			return
+		}
+	}
 }
 
 func (c *Connection) readLoop() {
-	c.connReader = bufio.NewReader(c.conn)
 	for {
-		message := Message{
-			Header:		MessageHeader{},
-			Payload: 	make([]byte, 0),
+		message, err := c.stream.ReadMessage()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Println("can not read from ", c.RemoteAddress(), ": ", err)
+				c.Misbehave(err)
+			}
+			break
 		}
-		UnmarshalBinaryMessage(c.connReader, &message)
 		receiveMessage := ReceiveMessage{
 			Conn: 		c,
 			Message: 	message,
 		}
 		c.onReceive(receiveMessage)
 	}
-	c.onFinish(c)
+	c.Close()
+	if c.onFinish != nil {
+		c.onFinish(c)
+	}
 }
 
 func (c *Connection) Start() {
 	go c.readLoop()
+	go c.writeLoop()
 }
 
 func (c *Connection) Close()  {
-	c.connReader = nil
+	c.mutex.Lock()
 	c.isOpen = false
 	c.isSynchronizing = false
-	c.conn.Close()
+	c.mutex.Unlock()
+	c.queue.close()
+	c.calls.close()
+	c.stream.Close()
 }
\ No newline at end of file
@@ -4,9 +4,6 @@ import (
 	"reflect"
 	"consensus_layer/serializer"
 	"fmt"
-	"bufio"
-	"io"
-	"encoding/binary"
 	"consensus_layer/crypto"
 	"consensus_layer/blockchain"
 )
@@ -58,29 +55,13 @@ func UnmarshalBinary(buf []byte, v interface{}) error {
 		}
 	}
 	d.Extension = extension
-	return d.Deserialize(v)
-}
-
-func UnmarshalBinaryMessage(reader *bufio.Reader, message *Message) error {
-	typeBuf := make([]byte, 1, 1)
-	_, err := io.ReadFull(reader, typeBuf)
-	if err != nil {
+	if err := d.Deserialize(v); err != nil {
 		return err
 	}
-	messageType := MessageType(typeBuf[0])
-	lenBuf := make([]byte, 4, 4)
-	_, err = io.ReadFull(reader, lenBuf)
-	if err != nil {
-		return err
+	// bytes appended to a valid payload would make another copy of the same message
+	if d.Remaining() > 0 {
+		return fmt.Errorf("%d bytes left after the payload", d.Remaining())
 	}
-	length := binary.BigEndian.Uint32(lenBuf)
-	messageData := make([]byte, length, length)
-	n, err := io.ReadFull(reader, messageData)
-	if uint32(n) != length {
-		return fmt.Errorf("wrong length")
-	}
-	message.Header.Type = messageType
-	message.Header.Length = length
-	message.Payload = messageData
 	return nil
 }
+
@@ -0,0 +1,139 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// every frame starts with the magic of its network so peers of another network are told apart immediately
+var magicPrefix = [3]byte{0xc0, 0x5e, 0x75}
+
+const frameHeaderSize = 4 + 1 + 4 + 4 // magic, type, payload length and checksum
+
+// MaxBlockMessageSize is the limit of the blocks on a stream that isn't given the one of its genesis
+const MaxBlockMessageSize = 64 * 1024 * 1024 + 64 * 1024
+
+// the largest payload accepted for every message type, the length of a frame is checked
+// against it before anything is allocated
+var payloadLimits = struct {
+	sizes 	map[MessageType]uint32
+	mutex 	sync.RWMutex
+}{
+	sizes: map[MessageType]uint32{
+		Handshake: 		4 * 1024,
+		Notice: 		256,
+		Request: 		64 * 1024,
+		Block: 			MaxBlockMessageSize,
+		RequestNewTerm: 1024,
+		RequestVote: 	1024 * 1024,
+		GrantVote: 		1024,
+		Commit: 		1024,
+		GetAddresses: 	64,
+		Addresses: 		256 * 1024,
+		Ping: 			64,
+		Pong: 			64,
+		Headers: 		1024 * 1024,
+		RPCRequest: 	1024 * 1024 + rpcOverhead, // the size of the wrapped payload is checked against its own type
+		RPCResponse: 	MaxBlockMessageSize + rpcOverhead,
+	},
+}
+
+// MaxPayloadSize returns the largest payload of the message type, 0 for unknown types
+func MaxPayloadSize(messageType MessageType) uint32 {
+	payloadLimits.mutex.RLock()
+	defer payloadLimits.mutex.RUnlock()
+	return payloadLimits.sizes[messageType]
+}
+
+// SetMaxPayloadSize allows the message type to be received with payloads of up to size bytes.
+// It's meant to be called from init functions next to RegisterMessage.
+func SetMaxPayloadSize(messageType MessageType, size uint32) {
+	payloadLimits.mutex.Lock()
+	defer payloadLimits.mutex.Unlock()
+	payloadLimits.sizes[messageType] = size
+}
+
+// payloadLimit returns the largest payload of the message type on a stream whose blocks are bounded
+// by maxBlockSize, MaxBlockMessageSize if it's 0. The responses are bounded by the largest payload they may wrap.
+func payloadLimit(messageType MessageType, maxBlockSize uint32) (uint32, bool) {
+	if maxBlockSize == 0 {
+		maxBlockSize = MaxBlockMessageSize
+	}
+	payloadLimits.mutex.RLock()
+	defer payloadLimits.mutex.RUnlock()
+	switch messageType {
+	case Block:
+		return maxBlockSize, true
+	case RPCResponse:
+		largest := maxBlockSize
+		for messageType, max := range payloadLimits.sizes {
+			if messageType != Block && messageType != RPCRequest && messageType != RPCResponse && max > largest {
+				largest = max
+			}
+		}
+		return largest + rpcOverhead, true
+	}
+	max, ok := payloadLimits.sizes[messageType]
+	return max, ok
+}
+
+func magic(network NetworkType) []byte {
+	return append(magicPrefix[:], byte(network))
+}
+
+func checksum(payload []byte) []byte {
+	hash := sha256.Sum256(payload)
+	return hash[:4]
+}
+
+// frame is the wire format of a message: magic, type, big endian payload length, checksum and the raw payload
+func (message *Message) frame(network NetworkType) []byte {
+	data := make([]byte, 0, frameHeaderSize + len(message.Payload))
+	data = append(data, magic(network)...)
+	data = append(data, byte(message.Header.Type))
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(message.Payload)))
+	data = append(data, length...)
+	data = append(data, checksum(message.Payload)...)
+	return append(data, message.Payload...)
+}
+
+// UnmarshalBinaryMessage reads the next frame of network, a block may have up to maxBlockSize bytes
+// (MaxBlockMessageSize if it's 0). Errors of the reader, including
+// io.ErrUnexpectedEOF for a frame cut short by a closed stream, are returned as plain errors.
+// A frame that was read completely but is invalid gives a MisbehaviorError.
+// The stream can't be trusted after any error.
+func UnmarshalBinaryMessage(reader *bufio.Reader, network NetworkType, maxBlockSize uint32, message *Message) error {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:4], magic(network)) {
+		return Misbehavior(ProtocolViolation, "wrong magic %x", header[:4])
+	}
+	messageType := MessageType(header[4])
+	length := binary.BigEndian.Uint32(header[5:9])
+	maxSize, ok := payloadLimit(messageType, maxBlockSize)
+	if !ok {
+		return Misbehavior(ProtocolViolation, "unknown message type %d", messageType)
+	}
+	if length > maxSize {
+		return Misbehavior(OversizedMessage, "payload of message type %d is %d bytes, at most %d are allowed", messageType, length, maxSize)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return fmt.Errorf("truncated payload: %s", err)
+	}
+	if !bytes.Equal(checksum(payload), header[9:13]) {
+		return Misbehavior(MalformedMessage, "checksum of message type %d doesn't match", messageType)
+	}
+	message.Header.Type = messageType
+	message.Header.Length = length
+	message.Payload = payload
+	return nil
+}
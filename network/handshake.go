@@ -0,0 +1,37 @@
+package network
+
+import (
+	"consensus_layer/blockchain"
+	"consensus_layer/crypto"
+	"fmt"
+)
+
+const ProtocolVersion uint16 = 1
+
+// NewHandshakePacket signs the digest of the handshake info with the node key
+func NewHandshakePacket(info HandshakeInfo, privateKey *crypto.PrivateKey) (HandshakePacket, error) {
+	hash, err := blockchain.Digest(info)
+	if err != nil {
+		return HandshakePacket{}, err
+	}
+	sign, err := privateKey.Sign(hash[:])
+	if err != nil {
+		return HandshakePacket{}, err
+	}
+	return HandshakePacket{
+		Info: info,
+		Sign: sign,
+	}, nil
+}
+
+// Verify checks that the handshake info is signed by the key it presents
+func (packet *HandshakePacket) Verify() error {
+	hash, err := blockchain.Digest(packet.Info)
+	if err != nil {
+		return err
+	}
+	if !packet.Sign.Verify(packet.Info.Key, hash[:]) {
+		return fmt.Errorf("invalid handshake signature")
+	}
+	return nil
+}
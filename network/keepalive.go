@@ -0,0 +1,65 @@
+package network
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// latency tracks the outstanding ping of a connection and the round trip time of its peer
+type latency struct {
+	nonce 		uint64
+	sentAt 		time.Time
+	waiting 	bool // a ping was sent and its pong hasn't arrived
+	missed 		int // pings in a row that weren't answered before the next one
+	rtt 		time.Duration
+}
+
+// the weight of a new sample in the moving average of the round trip time
+const rttSmoothing = 8
+
+// Ping sends a new ping to the peer, an unanswered previous ping counts as a missed pong.
+// It returns the number of pongs missed in a row.
+func (c *Connection) Ping(now time.Time) (int, error) {
+	c.mutex.Lock()
+	if c.latency.waiting {
+		c.latency.missed++
+	}
+	c.latency.nonce = rand.Uint64()
+	c.latency.sentAt = now
+	c.latency.waiting = true
+	nonce := c.latency.nonce
+	missed := c.latency.missed
+	c.mutex.Unlock()
+	return missed, c.Send(PingPacket{Nonce: nonce})
+}
+
+// Pong answers a ping of the peer
+func (c *Connection) Pong(ping PingPacket) error {
+	return c.Send(PongPacket{Nonce: ping.Nonce})
+}
+
+// ReceivedPong updates the round trip time, only the pong of the outstanding ping is accepted
+func (c *Connection) ReceivedPong(pong PongPacket, now time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.latency.waiting || pong.Nonce != c.latency.nonce {
+		return fmt.Errorf("unsolicited pong %d", pong.Nonce)
+	}
+	sample := now.Sub(c.latency.sentAt)
+	if c.latency.rtt == 0 {
+		c.latency.rtt = sample
+	} else {
+		c.latency.rtt += (sample - c.latency.rtt) / rttSmoothing
+	}
+	c.latency.waiting = false
+	c.latency.missed = 0
+	return nil
+}
+
+// RTT returns the moving average of the round trip time, 0 until the first pong
+func (c *Connection) RTT() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.latency.rtt
+}
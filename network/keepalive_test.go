@@ -0,0 +1,49 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPingPong(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			if _, err := remote.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	c := newConnection()
+	c.stream = newTCPStream(local, TestNet)
+	defer c.Close()
+	now := time.Now()
+	if missed, err := c.Ping(now); err != nil || missed != 0 {
+		t.Fatal("first ping shouldn't miss a pong", err)
+	}
+	if err := c.ReceivedPong(PongPacket{Nonce: c.latency.nonce + 1}, now); err == nil {
+		t.Fatal("pong with another nonce should be rejected")
+	}
+	if err := c.ReceivedPong(PongPacket{Nonce: c.latency.nonce}, now.Add(80 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if c.RTT() != 80 * time.Millisecond {
+		t.Fatal("first sample should be the round trip time")
+	}
+	if err := c.ReceivedPong(PongPacket{Nonce: c.latency.nonce}, now); err == nil {
+		t.Fatal("duplicated pong should be rejected")
+	}
+	c.Ping(now)
+	c.ReceivedPong(PongPacket{Nonce: c.latency.nonce}, now.Add(160 * time.Millisecond))
+	if c.RTT() != 90 * time.Millisecond {
+		t.Fatal("round trip time should be averaged, got ", c.RTT())
+	}
+	c.Ping(now)
+	c.Ping(now)
+	if missed, _ := c.Ping(now); missed != 2 {
+		t.Fatal("unanswered pings should be counted, got ", missed)
+	}
+}
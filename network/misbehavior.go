@@ -0,0 +1,101 @@
+package network
+
+import (
+	"fmt"
+)
+
+type Offense byte
+const (
+	MalformedMessage Offense = iota // the payload can't be decoded
+	UnsolicitedMessage // a response nobody asked for
+	OversizedMessage // more items than the protocol allows
+	InvalidBlock
+	InvalidSignature
+	ProtocolViolation // e.g. a message before the handshake
+	UnservedRequest // the peer didn't answer a request for data it announced
+)
+
+// the penalties of the offenses, a peer is banned once its score reaches the threshold of the node.
+// Only a forged signature reaches the default threshold at once, the offenses an honest peer may
+// commit in a race or because of a bug are banned when they are repeated.
+var penalties = map[Offense]int{
+	MalformedMessage: 	20,
+	UnsolicitedMessage: 5,
+	OversizedMessage: 	50,
+	InvalidBlock: 		50,
+	InvalidSignature: 	100,
+	ProtocolViolation: 	25,
+	UnservedRequest: 	10,
+}
+
+func (offense Offense) Penalty() int {
+	return penalties[offense]
+}
+
+func (offense Offense) String() string {
+	switch offense {
+	case MalformedMessage:
+		return "malformed message"
+	case UnsolicitedMessage:
+		return "unsolicited message"
+	case OversizedMessage:
+		return "oversized message"
+	case InvalidBlock:
+		return "invalid block"
+	case InvalidSignature:
+		return "invalid signature"
+	case ProtocolViolation:
+		return "protocol violation"
+	case UnservedRequest:
+		return "unserved request"
+	}
+	return fmt.Sprintf("offense %d", byte(offense))
+}
+
+// MisbehaviorError is returned by handlers when the peer sent something an honest peer wouldn't
+type MisbehaviorError struct {
+	Offense Offense
+	Reason 	string
+}
+
+func (err *MisbehaviorError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Offense, err.Reason)
+}
+
+func Misbehavior(offense Offense, format string, args ...interface{}) error {
+	return &MisbehaviorError{
+		Offense: 	offense,
+		Reason: 	fmt.Sprintf(format, args...),
+	}
+}
+
+type MisbehaveFunc func(c *Connection, err *MisbehaviorError)
+
+// SetMisbehaveFunc sets the handler of the offenses of the peer
+func (c *Connection) SetMisbehaveFunc(onMisbehave MisbehaveFunc) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onMisbehave = onMisbehave
+}
+
+// Misbehave reports err if it's a MisbehaviorError, other errors aren't the fault of the peer
+func (c *Connection) Misbehave(err error) {
+	misbehavior, ok := err.(*MisbehaviorError)
+	if !ok {
+		return
+	}
+	c.mutex.Lock()
+	c.offenses++
+	onMisbehave := c.onMisbehave
+	c.mutex.Unlock()
+	if onMisbehave != nil {
+		onMisbehave(c, misbehavior)
+	}
+}
+
+// Offenses returns the number of offenses reported on the connection
+func (c *Connection) Offenses() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.offenses
+}
@@ -0,0 +1,79 @@
+package network
+
+import (
+	"fmt"
+)
+
+// MinProtocolVersion is the oldest version this node still speaks, ProtocolVersion the newest
+const MinProtocolVersion uint16 = 1
+
+// Capability is a bitset of the optional features of the protocol
+type Capability uint64
+const (
+	CapabilityAddressExchange Capability = 1 << iota // GetAddresses and Addresses
+	CapabilityKeepalive // Ping and Pong
+	CapabilityHeaderSync // Notice, Request and Headers
+	CapabilityRPC // RPCRequest and RPCResponse
+)
+
+const SupportedCapabilities = CapabilityAddressExchange | CapabilityKeepalive | CapabilityHeaderSync | CapabilityRPC
+
+// RequiredCapabilities must be offered by every peer, a silent peer is only noticed through the missed pongs
+const RequiredCapabilities = CapabilityKeepalive
+
+// the messages of an optional feature are only accepted when both peers agreed on it
+var messageCapabilities = map[MessageType]Capability{
+	GetAddresses: 	CapabilityAddressExchange,
+	Addresses: 		CapabilityAddressExchange,
+	Ping: 			CapabilityKeepalive,
+	Pong: 			CapabilityKeepalive,
+	Notice: 		CapabilityHeaderSync,
+	Request: 		CapabilityHeaderSync,
+	Headers: 		CapabilityHeaderSync,
+	RPCRequest: 	CapabilityRPC,
+	RPCResponse: 	CapabilityRPC,
+}
+
+func (capabilities Capability) Has(capability Capability) bool {
+	return capabilities & capability == capability
+}
+
+// RequiredCapability returns the capability a message type belongs to
+func RequiredCapability(messageType MessageType) (Capability, bool) {
+	capability, ok := messageCapabilities[messageType]
+	return capability, ok
+}
+
+// NegotiateVersion returns the highest version in both ranges
+func NegotiateVersion(localMin uint16, localMax uint16, remoteMin uint16, remoteMax uint16) (uint16, error) {
+	version := localMax
+	if remoteMax < version {
+		version = remoteMax
+	}
+	if version < localMin || version < remoteMin || remoteMin > remoteMax {
+		return 0, fmt.Errorf("no common version between %d-%d and %d-%d", localMin, localMax, remoteMin, remoteMax)
+	}
+	return version, nil
+}
+
+// SetProtocol records the version and the capabilities agreed with the peer
+func (c *Connection) SetProtocol(version uint16, capabilities Capability) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.version = version
+	c.capabilities = capabilities
+}
+
+// Version returns the agreed version, 0 before the handshake
+func (c *Connection) Version() uint16 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.version
+}
+
+// Supports reports whether both peers agreed on the capability
+func (c *Connection) Supports(capability Capability) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.capabilities.Has(capability)
+}
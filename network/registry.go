@@ -0,0 +1,98 @@
+package network
+
+import (
+	"reflect"
+	"fmt"
+	"sync"
+	"consensus_layer/blockchain"
+)
+
+// the registry maps payload types to message types so that any registered payload can be sent and decoded
+var registry = struct {
+	messageTypes 	map[reflect.Type]MessageType
+	payloadTypes 	map[MessageType]reflect.Type
+	mutex 			sync.RWMutex
+}{
+	messageTypes: 	make(map[reflect.Type]MessageType, 0),
+	payloadTypes: 	make(map[MessageType]reflect.Type, 0),
+}
+
+func init() {
+	RegisterMessage(Handshake, HandshakePacket{})
+	RegisterMessage(Block, blockchain.SignedBlock{})
+	RegisterMessage(Commit, blockchain.Commit{})
+	RegisterMessage(GetAddresses, GetAddressesPacket{})
+	RegisterMessage(Addresses, AddressesPacket{})
+	RegisterMessage(Ping, PingPacket{})
+	RegisterMessage(Pong, PongPacket{})
+	RegisterMessage(Notice, NoticePacket{})
+	RegisterMessage(Request, RequestPacket{})
+	RegisterMessage(Headers, HeadersPacket{})
+	RegisterMessage(RPCRequest, RPCRequestPacket{})
+	RegisterMessage(RPCResponse, RPCResponsePacket{})
+}
+
+// RegisterMessage binds the Go type of payload to messageType.
+// It's meant to be called from init functions and panics if either side is already registered.
+func RegisterMessage(messageType MessageType, payload interface{}) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	payloadType := reflect.Indirect(reflect.ValueOf(payload)).Type()
+	if t, ok := registry.payloadTypes[messageType]; ok {
+		panic(fmt.Sprintf("message type %d is already registered for %s", messageType, t))
+	}
+	if t, ok := registry.messageTypes[payloadType]; ok {
+		panic(fmt.Sprintf("%s is already registered as message type %d", payloadType, t))
+	}
+	registry.messageTypes[payloadType] = messageType
+	registry.payloadTypes[messageType] = payloadType
+}
+
+// MessageTypeOf returns the message type of a registered payload
+func MessageTypeOf(payload interface{}) (MessageType, error) {
+	if payload == nil {
+		return 0, fmt.Errorf("payload is nil")
+	}
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	payloadType := reflect.Indirect(reflect.ValueOf(payload)).Type()
+	messageType, ok := registry.messageTypes[payloadType]
+	if !ok {
+		return 0, fmt.Errorf("%s isn't a registered message", payloadType)
+	}
+	return messageType, nil
+}
+
+// EncodeMessage serializes a registered payload into a message
+func EncodeMessage(payload interface{}) (Message, error) {
+	messageType, err := MessageTypeOf(payload)
+	if err != nil {
+		return Message{}, err
+	}
+	bytes, err := MarshalBinary(reflect.Indirect(reflect.ValueOf(payload)).Interface())
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{
+		Header: MessageHeader{
+			Type: 	messageType,
+			Length: uint32(len(bytes)),
+		},
+		Payload: bytes,
+	}, nil
+}
+
+// DecodeMessage deserializes the payload of a message into a value of its registered type
+func DecodeMessage(message Message) (interface{}, error) {
+	registry.mutex.RLock()
+	payloadType, ok := registry.payloadTypes[message.Header.Type]
+	registry.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("message type %d isn't registered", message.Header.Type)
+	}
+	payload := reflect.New(payloadType)
+	if err := UnmarshalBinary(message.Payload, payload.Interface()); err != nil {
+		return nil, err
+	}
+	return payload.Elem().Interface(), nil
+}
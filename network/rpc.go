@@ -0,0 +1,234 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const DefaultCallTimeout = 10 * time.Second
+const DefaultMaxConcurrentRequests = 64
+const rpcOverhead = 1024 // id, timeout, type and length prefixes of the wrapped payload
+
+// RPCHandler answers a request of a peer, ctx is done once the caller stopped waiting
+type RPCHandler func(ctx context.Context, conn *Connection, request interface{}) (interface{}, error)
+
+// RPCServer answers the requests of the peers with the handler of their payload type
+type RPCServer struct {
+	handlers 	map[MessageType]RPCHandler
+	slots 		chan struct{} // bounds the requests served at the same time
+	mutex 		sync.RWMutex
+}
+
+func NewRPCServer(maxConcurrentRequests int) *RPCServer {
+	if maxConcurrentRequests <= 0 {
+		maxConcurrentRequests = DefaultMaxConcurrentRequests
+	}
+	return &RPCServer{
+		handlers: 	make(map[MessageType]RPCHandler, 0),
+		slots: 		make(chan struct{}, maxConcurrentRequests),
+	}
+}
+
+// Handle serves the requests whose payload is of messageType with handler
+func (server *RPCServer) Handle(messageType MessageType, handler RPCHandler) error {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if _, ok := server.handlers[messageType]; ok {
+		return fmt.Errorf("requests of message %d already have a handler", messageType)
+	}
+	server.handlers[messageType] = handler
+	return nil
+}
+
+// Serve runs the handler of the request and sends its response, it blocks until the handler returns.
+// Nothing is sent if the caller stopped waiting or the connection closed in the meantime.
+func (server *RPCServer) Serve(conn *Connection, request RPCRequestPacket) {
+	payload, err := wrappedPayload(request.Type, request.Payload)
+	if err != nil {
+		conn.Misbehave(err)
+		return
+	}
+	server.mutex.RLock()
+	handler, ok := server.handlers[request.Type]
+	server.mutex.RUnlock()
+	response := RPCResponsePacket{Id: request.Id}
+	if !ok {
+		response.Error = fmt.Sprintf("requests of message %d aren't served", request.Type)
+	} else {
+		select {
+		case server.slots <- struct{}{}:
+			response, ok = server.run(conn, handler, request, payload)
+			<-server.slots
+			if !ok {
+				return
+			}
+		default:
+			response.Error = "too many concurrent requests"
+		}
+	}
+	if err := conn.SendWithPriority(response, MessagePriority(request.Type)); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// run returns the response of the handler, false if the caller stopped waiting before it
+func (server *RPCServer) run(conn *Connection, handler RPCHandler, request RPCRequestPacket, payload interface{}) (RPCResponsePacket, bool) {
+	response := RPCResponsePacket{Id: request.Id}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(request.Timeout) * time.Millisecond)
+	defer cancel()
+	go func() {
+		select {
+		case <-conn.calls.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	result, err := handler(ctx, conn, payload)
+	if ctx.Err() != nil {
+		return response, false
+	}
+	if err == nil {
+		var message Message
+		if message, err = EncodeMessage(result); err == nil {
+			response.Type = message.Header.Type
+			response.Payload = message.Payload
+		}
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	return response, true
+}
+
+// wrappedPayload decodes the payload of a request or a response, it's held to the limit of its own type
+func wrappedPayload(messageType MessageType, payload []byte) (interface{}, error) {
+	if max := MaxPayloadSize(messageType); uint32(len(payload)) > max {
+		return nil, Misbehavior(OversizedMessage, "wrapped payload of message %d has %d bytes, the limit is %d", messageType, len(payload), max)
+	}
+	value, err := DecodeMessage(Message{
+		Header: 	MessageHeader{Type: messageType, Length: uint32(len(payload))},
+		Payload: 	payload,
+	})
+	if err != nil {
+		return nil, Misbehavior(MalformedMessage, "%s", err)
+	}
+	return value, nil
+}
+
+// rpcCalls tracks the requests of a connection waiting for their response
+type rpcCalls struct {
+	lastId 		uint64
+	pending 	map[uint64]chan RPCResponsePacket
+	closed 		chan struct{} // closed with the connection, every waiting call fails
+	closeOnce 	sync.Once
+	mutex 		sync.Mutex
+}
+
+func newRPCCalls() *rpcCalls {
+	return &rpcCalls{
+		pending: 	make(map[uint64]chan RPCResponsePacket, 0),
+		closed: 	make(chan struct{}),
+	}
+}
+
+func (calls *rpcCalls) add() (uint64, chan RPCResponsePacket) {
+	calls.mutex.Lock()
+	defer calls.mutex.Unlock()
+	calls.lastId++
+	responses := make(chan RPCResponsePacket, 1)
+	calls.pending[calls.lastId] = responses
+	return calls.lastId, responses
+}
+
+func (calls *rpcCalls) remove(id uint64) {
+	calls.mutex.Lock()
+	defer calls.mutex.Unlock()
+	delete(calls.pending, id)
+}
+
+// take removes the call waiting for the response. A response to an id that was never sent is
+// unsolicited, one to a call that timed out, was cancelled or was already answered is late.
+func (calls *rpcCalls) take(id uint64) (chan RPCResponsePacket, error) {
+	calls.mutex.Lock()
+	defer calls.mutex.Unlock()
+	if id == 0 || id > calls.lastId {
+		return nil, Misbehavior(UnsolicitedMessage, "response to request %d that wasn't sent", id)
+	}
+	responses, ok := calls.pending[id]
+	if !ok {
+		return nil, fmt.Errorf("late response to request %d", id)
+	}
+	delete(calls.pending, id)
+	return responses, nil
+}
+
+func (calls *rpcCalls) close() {
+	calls.closeOnce.Do(func() {
+		close(calls.closed)
+	})
+}
+
+// Call sends the request to the peer and waits for its response until ctx is done,
+// DefaultCallTimeout applies if ctx has no deadline. The peer gets the time left
+// so it can give up on the request when the caller does.
+func (c *Connection) Call(ctx context.Context, request interface{}) (interface{}, error) {
+	if !c.Supports(CapabilityRPC) {
+		return nil, fmt.Errorf("%s doesn't support requests", c.RemoteAddress())
+	}
+	message, err := EncodeMessage(request)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultCallTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+	timeout := time.Until(deadline) / time.Millisecond
+	if timeout <= 0 {
+		return nil, context.DeadlineExceeded
+	}
+	if timeout > 1 << 32 - 1 {
+		timeout = 1 << 32 - 1
+	}
+	id, responses := c.calls.add()
+	defer c.calls.remove(id)
+	packet := RPCRequestPacket{
+		Id: 		id,
+		Timeout: 	uint32(timeout),
+		Type: 		message.Header.Type,
+		Payload: 	message.Payload,
+	}
+	if err := c.SendWithPriority(packet, MessagePriority(message.Header.Type)); err != nil {
+		return nil, err
+	}
+	select {
+	case response := <-responses:
+		if response.Error != "" {
+			return nil, fmt.Errorf("request %d to %s failed: %s", id, c.RemoteAddress(), response.Error)
+		}
+		payload, err := wrappedPayload(response.Type, response.Payload)
+		if err != nil {
+			c.Misbehave(err)
+			return nil, err
+		}
+		return payload, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.calls.closed:
+		return nil, fmt.Errorf("connection to %s is closed", c.RemoteAddress())
+	}
+}
+
+// ReceivedResponse hands the response to the call waiting for it
+func (c *Connection) ReceivedResponse(response RPCResponsePacket) error {
+	responses, err := c.calls.take(response.Id)
+	if err != nil {
+		return err
+	}
+	responses <- response
+	return nil
+}
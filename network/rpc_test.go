@@ -0,0 +1,145 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+	"consensus_layer/crypto"
+)
+
+// newRPCConnections connects a client to a server answering with the handlers of server,
+// the errors of the responses received by the client are sent to responseErrors
+func newRPCConnections(t *testing.T, server *RPCServer, responseErrors chan error) (*Connection, *Connection) {
+	sim := NewSimNetwork(SimConfig{Seed: 1, Latency: time.Millisecond})
+	serverKey, _ := crypto.NewRandomPrivateKey()
+	clientKey, _ := crypto.NewRandomPrivateKey()
+	listener, err := sim.Transport("10.0.0.1:9000").Listen("10.0.0.1:9000", serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	onReceive := func(receiveMessage ReceiveMessage) {
+		payload, err := DecodeMessage(receiveMessage.Message)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		switch packet := payload.(type) {
+		case RPCRequestPacket:
+			go server.Serve(receiveMessage.Conn, packet)
+		case RPCResponsePacket:
+			responseErrors <- receiveMessage.Conn.ReceivedResponse(packet)
+		}
+	}
+	client, err := NewOutgoingConnection(sim.Transport("10.0.0.2:9000"), "10.0.0.1:9000", clientKey, onReceive, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn := NewIncomingConnection(stream, onReceive, nil)
+	for _, c := range []*Connection{client, serverConn} {
+		c.SetProtocol(ProtocolVersion, CapabilityRPC)
+		c.Start()
+	}
+	return client, serverConn
+}
+
+func TestRPCCall(t *testing.T) {
+	server := NewRPCServer(0)
+	err := server.Handle(Ping, func(ctx context.Context, conn *Connection, request interface{}) (interface{}, error) {
+		ping := request.(PingPacket)
+		if ping.Nonce == 0 {
+			return nil, fmt.Errorf("nonce is zero")
+		}
+		return PongPacket{Nonce: ping.Nonce}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Handle(Ping, nil); err == nil {
+		t.Fatal("second handler of a message should be rejected")
+	}
+	responseErrors := make(chan error, 10)
+	client, serverConn := newRPCConnections(t, server, responseErrors)
+	defer client.Close()
+	defer serverConn.Close()
+
+	response, err := client.Call(context.Background(), PingPacket{Nonce: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pong, ok := response.(PongPacket); !ok || pong.Nonce != 7 {
+		t.Fatal("response should be matched to its request")
+	}
+	if _, err := client.Call(context.Background(), PingPacket{Nonce: 0}); err == nil {
+		t.Fatal("error of the handler should be returned")
+	}
+	if _, err := client.Call(context.Background(), NoticePacket{}); err == nil {
+		t.Fatal("request without handler should fail")
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-responseErrors; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRPCTimeout(t *testing.T) {
+	server := NewRPCServer(0)
+	release := make(chan struct{})
+	server.Handle(Ping, func(ctx context.Context, conn *Connection, request interface{}) (interface{}, error) {
+		<-release
+		return PongPacket{}, nil
+	})
+	cancelled := make(chan error, 1)
+	server.Handle(Notice, func(ctx context.Context, conn *Connection, request interface{}) (interface{}, error) {
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	responseErrors := make(chan error, 10)
+	client, serverConn := newRPCConnections(t, server, responseErrors)
+	defer client.Close()
+	defer serverConn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+	if _, err := client.Call(ctx, PingPacket{Nonce: 1}); err != context.DeadlineExceeded {
+		t.Fatal("call should time out, got ", err)
+	}
+	// the response of the handler arrives after the call gave up
+	close(release)
+
+	// a late response is rejected without penalty, an unsolicited one is misbehavior
+	if err := client.ReceivedResponse(RPCResponsePacket{Id: 1}); err == nil {
+		t.Fatal("late response should be rejected")
+	} else if _, ok := err.(*MisbehaviorError); ok {
+		t.Fatal("late response shouldn't be misbehavior")
+	}
+	if err, ok := client.ReceivedResponse(RPCResponsePacket{Id: 100}).(*MisbehaviorError); !ok || err.Offense != UnsolicitedMessage {
+		t.Fatal("unsolicited response should be misbehavior")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := client.Call(ctx, NoticePacket{}); err != context.Canceled {
+		t.Fatal("call should be cancelled, got ", err)
+	}
+	// the peer gives up on the request once the connection is gone
+	serverConn.Close()
+	if err := <-cancelled; err != context.Canceled {
+		t.Fatal("handler should be cancelled when the connection closes, got ", err)
+	}
+	for len(responseErrors) > 0 {
+		err := <-responseErrors
+		if _, ok := err.(*MisbehaviorError); err == nil || ok {
+			t.Fatal("only late responses should be received, got ", err)
+		}
+	}
+}
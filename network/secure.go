@@ -0,0 +1,249 @@
+package network
+
+import (
+	"bytes"
+	"consensus_layer/crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"github.com/btcsuite/btcd/btcec"
+)
+
+const secureChannelVersion = 1
+const secureHandshakeTimeout = 10 * time.Second
+const maxRecordPayload = 1 << 16
+const helloSize = 1 + 33 + 33 // version, static key and ephemeral key, both compressed
+
+// a direction switches to a new key after this many records so a key never encrypts too much data
+var rekeyInterval uint64 = 1 << 20
+
+// cipherState encrypts the records of one direction with AES-256-GCM, the nonce is the record counter
+type cipherState struct {
+	key 	[32]byte
+	aead 	cipher.AEAD
+	counter uint64
+}
+
+func newCipherState(key [32]byte) (*cipherState, error) {
+	cs := &cipherState{}
+	if err := cs.setKey(key); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+func (cs *cipherState) setKey(key [32]byte) error {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	cs.key = key
+	cs.aead = aead
+	cs.counter = 0
+	return nil
+}
+
+func (cs *cipherState) nonce() []byte {
+	nonce := make([]byte, cs.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce) - 8:], cs.counter)
+	return nonce
+}
+
+// advance moves to the next nonce, both sides derive the next key on their own when the interval is reached
+func (cs *cipherState) advance() error {
+	cs.counter++
+	if cs.counter < rekeyInterval {
+		return nil
+	}
+	return cs.setKey(sha256.Sum256(append(cs.key[:], []byte("rekey")...)))
+}
+
+func (cs *cipherState) seal(plaintext []byte) ([]byte, error) {
+	ciphertext := cs.aead.Seal(nil, cs.nonce(), plaintext, nil)
+	return ciphertext, cs.advance()
+}
+
+func (cs *cipherState) open(ciphertext []byte) ([]byte, error) {
+	plaintext, err := cs.aead.Open(nil, cs.nonce(), ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+	return plaintext, cs.advance()
+}
+
+// SecureConn encrypts and authenticates a connection with keys agreed by an ephemeral ECDH.
+// Each side proves that it owns its static node key by signing the transcript of the key exchange.
+type SecureConn struct {
+	net.Conn
+	remoteKey 	crypto.PublicKey
+	send 		*cipherState
+	receive 	*cipherState
+	readBuffer 	[]byte
+	readMutex 	sync.Mutex
+	writeMutex 	sync.Mutex
+}
+
+func NewSecureConn(conn net.Conn, privateKey *crypto.PrivateKey, initiator bool) (*SecureConn, error) {
+	conn.SetDeadline(time.Now().Add(secureHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	ephemeralKey, err := crypto.NewRandomPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	hello := make([]byte, 0, helloSize)
+	hello = append(hello, secureChannelVersion)
+	hello = append(hello, privateKey.PublicKey().Data...)
+	hello = append(hello, ephemeralKey.PublicKey().Data...)
+	remoteHello := make([]byte, helloSize)
+	if err := exchange(conn, hello, remoteHello); err != nil {
+		return nil, err
+	}
+	if remoteHello[0] != secureChannelVersion {
+		return nil, fmt.Errorf("unsupported secure channel version %d", remoteHello[0])
+	}
+	remoteStatic := crypto.PublicKey{Data: remoteHello[1:34]}
+	remoteEphemeral, err := btcec.ParsePubKey(remoteHello[34:], btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(remoteEphemeral.SerializeCompressed(), ephemeralKey.PublicKey().Data) {
+		return nil, fmt.Errorf("reflected key exchange")
+	}
+
+	// the transcript binds the keys of both sides in the order of their roles
+	transcript := append(append([]byte{}, hello...), remoteHello...)
+	if !initiator {
+		transcript = append(append([]byte{}, remoteHello...), hello...)
+	}
+	transcriptHash := sha256.Sum256(transcript)
+	secret := btcec.GenerateSharedSecret(ephemeralKey.PrivateKey, remoteEphemeral)
+	initiatorKey := sessionKey(secret, transcriptHash, "initiator")
+	responderKey := sessionKey(secret, transcriptHash, "responder")
+	s := &SecureConn{Conn: conn, remoteKey: remoteStatic}
+	sendKey, receiveKey := initiatorKey, responderKey
+	localRole, remoteRole := "initiator", "responder"
+	if !initiator {
+		sendKey, receiveKey = responderKey, initiatorKey
+		localRole, remoteRole = "responder", "initiator"
+	}
+	if s.send, err = newCipherState(sendKey); err != nil {
+		return nil, err
+	}
+	if s.receive, err = newCipherState(receiveKey); err != nil {
+		return nil, err
+	}
+
+	// the proofs are exchanged as the first encrypted records
+	localHash := authHash(transcriptHash, localRole)
+	signature, err := privateKey.Sign(localHash[:])
+	if err != nil {
+		return nil, err
+	}
+	proof, err := s.send.seal(signature.Data)
+	if err != nil {
+		return nil, err
+	}
+	remoteProof := make([]byte, len(proof))
+	if err := exchange(conn, proof, remoteProof); err != nil {
+		return nil, err
+	}
+	remoteSignature, err := s.receive.open(remoteProof)
+	if err != nil {
+		return nil, err
+	}
+	remoteHash := authHash(transcriptHash, remoteRole)
+	sig := crypto.Signature{Data: remoteSignature}
+	if !sig.Verify(remoteStatic, remoteHash[:]) {
+		return nil, fmt.Errorf("peer doesn't own its static key")
+	}
+	return s, nil
+}
+
+// exchange writes and reads at the same time, synchronous transports would block otherwise
+func exchange(conn net.Conn, out []byte, in []byte) error {
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(out)
+		written <- err
+	}()
+	_, err := io.ReadFull(conn, in)
+	if writeErr := <-written; err == nil {
+		err = writeErr
+	}
+	return err
+}
+
+func sessionKey(secret []byte, transcriptHash [32]byte, role string) [32]byte {
+	data := append(append(append([]byte{}, secret...), transcriptHash[:]...), []byte(role)...)
+	return sha256.Sum256(data)
+}
+
+func authHash(transcriptHash [32]byte, role string) [32]byte {
+	return sha256.Sum256(append([]byte("auth " + role), transcriptHash[:]...))
+}
+
+// RemoteKey is the static key the peer proved to own
+func (s *SecureConn) RemoteKey() crypto.PublicKey {
+	return s.remoteKey
+}
+
+// Write splits p into records of at most maxRecordPayload bytes, each sent as its length and ciphertext
+func (s *SecureConn) Write(p []byte) (int, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	written := 0
+	for written < len(p) {
+		end := written + maxRecordPayload
+		if end > len(p) {
+			end = len(p)
+		}
+		ciphertext, err := s.send.seal(p[written:end])
+		if err != nil {
+			return written, err
+		}
+		record := make([]byte, 4, 4 + len(ciphertext))
+		binary.BigEndian.PutUint32(record, uint32(len(ciphertext)))
+		if _, err := s.Conn.Write(append(record, ciphertext...)); err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+func (s *SecureConn) Read(p []byte) (int, error) {
+	s.readMutex.Lock()
+	defer s.readMutex.Unlock()
+	for len(s.readBuffer) == 0 {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(s.Conn, header); err != nil {
+			return 0, err
+		}
+		length := binary.BigEndian.Uint32(header)
+		if length > maxRecordPayload + uint32(s.receive.aead.Overhead()) {
+			return 0, fmt.Errorf("record of %d bytes is too large", length)
+		}
+		ciphertext := make([]byte, length)
+		if _, err := io.ReadFull(s.Conn, ciphertext); err != nil {
+			return 0, err
+		}
+		plaintext, err := s.receive.open(ciphertext)
+		if err != nil {
+			return 0, err
+		}
+		s.readBuffer = plaintext
+	}
+	n := copy(p, s.readBuffer)
+	s.readBuffer = s.readBuffer[n:]
+	return n, nil
+}
@@ -0,0 +1,74 @@
+package network
+
+import (
+	"bytes"
+	"consensus_layer/crypto"
+	"io"
+	"net"
+	"testing"
+)
+
+func newSecurePair(t *testing.T) (*SecureConn, *SecureConn, *crypto.PrivateKey, *crypto.PrivateKey) {
+	key1, _ := crypto.NewRandomPrivateKey()
+	key2, _ := crypto.NewRandomPrivateKey()
+	conn1, conn2 := net.Pipe()
+	type result struct {
+		conn 	*SecureConn
+		err 	error
+	}
+	responder := make(chan result, 1)
+	go func() {
+		s, err := NewSecureConn(conn2, key2, false)
+		responder <- result{s, err}
+	}()
+	s1, err := NewSecureConn(conn1, key1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-responder
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	return s1, r.conn, key1, key2
+}
+
+func TestSecureConn(t *testing.T) {
+	s1, s2, key1, key2 := newSecurePair(t)
+	defer s1.Close()
+	defer s2.Close()
+	if !bytes.Equal(s1.RemoteKey().Data, key2.PublicKey().Data) || !bytes.Equal(s2.RemoteKey().Data, key1.PublicKey().Data) {
+		t.Fatal("both sides should learn the static key of the other")
+	}
+	rekeyInterval = 3
+	defer func() { rekeyInterval = 1 << 20 }()
+	// larger than a record, so it's split and crosses a rekey
+	data := make([]byte, 3 * maxRecordPayload + 10)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	for i := 0; i < 2; i++ {
+		go s1.Write(data)
+		received := make([]byte, len(data))
+		if _, err := io.ReadFull(s2, received); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(received, data) {
+			t.Fatal("data should be the same")
+		}
+	}
+}
+
+func TestSecureConnTampered(t *testing.T) {
+	s1, s2, _, _ := newSecurePair(t)
+	defer s1.Close()
+	defer s2.Close()
+	go func() {
+		ciphertext, _ := s1.send.seal([]byte("vote"))
+		ciphertext[0] ^= 1
+		record := []byte{0, 0, 0, byte(len(ciphertext))}
+		s1.Conn.Write(append(record, ciphertext...))
+	}()
+	if _, err := s2.Read(make([]byte, 16)); err == nil {
+		t.Fatal("tampered record should be rejected")
+	}
+}
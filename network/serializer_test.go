@@ -0,0 +1,235 @@
+package network
+
+import (
+	"testing"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"consensus_layer/blockchain"
+	"consensus_layer/crypto"
+	"time"
+)
+
+func TestMerkleProofSerializer(t *testing.T) {
+	leaves := []blockchain.SHA256Type{{1}, {2}, {3}}
+	tree := blockchain.NewMerkleTree(leaves)
+	proof1, err := tree.Proof(2, leaves[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := MarshalBinary(*proof1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof2 := blockchain.MerkleProof{}
+	if err := UnmarshalBinary(buf, &proof2); err != nil {
+		t.Fatal(err)
+	}
+	if !proof2.Verify(tree.Root()) {
+		t.Fatal("deserialized proof should be valid")
+	}
+}
+
+type unregisteredPacket struct {
+	Value uint32
+}
+
+func TestMessageRegistry(t *testing.T) {
+	commit := blockchain.Commit{
+		Type: 		blockchain.Commitment,
+		BlockId: 	blockchain.SHA256Type{1},
+		Committer: 	"producer1",
+	}
+	message, err := EncodeMessage(&commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Header.Type != Commit || message.Header.Length != uint32(len(message.Payload)) {
+		t.Fatal("wrong header")
+	}
+	payload, err := DecodeMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, ok := payload.(blockchain.Commit)
+	if !ok || decoded.BlockId != commit.BlockId || decoded.Committer != commit.Committer {
+		t.Fatal("decoded commit should be the same")
+	}
+	if _, err := EncodeMessage(unregisteredPacket{}); err == nil {
+		t.Fatal("unregistered payload should be rejected")
+	}
+	reader := bufio.NewReader(bytes.NewReader(message.frame(TestNet)))
+	received := Message{}
+	if err := UnmarshalBinaryMessage(reader, TestNet, 0, &received); err != nil {
+		t.Fatal(err)
+	}
+	if received.Header.Type != Commit || !bytes.Equal(received.Payload, message.Payload) {
+		t.Fatal("framed message should be read back")
+	}
+	message.Payload = append(message.Payload, 0)
+	message.Header.Length++
+	if _, err := DecodeMessage(message); err == nil {
+		t.Fatal("payload with trailing bytes should be rejected")
+	}
+}
+
+// lengths beyond the payload are rejected before anything is allocated or sliced
+func TestDecodeMessageHugeLengths(t *testing.T) {
+	decode := func(messageType MessageType, payload []byte) error {
+		_, err := DecodeMessage(Message{
+			Header: 	MessageHeader{Type: messageType, Length: uint32(len(payload))},
+			Payload: 	payload,
+		})
+		return err
+	}
+	huge := func(l uint64) []byte {
+		buf := make([]byte, binary.MaxVarintLen64)
+		return buf[:binary.PutUvarint(buf, l)]
+	}
+	// a slice of 1<<62 addresses in a 10 byte payload
+	if err := decode(Addresses, huge(1 << 62)); err == nil {
+		t.Fatal("slice longer than the payload should be rejected")
+	}
+	// every address takes at least 13 bytes
+	if err := decode(Addresses, append(huge(2), make([]byte, 20)...)); err == nil {
+		t.Fatal("slice whose elements can't fit in the payload should be rejected")
+	}
+	// a byte string whose length turns negative as an int
+	request := append(make([]byte, 8 + 4 + 1), huge(1 << 63 + 5)...)
+	if err := decode(RPCRequest, request); err == nil {
+		t.Fatal("byte string longer than the payload should be rejected")
+	}
+	response := append(make([]byte, 8 + 1 + 1), huge(1 << 40)...)
+	if err := decode(RPCResponse, append(response, 'x')); err == nil {
+		t.Fatal("string longer than the payload should be rejected")
+	}
+}
+
+func TestHandshakePacket(t *testing.T) {
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	info := HandshakeInfo{
+		Network: 	TestNet,
+		Version: 	ProtocolVersion,
+		Key: 		*privateKey.PublicKey(),
+		Timestamp: 	time.Now(),
+	}
+	packet1, err := NewHandshakePacket(info, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, _ := EncodeMessage(packet1)
+	payload, err := DecodeMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet2 := payload.(HandshakePacket)
+	if err := packet2.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if !packet2.Info.Timestamp.Equal(info.Timestamp) {
+		t.Fatal("timestamp should be the same")
+	}
+	otherKey, _ := crypto.NewRandomPrivateKey()
+	packet2.Info.Key = *otherKey.PublicKey()
+	if err := packet2.Verify(); err == nil {
+		t.Fatal("handshake signed by another key should be rejected")
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	if version, err := NegotiateVersion(1, 3, 2, 5); err != nil || version != 3 {
+		t.Fatal("highest common version should be 3, got ", version, err)
+	}
+	if version, err := NegotiateVersion(2, 4, 1, 2); err != nil || version != 2 {
+		t.Fatal("highest common version should be 2, got ", version, err)
+	}
+	if _, err := NegotiateVersion(3, 4, 1, 2); err == nil {
+		t.Fatal("disjoint ranges should be rejected")
+	}
+	capabilities := CapabilityKeepalive | CapabilityHeaderSync
+	if !capabilities.Has(CapabilityKeepalive) || capabilities.Has(CapabilityAddressExchange) {
+		t.Fatal("wrong capabilities")
+	}
+	if capability, ok := RequiredCapability(Ping); !ok || capability != CapabilityKeepalive {
+		t.Fatal("ping belongs to keepalive")
+	}
+	if _, ok := RequiredCapability(Commit); ok {
+		t.Fatal("commit isn't optional")
+	}
+}
+
+func TestFraming(t *testing.T) {
+	message, _ := EncodeMessage(PingPacket{Nonce: 1})
+	read := func(frame []byte, network NetworkType) error {
+		return UnmarshalBinaryMessage(bufio.NewReader(bytes.NewReader(frame)), network, 0, &Message{})
+	}
+	if err := read(message.frame(TestNet), TestNet); err != nil {
+		t.Fatal(err)
+	}
+	if err := read(message.frame(MainNet), TestNet); err == nil {
+		t.Fatal("frame of another network should be rejected")
+	}
+	corrupted := message.frame(TestNet)
+	corrupted[len(corrupted) - 1] ^= 1
+	if err := read(corrupted, TestNet); err == nil {
+		t.Fatal("corrupted payload should be rejected")
+	}
+	// the length is checked before the payload is read, so the missing 4 GiB are never allocated
+	oversized := message.frame(TestNet)
+	binary.BigEndian.PutUint32(oversized[5:9], 0xffffffff)
+	err := read(oversized, TestNet)
+	if misbehavior, ok := err.(*MisbehaviorError); !ok || misbehavior.Offense != OversizedMessage {
+		t.Fatal("oversized payload should be rejected, got ", err)
+	}
+	unknown := message.frame(TestNet)
+	unknown[4] = 0xff
+	if err := read(unknown, TestNet); err == nil {
+		t.Fatal("unknown message type should be rejected")
+	}
+	// a peer closing the stream in the middle of a frame isn't scored
+	truncated := message.frame(TestNet)
+	err = read(truncated[:len(truncated) - 1], TestNet)
+	if _, ok := err.(*MisbehaviorError); err == nil || ok {
+		t.Fatal("truncated frame should be a plain error, got ", err)
+	}
+}
+
+func TestMaxBlockSize(t *testing.T) {
+	block := Message{Header: MessageHeader{Type: Block}, Payload: make([]byte, 4096)}
+	read := func(maxBlockSize uint32) error {
+		return UnmarshalBinaryMessage(bufio.NewReader(bytes.NewReader(block.frame(TestNet))), TestNet, maxBlockSize, &Message{})
+	}
+	err := read(2048)
+	if misbehavior, ok := err.(*MisbehaviorError); !ok || misbehavior.Offense != OversizedMessage {
+		t.Fatal("block over the limit should be rejected, got ", err)
+	}
+	// the limit belongs to the stream, the other streams keep theirs
+	if err := read(0); err != nil {
+		t.Fatal(err)
+	}
+	if limit, _ := payloadLimit(RPCResponse, 2048); limit < MaxPayloadSize(Headers) {
+		t.Fatal("responses should still carry the largest payload of the other types")
+	}
+}
+
+func TestReadLoopFinishesOnBadFrame(t *testing.T) {
+	local, remote := net.Pipe()
+	finished := make(chan *MisbehaviorError, 1)
+	c := newConnection()
+	c.stream = newTCPStream(local, TestNet)
+	c.isOpen = true
+	c.onReceive = func(ReceiveMessage) {}
+	c.onFinish = func(*Connection) { close(finished) }
+	c.SetMisbehaveFunc(func(c *Connection, err *MisbehaviorError) {})
+	c.Start()
+	go remote.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("bad frame should finish the connection")
+	}
+	if c.IsAvailable() || c.Offenses() != 1 {
+		t.Fatal("connection should be closed and the peer reported")
+	}
+}
@@ -0,0 +1,416 @@
+package network
+
+import (
+	"consensus_layer/crypto"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const firstEphemeralPort = 40000
+
+// SimConfig describes the links of a simulated network, every message is delayed by
+// Latency plus a random jitter and lost with the probability LossRate. A message is
+// reordered with the probability ReorderRate, it is held back by ReorderDelay so the
+// messages sent after it overtake it.
+//
+// The fate of a message is drawn from Seed, its link and its position on the link, so
+// the n-th message of a link is lost, reordered and delayed the same way in every run
+// with the same seed, whatever the other links and goroutines do. The delays still run
+// on the wall clock: a scenario whose nodes send on their timers may send other messages
+// in another run, only the messages sent at the same positions share their fate.
+type SimConfig struct {
+	Seed 			int64
+	Latency 		time.Duration
+	Jitter 			time.Duration
+	LossRate 		float64
+	ReorderRate 	float64
+	ReorderDelay 	time.Duration
+	Trace 			func(event SimEvent) // called with the fate of every message written, from the goroutine of the writer
+}
+
+// SimEvent is the fate the network drew for a message
+type SimEvent struct {
+	Link 		string // sender>receiver#n, the n-th link dialed between the nodes
+	Sequence 	uint64 // position of the message on the link
+	Lost 		bool
+	Reordered 	bool
+	Delay 		time.Duration
+}
+
+// SimNetwork connects the nodes of a process without sockets
+type SimNetwork struct {
+	config 		SimConfig
+	listeners 	map[string]*simListener
+	groups 		map[string]int // partition group of each node, the nodes missing from every group are in group 0
+	links 		map[string]int // links dialed from a node to another
+	nextPort 	int
+	mutex 		sync.Mutex
+}
+
+func NewSimNetwork(config SimConfig) *SimNetwork {
+	return &SimNetwork{
+		config: 	config,
+		listeners: 	make(map[string]*simListener, 0),
+		groups: 	make(map[string]int, 0),
+		links: 		make(map[string]int, 0),
+		nextPort: 	firstEphemeralPort,
+	}
+}
+
+// Transport returns the transport of the node listening at address
+func (sim *SimNetwork) Transport(address string) Transport {
+	return &simTransport{sim: sim, address: address}
+}
+
+// Partition splits the network into the groups of node addresses, the messages between
+// groups are lost and the dials across groups fail until Heal is called
+func (sim *SimNetwork) Partition(groups ...[]string) {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	sim.groups = make(map[string]int, 0)
+	for i, group := range groups {
+		for _, address := range group {
+			sim.groups[address] = i + 1
+		}
+	}
+}
+
+func (sim *SimNetwork) Heal() {
+	sim.Partition()
+}
+
+// reachable must be called with the mutex held
+func (sim *SimNetwork) reachable(from string, to string) bool {
+	return sim.groups[from] == sim.groups[to]
+}
+
+// fate draws what happens to the message at the position of the link. A message lost to a
+// partition still takes its position, the messages after it keep their draws.
+func (sim *SimNetwork) fate(link *simLink, sequence uint64, from string, to string) SimEvent {
+	event := SimEvent{Link: link.name, Sequence: sequence}
+	draw := simDraw(mix(link.seed ^ mix(sequence)))
+	lost := draw.float64() < sim.config.LossRate
+	jitter := draw.int63n(int64(sim.config.Jitter))
+	reordered := draw.float64() < sim.config.ReorderRate
+	sim.mutex.Lock()
+	reachable := sim.reachable(from, to)
+	sim.mutex.Unlock()
+	if !reachable || lost {
+		event.Lost = true
+		return event
+	}
+	event.Delay = sim.config.Latency + time.Duration(jitter)
+	if reordered {
+		event.Reordered = true
+		event.Delay += sim.config.ReorderDelay
+	}
+	return event
+}
+
+// simLink numbers the messages of one direction of a connection
+type simLink struct {
+	name 		string
+	seed 		uint64
+	sequence 	uint64
+	mutex 		sync.Mutex
+}
+
+func newSimLink(seed int64, from string, to string, n int) *simLink {
+	name := fmt.Sprintf("%s>%s#%d", from, to, n)
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	return &simLink{name: name, seed: mix(uint64(seed) ^ hash.Sum64())}
+}
+
+func (link *simLink) next() uint64 {
+	link.mutex.Lock()
+	defer link.mutex.Unlock()
+	sequence := link.sequence
+	link.sequence++
+	return sequence
+}
+
+// mix is the finalizer of splitmix64, every bit of x changes about half of the bits of the result
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// simDraw is a splitmix64 generator, the draws of a message start from the state of its position
+type simDraw uint64
+
+func (d *simDraw) uint64() uint64 {
+	*d = simDraw(mix(uint64(*d)))
+	return uint64(*d)
+}
+
+func (d *simDraw) float64() float64 {
+	return float64(d.uint64() >> 11) / (1 << 53)
+}
+
+// int63n returns 0 if n isn't positive, the draw is taken anyway
+func (d *simDraw) int63n(n int64) int64 {
+	value := int64(d.uint64() >> 1)
+	if n <= 0 {
+		return 0
+	}
+	return value % n
+}
+
+type simTransport struct {
+	sim 	*SimNetwork
+	address string
+}
+
+func (transport *simTransport) Dial(address string, privateKey *crypto.PrivateKey) (Stream, error) {
+	sim := transport.sim
+	sim.mutex.Lock()
+	listener, ok := sim.listeners[address]
+	if !ok {
+		sim.mutex.Unlock()
+		return nil, fmt.Errorf("dial %s: connection refused", address)
+	}
+	if !sim.reachable(transport.address, address) {
+		sim.mutex.Unlock()
+		return nil, fmt.Errorf("dial %s: network is unreachable", address)
+	}
+	host, _, err := net.SplitHostPort(transport.address)
+	if err != nil {
+		host = transport.address
+	}
+	localAddress := net.JoinHostPort(host, strconv.Itoa(sim.nextPort))
+	sim.nextPort++
+	// the links are named after the listen addresses, the ephemeral ports depend on the order of the dials
+	pair := transport.address + ">" + address
+	n := sim.links[pair]
+	sim.links[pair]++
+	sim.mutex.Unlock()
+
+	outgoing := newSimPipe(newSimLink(sim.config.Seed, transport.address, address, n))
+	incoming := newSimPipe(newSimLink(sim.config.Seed, address, transport.address, n))
+	local := &simStream{
+		sim: 			sim,
+		node: 			transport.address,
+		remoteNode: 	address,
+		localAddress: 	localAddress,
+		remoteAddress: 	address,
+		remoteKey: 		listener.publicKey,
+		in: 			incoming,
+		out: 			outgoing,
+	}
+	remote := &simStream{
+		sim: 			sim,
+		node: 			address,
+		remoteNode: 	transport.address,
+		localAddress: 	address,
+		remoteAddress: 	localAddress,
+		remoteKey: 		privateKey.PublicKey(),
+		in: 			outgoing,
+		out: 			incoming,
+	}
+	if err := listener.deliver(remote); err != nil {
+		return nil, err
+	}
+	return local, nil
+}
+
+func (transport *simTransport) Listen(address string, privateKey *crypto.PrivateKey) (Listener, error) {
+	sim := transport.sim
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	if _, ok := sim.listeners[address]; ok {
+		return nil, fmt.Errorf("listen %s: address already in use", address)
+	}
+	listener := &simListener{
+		sim: 		sim,
+		address: 	address,
+		publicKey: 	privateKey.PublicKey(),
+		streams: 	make(chan Stream, 16),
+		closed: 	make(chan struct{}),
+	}
+	sim.listeners[address] = listener
+	return listener, nil
+}
+
+type simListener struct {
+	sim 		*SimNetwork
+	address 	string
+	publicKey 	*crypto.PublicKey
+	streams 	chan Stream
+	closed 		chan struct{}
+	closeOnce 	sync.Once
+}
+
+func (l *simListener) deliver(stream Stream) error {
+	select {
+	case l.streams <- stream:
+		return nil
+	case <-l.closed:
+		return fmt.Errorf("dial %s: connection refused", l.address)
+	}
+}
+
+func (l *simListener) Accept() (Stream, error) {
+	select {
+	case stream := <-l.streams:
+		return stream, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("listener %s is closed", l.address)
+	}
+}
+
+func (l *simListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.sim.mutex.Lock()
+		delete(l.sim.listeners, l.address)
+		l.sim.mutex.Unlock()
+		close(l.closed)
+	})
+	return nil
+}
+
+type simPacket struct {
+	message 	Message
+	deliverAt 	time.Time
+}
+
+// simPipe carries the messages of one direction, sorted by their delivery time
+type simPipe struct {
+	link 		*simLink
+	packets 	[]simPacket
+	last 		time.Time // delivery time of the last message that wasn't reordered
+	closed 		bool
+	timer 		*time.Timer
+	mutex 		sync.Mutex
+	cond 		*sync.Cond
+}
+
+func newSimPipe(link *simLink) *simPipe {
+	p := &simPipe{link: link}
+	p.cond = sync.NewCond(&p.mutex)
+	return p
+}
+
+func (p *simPipe) push(message Message, delay time.Duration, reordered bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return
+	}
+	deliverAt := time.Now().Add(delay)
+	// the links keep the order of the messages like TCP, only the reordered ones are overtaken
+	if !reordered {
+		if deliverAt.Before(p.last) {
+			deliverAt = p.last
+		}
+		p.last = deliverAt
+	}
+	i := sort.Search(len(p.packets), func(i int) bool {
+		return p.packets[i].deliverAt.After(deliverAt)
+	})
+	p.packets = append(p.packets, simPacket{})
+	copy(p.packets[i + 1:], p.packets[i:])
+	p.packets[i] = simPacket{message: message, deliverAt: deliverAt}
+	p.cond.Broadcast()
+}
+
+func (p *simPipe) pop() (Message, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for {
+		if p.closed {
+			return Message{}, io.EOF
+		}
+		if len(p.packets) > 0 {
+			wait := time.Until(p.packets[0].deliverAt)
+			if wait <= 0 {
+				message := p.packets[0].message
+				p.packets = p.packets[1:]
+				return message, nil
+			}
+			if p.timer != nil {
+				p.timer.Stop()
+			}
+			p.timer = time.AfterFunc(wait, func() {
+				p.mutex.Lock()
+				p.cond.Broadcast()
+				p.mutex.Unlock()
+			})
+		}
+		p.cond.Wait()
+	}
+}
+
+func (p *simPipe) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closed = true
+	p.packets = nil
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.cond.Broadcast()
+}
+
+// simStream is an end of a simulated link, node is the address the owner of the end listens at
+type simStream struct {
+	sim 			*SimNetwork
+	node 			string
+	remoteNode 		string
+	localAddress 	string
+	remoteAddress 	string
+	remoteKey 		*crypto.PublicKey
+	in 				*simPipe
+	out 			*simPipe
+}
+
+func (s *simStream) ReadMessage() (Message, error) {
+	return s.in.pop()
+}
+
+// WriteMessage never blocks, a lost message is dropped silently like on a real network
+func (s *simStream) WriteMessage(message Message) error {
+	s.out.mutex.Lock()
+	closed := s.out.closed
+	s.out.mutex.Unlock()
+	if closed {
+		return fmt.Errorf("write to %s: connection is closed", s.remoteAddress)
+	}
+	event := s.sim.fate(s.out.link, s.out.link.next(), s.node, s.remoteNode)
+	if s.sim.config.Trace != nil {
+		s.sim.config.Trace(event)
+	}
+	if event.Lost {
+		return nil
+	}
+	message.Payload = append([]byte{}, message.Payload...)
+	s.out.push(message, event.Delay, event.Reordered)
+	return nil
+}
+
+// Close closes both directions, the reader of the other end gets io.EOF
+func (s *simStream) Close() error {
+	s.in.close()
+	s.out.close()
+	return nil
+}
+
+func (s *simStream) LocalAddress() string {
+	return s.localAddress
+}
+
+func (s *simStream) RemoteAddress() string {
+	return s.remoteAddress
+}
+
+func (s *simStream) RemoteKey() *crypto.PublicKey {
+	return s.remoteKey
+}
@@ -0,0 +1,228 @@
+package network
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	"consensus_layer/crypto"
+)
+
+func newSimStreams(t *testing.T, sim *SimNetwork) (Stream, Stream) {
+	serverKey, _ := crypto.NewRandomPrivateKey()
+	clientKey, _ := crypto.NewRandomPrivateKey()
+	listener, err := sim.Transport("10.0.0.1:9000").Listen("10.0.0.1:9000", serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := sim.Transport("10.0.0.2:9000").Dial("10.0.0.1:9000", clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.RemoteKey().String() != serverKey.PublicKey().String() || server.RemoteKey().String() != clientKey.PublicKey().String() {
+		t.Fatal("both ends should know the key of the other end")
+	}
+	if server.RemoteAddress() != client.LocalAddress() {
+		t.Fatal("addresses of the ends should match")
+	}
+	return client, server
+}
+
+func newSimMessage(i int) Message {
+	return Message{Header: MessageHeader{Type: Ping}, Payload: []byte{byte(i)}}
+}
+
+// received reads n messages and returns their payloads in the order of delivery
+func received(t *testing.T, stream Stream, n int) []int {
+	order := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		message, err := stream.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		order = append(order, int(message.Payload[0]))
+	}
+	return order
+}
+
+func TestSimNetworkOrder(t *testing.T) {
+	sim := NewSimNetwork(SimConfig{Seed: 1, Latency: time.Millisecond, Jitter: 5 * time.Millisecond})
+	client, server := newSimStreams(t, sim)
+	for i := 0; i < 50; i++ {
+		client.WriteMessage(newSimMessage(i))
+	}
+	for i, payload := range received(t, server, 50) {
+		if payload != i {
+			t.Fatal("messages should keep their order without reordering")
+		}
+	}
+	client.Close()
+	if _, err := server.ReadMessage(); err == nil {
+		t.Fatal("read of a closed link should fail")
+	}
+}
+
+func TestSimNetworkReorder(t *testing.T) {
+	sim := NewSimNetwork(SimConfig{Seed: 1, Latency: time.Millisecond, ReorderRate: 0.2, ReorderDelay: 10 * time.Millisecond})
+	client, server := newSimStreams(t, sim)
+	for i := 0; i < 50; i++ {
+		client.WriteMessage(newSimMessage(i))
+	}
+	inOrder := true
+	for i, payload := range received(t, server, 50) {
+		inOrder = inOrder && payload == i
+	}
+	if inOrder {
+		t.Fatal("some messages should be reordered")
+	}
+}
+
+func TestSimNetworkLoss(t *testing.T) {
+	delivered := func(seed int64) []int {
+		sim := NewSimNetwork(SimConfig{Seed: seed, LossRate: 0.5})
+		client, server := newSimStreams(t, sim)
+		for i := 0; i < 40; i++ {
+			client.WriteMessage(newSimMessage(i))
+		}
+		payloads := make(chan int)
+		go func() {
+			for {
+				message, err := server.ReadMessage()
+				if err != nil {
+					return
+				}
+				payloads <- int(message.Payload[0])
+			}
+		}()
+		order := make([]int, 0)
+		for {
+			select {
+			case payload := <-payloads:
+				order = append(order, payload)
+			case <-time.After(100 * time.Millisecond):
+				server.Close()
+				return order
+			}
+		}
+	}
+	delivered1, delivered2 := delivered(1), delivered(1)
+	if len(delivered1) == 40 || len(delivered1) == 0 {
+		t.Fatal("about half of the messages should be lost")
+	}
+	if len(delivered1) != len(delivered2) {
+		t.Fatal("the same seed should lose the same messages")
+	}
+	for i := range delivered1 {
+		if delivered1[i] != delivered2[i] {
+			t.Fatal("the same seed should lose the same messages")
+		}
+	}
+}
+
+func TestSimNetworkPartition(t *testing.T) {
+	sim := NewSimNetwork(SimConfig{Seed: 1})
+	client, server := newSimStreams(t, sim)
+	sim.Partition([]string{"10.0.0.1:9000"}, []string{"10.0.0.2:9000"})
+	clientKey, _ := crypto.NewRandomPrivateKey()
+	if _, err := sim.Transport("10.0.0.2:9000").Dial("10.0.0.1:9000", clientKey); err == nil {
+		t.Fatal("dial across the partition should fail")
+	}
+	client.WriteMessage(newSimMessage(1))
+	sim.Heal()
+	client.WriteMessage(newSimMessage(2))
+	if payload := received(t, server, 1)[0]; payload != 2 {
+		t.Fatal("message sent across the partition should be lost")
+	}
+}
+
+// simTrace runs a scenario where every node writes to every other node from its own goroutine
+// and returns the fates drawn for the messages, sorted by link and position
+func simTrace(t *testing.T, seed int64) []SimEvent {
+	events := make([]SimEvent, 0)
+	var mutex sync.Mutex
+	sim := NewSimNetwork(SimConfig{
+		Seed: 			seed,
+		Latency: 		time.Millisecond,
+		Jitter: 		time.Millisecond,
+		LossRate: 		0.2,
+		ReorderRate: 	0.2,
+		ReorderDelay: 	5 * time.Millisecond,
+		Trace: func(event SimEvent) {
+			mutex.Lock()
+			events = append(events, event)
+			mutex.Unlock()
+		},
+	})
+	addresses := []string{"10.0.0.1:9000", "10.0.0.2:9000", "10.0.0.3:9000"}
+	for _, address := range addresses {
+		key, _ := crypto.NewRandomPrivateKey()
+		listener, err := sim.Transport(address).Listen(address, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+	}
+	var wg sync.WaitGroup
+	for _, from := range addresses {
+		for _, to := range addresses {
+			if from == to {
+				continue
+			}
+			key, _ := crypto.NewRandomPrivateKey()
+			stream, err := sim.Transport(from).Dial(to, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stream.Close()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					stream.WriteMessage(newSimMessage(i))
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Link != events[j].Link {
+			return events[i].Link < events[j].Link
+		}
+		return events[i].Sequence < events[j].Sequence
+	})
+	return events
+}
+
+func TestSimNetworkReplay(t *testing.T) {
+	trace := simTrace(t, 7)
+	if len(trace) != 6 * 50 {
+		t.Fatalf("expected %d events, got %d", 6 * 50, len(trace))
+	}
+	lost, reordered := 0, 0
+	for _, event := range trace {
+		if event.Lost {
+			lost++
+		}
+		if event.Reordered {
+			reordered++
+		}
+	}
+	if lost == 0 || reordered == 0 {
+		t.Fatal("some messages should be lost and reordered")
+	}
+	if !reflect.DeepEqual(trace, simTrace(t, 7)) {
+		t.Fatal("the same seed should draw the same fate for every message")
+	}
+	if reflect.DeepEqual(trace, simTrace(t, 8)) {
+		t.Fatal("another seed should draw other fates")
+	}
+	if trace[0].Link != fmt.Sprintf("%s>%s#0", "10.0.0.1:9000", "10.0.0.2:9000") {
+		t.Fatal("links should be named after the listen addresses of their nodes, got ", trace[0].Link)
+	}
+}
@@ -0,0 +1,190 @@
+package network
+
+import (
+	"bufio"
+	"consensus_layer/crypto"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Stream carries the messages of one connection
+type Stream interface {
+	ReadMessage() (Message, error)
+	WriteMessage(message Message) error
+	Close() error
+	LocalAddress() string
+	RemoteAddress() string
+	RemoteKey() *crypto.PublicKey // the static key the peer proved to own, nil if the stream isn't authenticated
+}
+
+type Listener interface {
+	Accept() (Stream, error)
+	Close() error
+}
+
+// Transport opens the streams beneath the connections: TCP in production, a simulated network in tests
+type Transport interface {
+	Dial(address string, privateKey *crypto.PrivateKey) (Stream, error)
+	Listen(address string, privateKey *crypto.PrivateKey) (Listener, error)
+}
+
+// DefaultMaxPendingHandshakes is the number of accepted connections secured at once
+const DefaultMaxPendingHandshakes = 64
+
+// TCPTransport secures TCP connections with the node key and frames the messages of Network.
+// The frames carrying a block are bounded by MaxBlockSize, MaxBlockMessageSize if it's 0.
+// Connections accepted while MaxPendingHandshakes others are being secured are closed,
+// DefaultMaxPendingHandshakes if it's 0.
+type TCPTransport struct {
+	Network 				NetworkType
+	MaxBlockSize 			uint32
+	MaxPendingHandshakes 	int
+}
+
+func (transport *TCPTransport) Dial(address string, privateKey *crypto.PrivateKey) (Stream, error) {
+	if !strings.Contains(address, ":") {
+		return nil, fmt.Errorf("invalid peer address %s", address)
+	}
+	conn, err := net.Dial(TCP, address)
+	if err != nil {
+		return nil, err
+	}
+	secureConn, err := NewSecureConn(conn, privateKey, true)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return transport.newStream(secureConn), nil
+}
+
+func (transport *TCPTransport) Listen(address string, privateKey *crypto.PrivateKey) (Listener, error) {
+	listener, err := net.Listen(TCP, address)
+	if err != nil {
+		return nil, err
+	}
+	l := &tcpListener{
+		listener: 	listener,
+		streams: 	make(chan Stream),
+		closed: 	make(chan struct{}),
+	}
+	go l.acceptLoop(privateKey, transport)
+	return l, nil
+}
+
+func (transport *TCPTransport) newStream(conn net.Conn) *tcpStream {
+	stream := newTCPStream(conn, transport.Network)
+	stream.maxBlockSize = transport.MaxBlockSize
+	return stream
+}
+
+type tcpListener struct {
+	listener 	net.Listener
+	streams 	chan Stream
+	closed 		chan struct{}
+	err 		error
+}
+
+// acceptLoop secures every accepted connection on its own goroutine, the key exchange
+// of a slow peer doesn't hold up the others. The peers aren't authenticated yet, so the
+// key exchanges running at once are bounded and each of them has a deadline.
+func (l *tcpListener) acceptLoop(privateKey *crypto.PrivateKey, transport *TCPTransport) {
+	max := transport.MaxPendingHandshakes
+	if max <= 0 {
+		max = DefaultMaxPendingHandshakes
+	}
+	pending := make(chan struct{}, max)
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			l.err = err
+			close(l.closed)
+			return
+		}
+		select {
+		case pending <- struct{}{}:
+		default:
+			fmt.Println("too many pending handshakes, closing connection from ", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		go func(conn net.Conn) {
+			secureConn, err := NewSecureConn(conn, privateKey, false)
+			<-pending
+			if err != nil {
+				fmt.Println("can not secure connection from ", conn.RemoteAddr(), ": ", err)
+				conn.Close()
+				return
+			}
+			select {
+			case l.streams <- transport.newStream(secureConn):
+			case <-l.closed:
+				conn.Close()
+			}
+		}(conn)
+	}
+}
+
+func (l *tcpListener) Accept() (Stream, error) {
+	select {
+	case stream := <-l.streams:
+		return stream, nil
+	case <-l.closed:
+		return nil, l.err
+	}
+}
+
+func (l *tcpListener) Close() error {
+	return l.listener.Close()
+}
+
+// tcpStream frames the messages over a byte stream
+type tcpStream struct {
+	conn 		net.Conn
+	reader 		*bufio.Reader
+	network 		NetworkType
+	maxBlockSize 	uint32
+	writeMutex 		sync.Mutex
+}
+
+func newTCPStream(conn net.Conn, network NetworkType) *tcpStream {
+	return &tcpStream{
+		conn: 		conn,
+		reader: 	bufio.NewReader(conn),
+		network: 	network,
+	}
+}
+
+func (s *tcpStream) ReadMessage() (Message, error) {
+	message := Message{}
+	err := UnmarshalBinaryMessage(s.reader, s.network, s.maxBlockSize, &message)
+	return message, err
+}
+
+func (s *tcpStream) WriteMessage(message Message) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	_, err := s.conn.Write(message.frame(s.network))
+	return err
+}
+
+func (s *tcpStream) Close() error {
+	return s.conn.Close()
+}
+
+func (s *tcpStream) LocalAddress() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *tcpStream) RemoteAddress() string {
+	return s.conn.RemoteAddr().String()
+}
+
+func (s *tcpStream) RemoteKey() *crypto.PublicKey {
+	if secureConn, ok := s.conn.(*SecureConn); ok {
+		key := secureConn.RemoteKey()
+		return &key
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package network
+
+import (
+	"consensus_layer/crypto"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPendingHandshakes(t *testing.T) {
+	key, _ := crypto.NewRandomPrivateKey()
+	transport := &TCPTransport{Network: TestNet, MaxPendingHandshakes: 1}
+	listener, err := transport.Listen("127.0.0.1:0", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	address := listener.(*tcpListener).listener.Addr().String()
+
+	// a peer that never completes its key exchange holds the only slot
+	idle, err := net.Dial(TCP, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected, err := net.Dial(TCP, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rejected.Close()
+	rejected.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := rejected.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("connection over the limit of pending handshakes should be closed, got ", err)
+	}
+
+	// the slot is released once the key exchange fails
+	idle.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stream, err := transport.Dial(address, key)
+		if err == nil {
+			stream.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection should be secured once a slot is free, got ", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
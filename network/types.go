@@ -8,6 +8,8 @@ import (
 
 const TCP  = "tcp"
 const ElectionManager  = "ElectionManager"
+const CommitManager  = "CommitManager"
+const SyncManager  = "SyncManager"
 type MessageType byte
 const (
 	Handshake MessageType = iota
@@ -17,6 +19,14 @@ const (
 	RequestNewTerm
 	RequestVote
 	GrantVote
+	Commit
+	GetAddresses
+	Addresses
+	Ping
+	Pong
+	Headers
+	RPCRequest
+	RPCResponse
 )
 
 type NetworkType byte
@@ -36,7 +46,9 @@ type Message struct {
 
 type HandshakeInfo struct {
 	Network					NetworkType
-	Version					uint16
+	Version					uint16 // the newest version the node speaks
+	MinVersion				uint16 // the oldest version the node speaks
+	Capabilities			Capability
 	ChainId                 blockchain.SHA256Type
 	NodeId                  blockchain.SHA256Type
 	Key                     crypto.PublicKey
@@ -53,6 +65,71 @@ type HandshakePacket struct {
 	Sign crypto.Signature
 }
 
+// NoticePacket announces the head and the last irreversible block of a peer
+type NoticePacket struct {
+	LastCommitBlockHeight   uint32
+	LastCommitBlockId  		blockchain.SHA256Type
+	TopBlockHeight          uint32
+	TopBlockId              blockchain.SHA256Type
+}
+
+type RequestType byte
+const (
+	HeadersRequest RequestType = iota // headers of the head branch from StartHeight, answered by a HeadersPacket
+	BlocksRequest // blocks with the given ids, answered by one Block message per known block
+)
+
+type RequestPacket struct {
+	Type 			RequestType
+	StartHeight 	uint32
+	Count 			uint32
+	Ids 			[]blockchain.SHA256Type
+}
+
+type HeadersPacket struct {
+	Headers []blockchain.SignedHeader
+	Commits []blockchain.Commit // quorum of commitments of the highest block the peer knows to be irreversible
+}
+
+// GetAddressesPacket asks a peer for the addresses it knows
+type GetAddressesPacket struct {
+	Max uint32
+}
+
+type PeerAddress struct {
+	Address 	string
+	LastSeen 	time.Time
+}
+
+type AddressesPacket struct {
+	Addresses []PeerAddress
+}
+
+// PingPacket is answered by a PongPacket carrying the same nonce
+type PingPacket struct {
+	Nonce uint64
+}
+
+type PongPacket struct {
+	Nonce uint64
+}
+
+// RPCRequestPacket wraps a registered request payload, the response carries the same id
+type RPCRequestPacket struct {
+	Id 		uint64
+	Timeout uint32 // milliseconds the caller waits for the response
+	Type 	MessageType // type of the payload
+	Payload []byte
+}
+
+// RPCResponsePacket wraps the registered payload answered by the handler, or its error
+type RPCResponsePacket struct {
+	Id 		uint64
+	Type 	MessageType
+	Payload []byte
+	Error 	string
+}
+
 type ReceiveMessage struct {
 	Conn 	*Connection
 	Message Message
@@ -61,3 +138,6 @@ type ReceiveMessage struct {
 type ReceiveFunc func (ReceiveMessage)
 type FinishFunc func(*Connection)
 type SignFunc = func(hash blockchain.SHA256Type) crypto.Signature
+type BroadcastFunc = func(packet interface{})
+// PeerFilter selects the connections a broadcast is sent to
+type PeerFilter func(*Connection) bool
@@ -0,0 +1,155 @@
+package network
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Priority orders the messages waiting to be written to a peer, the lowest value is written first
+type Priority int
+
+const (
+	ConsensusPriority Priority = iota // handshakes, keepalive and the votes of the producers
+	GossipPriority // blocks and notices relayed through the network, address exchange
+	SyncPriority // responses to the sync requests of a peer
+	priorityCount
+)
+
+const DefaultWriteQueueSize = 256 // messages per priority
+
+var messagePriorities = map[MessageType]Priority{
+	Handshake: 		ConsensusPriority,
+	Ping: 			ConsensusPriority,
+	Pong: 			ConsensusPriority,
+	RequestNewTerm: ConsensusPriority,
+	RequestVote: 	ConsensusPriority,
+	GrantVote: 		ConsensusPriority,
+	Commit: 		ConsensusPriority,
+	Request: 		SyncPriority,
+	Headers: 		SyncPriority,
+}
+
+// MessagePriority returns the priority a message is sent with unless the sender chooses another one
+func MessagePriority(messageType MessageType) Priority {
+	if priority, ok := messagePriorities[messageType]; ok {
+		return priority
+	}
+	return GossipPriority
+}
+
+func (priority Priority) String() string {
+	switch priority {
+	case ConsensusPriority:
+		return "consensus"
+	case GossipPriority:
+		return "gossip"
+	case SyncPriority:
+		return "sync"
+	default:
+		return fmt.Sprintf("priority %d", int(priority))
+	}
+}
+
+// QueueStats describes the write queue of a connection, the arrays are indexed by priority
+type QueueStats struct {
+	Depth 		[priorityCount]int // messages waiting to be written
+	MaxDepth 	[priorityCount]int // the highest depth since the connection was opened
+	Dropped 	[priorityCount]uint64 // messages dropped because their queue was full
+}
+
+// queueOverflow is returned when a message doesn't fit its queue. A peer that can't keep up
+// with the consensus messages is disconnected, gossip and sync messages are dropped since
+// the other peers relay them again and the sync retries its requests.
+type queueOverflow struct {
+	priority 	Priority
+	disconnect 	bool
+}
+
+func (err *queueOverflow) Error() string {
+	if err.disconnect {
+		return fmt.Sprintf("%s queue is full, disconnecting", err.priority)
+	}
+	return fmt.Sprintf("%s queue is full, message dropped", err.priority)
+}
+
+// writeQueue holds the messages of a connection until its writer goroutine sends them
+type writeQueue struct {
+	queues 	[priorityCount][]Message
+	size 	int
+	closed 	bool
+	stats 	QueueStats
+	mutex 	sync.Mutex
+	cond 	*sync.Cond
+}
+
+func newWriteQueue(size int) *writeQueue {
+	q := &writeQueue{size: size}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+func (q *writeQueue) setSize(size int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.size = size
+}
+
+func (q *writeQueue) push(message Message, priority Priority) error {
+	if priority < 0 || priority >= priorityCount {
+		return fmt.Errorf("invalid priority %d", priority)
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return fmt.Errorf("connection is closed")
+	}
+	if len(q.queues[priority]) >= q.size {
+		q.stats.Dropped[priority]++
+		return &queueOverflow{priority: priority, disconnect: priority == ConsensusPriority}
+	}
+	q.queues[priority] = append(q.queues[priority], message)
+	if depth := len(q.queues[priority]); depth > q.stats.MaxDepth[priority] {
+		q.stats.MaxDepth[priority] = depth
+	}
+	q.cond.Signal()
+	return nil
+}
+
+// pop waits for the next message by priority, it returns false once the queue is closed
+func (q *writeQueue) pop() (Message, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for !q.closed {
+		for priority := range q.queues {
+			if len(q.queues[priority]) > 0 {
+				message := q.queues[priority][0]
+				q.queues[priority][0] = Message{}
+				q.queues[priority] = q.queues[priority][1:]
+				return message, true
+			}
+		}
+		q.cond.Wait()
+	}
+	return Message{}, false
+}
+
+// close discards the waiting messages
+func (q *writeQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.closed = true
+	for priority := range q.queues {
+		q.queues[priority] = nil
+	}
+	q.cond.Broadcast()
+}
+
+func (q *writeQueue) snapshot() QueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	stats := q.stats
+	for priority := range q.queues {
+		stats.Depth[priority] = len(q.queues[priority])
+	}
+	return stats
+}
@@ -0,0 +1,91 @@
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+func TestWriteQueuePriority(t *testing.T) {
+	q := newWriteQueue(2)
+	q.push(Message{Header: MessageHeader{Type: Headers}}, SyncPriority)
+	q.push(Message{Header: MessageHeader{Type: Block}}, GossipPriority)
+	q.push(Message{Header: MessageHeader{Type: Commit}}, ConsensusPriority)
+	stats := q.snapshot()
+	if stats.Depth[ConsensusPriority] != 1 || stats.Depth[GossipPriority] != 1 || stats.Depth[SyncPriority] != 1 {
+		t.Fatal("every queue should hold a message, got ", stats.Depth)
+	}
+	for _, expected := range []MessageType{Commit, Block, Headers} {
+		message, ok := q.pop()
+		if !ok || message.Header.Type != expected {
+			t.Fatal("messages should be written by priority")
+		}
+	}
+	if stats := q.snapshot(); stats.Depth[SyncPriority] != 0 || stats.MaxDepth[SyncPriority] != 1 {
+		t.Fatal("depth should drop while the high water mark stays")
+	}
+	q.close()
+	if _, ok := q.pop(); ok {
+		t.Fatal("closed queue shouldn't return messages")
+	}
+	if err := q.push(Message{}, GossipPriority); err == nil {
+		t.Fatal("closed queue shouldn't accept messages")
+	}
+}
+
+func TestWriteQueueOverflow(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	c := newConnection()
+	c.stream = newTCPStream(local, TestNet)
+	c.isOpen = true
+	c.SetWriteQueueSize(2)
+	// nobody writes the queue, so it fills up
+	for i := 0; i < 3; i++ {
+		c.SendMessage(Message{Header: MessageHeader{Type: Block}})
+	}
+	if stats := c.QueueStats(); stats.Dropped[GossipPriority] != 1 || stats.Depth[GossipPriority] != 2 {
+		t.Fatal("overflowing gossip message should be dropped")
+	}
+	if !c.IsAvailable() {
+		t.Fatal("dropping gossip shouldn't disconnect the peer")
+	}
+	for i := 0; i < 3; i++ {
+		c.SendMessage(Message{Header: MessageHeader{Type: Commit}})
+	}
+	if c.IsAvailable() {
+		t.Fatal("peer that can't keep up with consensus messages should be disconnected")
+	}
+	if MessagePriority(GrantVote) != ConsensusPriority || MessagePriority(Headers) != SyncPriority || MessagePriority(Notice) != GossipPriority {
+		t.Fatal("unexpected message priority")
+	}
+}
+
+func TestWriteLoop(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	c := newConnection()
+	c.stream = newTCPStream(local, TestNet)
+	c.isOpen = true
+	c.onReceive = func(ReceiveMessage) {}
+	for i := 0; i < 3; i++ {
+		if err := c.Send(PingPacket{Nonce: uint64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Start()
+	defer c.Close()
+	peer := newTCPStream(remote, TestNet)
+	for i := 0; i < 3; i++ {
+		message, err := peer.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, err := DecodeMessage(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ping, ok := payload.(PingPacket); !ok || ping.Nonce != uint64(i) {
+			t.Fatal("queued messages should be written in order")
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package node
+
+import (
+	"consensus_layer/network"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const MaxAddresses = 1000 // maximum number of addresses kept in the book and exchanged in a message
+const maxNewAddresses = MaxAddresses / 2 // addresses learned from peers and never connected to
+const maxAddressFailures = 10 // addresses that keep failing are forgotten
+const addressBookSaveInterval = 30 * time.Second
+
+type addressEntry struct {
+	Address 	string
+	LastSeen 	time.Time
+	FirstHeard 	time.Time // when this node learned the address
+	LastAttempt time.Time
+	Failures 	uint32
+	Tried 		bool // this node had a session with the address
+}
+
+// addressBook remembers the addresses of the peers of the network across restarts.
+// The addresses learned from peers stay in the new bucket until this node has a session
+// with them, then they move to the tried bucket. A peer can only flush the new bucket:
+// its addresses never evict a tried one and the times it claims can't place its addresses
+// ahead of the ones this node heard before.
+type addressBook struct {
+	path 		string
+	entries 	map[string]*addressEntry
+	dirty 		bool
+	mutex 		sync.Mutex
+}
+
+func loadAddressBook(path string) (*addressBook, error) {
+	book := &addressBook{
+		path: 		path,
+		entries: 	make(map[string]*addressEntry, 0),
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return book, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*addressEntry, 0)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		// books saved before the first heard times were kept
+		if e.FirstHeard.IsZero() {
+			e.FirstHeard = e.LastSeen
+		}
+		book.entries[e.Address] = e
+	}
+	return book, nil
+}
+
+func (book *addressBook) save() error {
+	book.mutex.Lock()
+	if !book.dirty {
+		book.mutex.Unlock()
+		return nil
+	}
+	entries := make([]*addressEntry, 0, len(book.entries))
+	for _, e := range book.entries {
+		entries = append(entries, e)
+	}
+	data, err := json.Marshal(entries)
+	book.dirty = false
+	book.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first so a crash never leaves a truncated book
+	tmp := book.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, book.path)
+}
+
+// add records an address learned from a peer at now, the peer can't claim it was seen
+// after this node first heard of it
+func (book *addressBook) add(address string, lastSeen time.Time, now time.Time) {
+	if !isDialable(address) {
+		return
+	}
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	if e, ok := book.entries[address]; ok {
+		if !e.Tried && lastSeen.After(e.FirstHeard) {
+			lastSeen = e.FirstHeard
+		}
+		if lastSeen.After(e.LastSeen) {
+			e.LastSeen = lastSeen
+			book.dirty = true
+		}
+		return
+	}
+	if lastSeen.After(now) {
+		lastSeen = now
+	}
+	newCount := 0
+	for _, e := range book.entries {
+		if !e.Tried {
+			newCount++
+		}
+	}
+	if newCount >= maxNewAddresses || len(book.entries) >= MaxAddresses {
+		if !book.evict(false) {
+			return
+		}
+	}
+	book.entries[address] = &addressEntry{
+		Address: 	address,
+		LastSeen: 	lastSeen,
+		FirstHeard: now,
+	}
+	book.dirty = true
+}
+
+// evict drops the new address heard of first, or if tried is set and the new bucket is empty,
+// the tried address seen the longest time ago. It returns false if nothing was dropped.
+func (book *addressBook) evict(tried bool) bool {
+	var oldest *addressEntry
+	for _, e := range book.entries {
+		if e.Tried || oldest != nil && !e.FirstHeard.Before(oldest.FirstHeard) {
+			continue
+		}
+		oldest = e
+	}
+	if oldest == nil && tried {
+		for _, e := range book.entries {
+			if oldest == nil || e.LastSeen.Before(oldest.LastSeen) {
+				oldest = e
+			}
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	delete(book.entries, oldest.Address)
+	return true
+}
+
+// seen records a successful session with the address
+func (book *addressBook) seen(address string, now time.Time) {
+	if !isDialable(address) {
+		return
+	}
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	e, ok := book.entries[address]
+	if !ok {
+		if len(book.entries) >= MaxAddresses {
+			book.evict(true)
+		}
+		e = &addressEntry{Address: address, FirstHeard: now}
+		book.entries[address] = e
+	}
+	e.LastSeen = now
+	e.Failures = 0
+	e.Tried = true
+	book.dirty = true
+}
+
+func (book *addressBook) attempted(address string, now time.Time) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	if e, ok := book.entries[address]; ok {
+		e.LastAttempt = now
+		book.dirty = true
+	}
+}
+
+func (book *addressBook) failed(address string) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	if e, ok := book.entries[address]; ok {
+		e.Failures++
+		if e.Failures > maxAddressFailures {
+			delete(book.entries, address)
+		}
+		book.dirty = true
+	}
+}
+
+// recent returns up to max addresses, the most recently seen first
+func (book *addressBook) recent(max int) []network.PeerAddress {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	addresses := make([]network.PeerAddress, 0, len(book.entries))
+	for _, e := range book.entries {
+		addresses = append(addresses, network.PeerAddress{
+			Address: 	e.Address,
+			LastSeen: 	e.LastSeen,
+		})
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i].LastSeen.After(addresses[j].LastSeen)
+	})
+	if len(addresses) > max {
+		addresses = addresses[:max]
+	}
+	return addresses
+}
+
+// candidates returns the addresses whose retry delay has elapsed, the fewest failures first
+func (book *addressBook) candidates(now time.Time, backoff func(attempts int) time.Duration) []string {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	entries := make([]*addressEntry, 0)
+	for _, e := range book.entries {
+		if e.Failures == 0 || now.After(e.LastAttempt.Add(backoff(int(e.Failures)))) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Failures != entries[j].Failures {
+			return entries[i].Failures < entries[j].Failures
+		}
+		return entries[i].LastSeen.After(entries[j].LastSeen)
+	})
+	addresses := make([]string, len(entries))
+	for i, e := range entries {
+		addresses[i] = e.Address
+	}
+	return addresses
+}
+
+func isDialable(address string) bool {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || port == "" || port == "0" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return host != "" && (ip == nil || !ip.IsUnspecified())
+}
+
+// advertisedAddress is the address a peer can be dialed at. Peers listening on an unspecified
+// address (0.0.0.0:2000) advertise it as is, so the host is taken from the connection instead.
+func advertisedAddress(origin string, remote string) string {
+	host, port, err := net.SplitHostPort(origin)
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		remoteHost, _, err := net.SplitHostPort(remote)
+		if err != nil {
+			return ""
+		}
+		host = remoteHost
+	}
+	return net.JoinHostPort(host, port)
+}
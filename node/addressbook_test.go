@@ -0,0 +1,110 @@
+package node
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAddressBook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "addressbook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "peers.json")
+	book, err := loadAddressBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	book.add("10.0.0.1:2000", now.Add(-time.Hour), now)
+	book.add("10.0.0.2:2000", now, now)
+	book.add("0.0.0.0:2000", now, now)
+	book.add("10.0.0.3", now, now)
+	recent := book.recent(10)
+	if len(recent) != 2 || recent[0].Address != "10.0.0.2:2000" {
+		t.Fatal("only dialable addresses should be kept, the most recent first")
+	}
+	book.attempted("10.0.0.2:2000", now)
+	book.failed("10.0.0.2:2000")
+	backoff := func(attempts int) time.Duration { return time.Minute }
+	candidates := book.candidates(now, backoff)
+	if len(candidates) != 1 || candidates[0] != "10.0.0.1:2000" {
+		t.Fatal("failed address should wait for its backoff")
+	}
+	if len(book.candidates(now.Add(2 * time.Minute), backoff)) != 2 {
+		t.Fatal("failed address should be retried after its backoff")
+	}
+	if err := book.save(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := loadAddressBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := reloaded.entries["10.0.0.2:2000"]
+	if len(reloaded.entries) != 2 || e == nil || e.Failures != 1 || !e.LastSeen.Equal(now) {
+		t.Fatal("address book should survive a restart")
+	}
+	for i := 0; i < maxAddressFailures; i++ {
+		reloaded.failed("10.0.0.2:2000")
+	}
+	if _, ok := reloaded.entries["10.0.0.2:2000"]; ok {
+		t.Fatal("address failing too often should be forgotten")
+	}
+}
+
+func TestAddressBookEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "addressbook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	book, err := loadAddressBook(filepath.Join(dir, "peers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i := 0; i < MaxAddresses / 2; i++ {
+		book.seen(fmt.Sprintf("10.0.%d.%d:2000", i / 256, i % 256), now.Add(-time.Hour))
+	}
+	for i := 0; i < maxNewAddresses; i++ {
+		book.add(fmt.Sprintf("10.1.%d.%d:2000", i / 256, i % 256), now.Add(-time.Hour), now.Add(-time.Hour))
+	}
+	// a peer floods the book with addresses it claims it just saw
+	later := now.Add(time.Minute)
+	for i := 0; i < MaxAddresses; i++ {
+		book.add(fmt.Sprintf("10.2.%d.%d:2000", i / 256, i % 256), later, later)
+	}
+	if len(book.entries) != MaxAddresses {
+		t.Fatal("book should be full, got ", len(book.entries))
+	}
+	for i := 0; i < MaxAddresses / 2; i++ {
+		if e := book.entries[fmt.Sprintf("10.0.%d.%d:2000", i / 256, i % 256)]; e == nil || !e.Tried {
+			t.Fatal("addresses learned from peers shouldn't evict the tried ones")
+		}
+	}
+	// the peer can't move an address ahead of the time this node first heard of it
+	last := fmt.Sprintf("10.2.%d.%d:2000", (MaxAddresses - 1) / 256, (MaxAddresses - 1) % 256)
+	book.add(last, later.Add(time.Hour), later.Add(time.Hour))
+	if e := book.entries[last]; e == nil || e.LastSeen.After(later) {
+		t.Fatal("last seen time of a new address should be capped at its first heard time")
+	}
+	book.seen("10.3.0.1:2000", later)
+	if e := book.entries["10.3.0.1:2000"]; e == nil || !e.Tried || len(book.entries) != MaxAddresses {
+		t.Fatal("tried address should replace a new one")
+	}
+}
+
+func TestAdvertisedAddress(t *testing.T) {
+	if address := advertisedAddress("0.0.0.0:2000", "10.0.0.1:53412"); address != "10.0.0.1:2000" {
+		t.Fatal("unspecified host should be replaced by the remote host, got ", address)
+	}
+	if address := advertisedAddress("node1.example.com:2000", "10.0.0.1:53412"); address != "node1.example.com:2000" {
+		t.Fatal("specified host should be kept, got ", address)
+	}
+}
@@ -0,0 +1,55 @@
+package node
+
+import (
+	"consensus_layer/blockchain"
+	"fmt"
+	"sync"
+)
+
+const appHashHistory = 10000 // irreversible blocks whose application state hash is remembered
+
+// appHashes remembers the state hash of the application after the last irreversible blocks
+type appHashes struct {
+	hashes 	map[uint64]blockchain.SHA256Type
+	top 	uint64
+	mutex 	sync.Mutex
+}
+
+func newAppHashes() *appHashes {
+	return &appHashes{
+		hashes: make(map[uint64]blockchain.SHA256Type, 0),
+	}
+}
+
+func (h *appHashes) add(height uint64, hash blockchain.SHA256Type) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.hashes[height] = hash
+	if height > h.top {
+		h.top = height
+	}
+	if h.top >= appHashHistory {
+		delete(h.hashes, h.top - appHashHistory)
+	}
+}
+
+func (h *appHashes) get(height uint64) (blockchain.SHA256Type, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	hash, ok := h.hashes[height]
+	return hash, ok
+}
+
+// checkAppHash compares the AppHash of the header with the state of the application after the
+// irreversible block at its AppHeight. A state this node hasn't reached yet is checked when the
+// block is finalized, one that is older than the remembered history can't be checked.
+func (node *Node) checkAppHash(header blockchain.BlockHeader) error {
+	if header.AppHeight >= header.Height {
+		return fmt.Errorf("app height %d of block %d isn't below the block", header.AppHeight, header.Height)
+	}
+	hash, ok := node.appHashes.get(header.AppHeight)
+	if ok && hash != header.AppHash {
+		return fmt.Errorf("app hash of block %d doesn't match the state at height %d", header.Height, header.AppHeight)
+	}
+	return nil
+}
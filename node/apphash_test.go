@@ -0,0 +1,31 @@
+package node
+
+import (
+	"testing"
+	"consensus_layer/blockchain"
+)
+
+func TestCheckAppHash(t *testing.T) {
+	node := &Node{appHashes: newAppHashes()}
+	node.appHashes.add(4, blockchain.SHA256Type{4})
+	header := blockchain.BlockHeader{
+		Height: 	6,
+		AppHeight: 	4,
+		AppHash: 	blockchain.SHA256Type{4},
+	}
+	if err := node.checkAppHash(header); err != nil {
+		t.Fatal(err)
+	}
+	header.AppHash = blockchain.SHA256Type{5}
+	if err := node.checkAppHash(header); err == nil {
+		t.Fatal("app hash that doesn't match the state should be rejected")
+	}
+	header.AppHeight = 5
+	if err := node.checkAppHash(header); err != nil {
+		t.Fatal("state that isn't reached yet should be checked later")
+	}
+	header.AppHeight = 6
+	if err := node.checkAppHash(header); err == nil {
+		t.Fatal("app height should be below the block")
+	}
+}
@@ -0,0 +1,322 @@
+package node
+
+import (
+	"consensus_layer/blockchain"
+	"consensus_layer/crypto"
+	"consensus_layer/network"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const DefaultBanThreshold = 100
+const DefaultBanDuration = time.Hour
+const scoreDecayInterval = time.Minute // a point of the score is forgiven every interval
+const maxScores = 10000 // scored peers that are remembered, the lowest scores are forgotten first
+
+// Ban keeps every connection of Peer away until it expires, Peer is the hex node id of the peer,
+// its host or an address if the peer isn't authenticated
+type Ban struct {
+	Peer 	string
+	Until 	time.Time
+	Reason 	string
+}
+
+type score struct {
+	value 		int
+	updatedAt 	time.Time
+}
+
+// banList tracks the misbehavior score of every peer and the bans, the bans survive restarts
+type banList struct {
+	path 	string
+	bans 	map[string]Ban
+	scores 	map[string]*score
+	mutex 	sync.Mutex
+}
+
+func loadBanList(path string) (*banList, error) {
+	list := &banList{
+		path: 		path,
+		bans: 		make(map[string]Ban, 0),
+		scores: 	make(map[string]*score, 0),
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	bans := make([]Ban, 0)
+	if err := json.Unmarshal(data, &bans); err != nil {
+		return nil, err
+	}
+	for _, ban := range bans {
+		// bans of the hosts saved by older versions are dropped
+		if ban.Peer != "" {
+			list.bans[ban.Peer] = ban
+		}
+	}
+	return list, nil
+}
+
+// save must be called with the mutex held
+func (list *banList) save() error {
+	bans := make([]Ban, 0, len(list.bans))
+	for _, ban := range list.bans {
+		bans = append(bans, ban)
+	}
+	data, err := json.Marshal(bans)
+	if err != nil {
+		return err
+	}
+	tmp := list.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, list.path)
+}
+
+func (list *banList) isBanned(peer string, now time.Time) bool {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	ban, ok := list.bans[peer]
+	return ok && now.Before(ban.Until)
+}
+
+func (list *banList) add(ban Ban) error {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.bans[ban.Peer] = ban
+	delete(list.scores, ban.Peer)
+	return list.save()
+}
+
+func (list *banList) remove(peer string) error {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	if _, ok := list.bans[peer]; !ok {
+		return fmt.Errorf("%s isn't banned", peer)
+	}
+	delete(list.bans, peer)
+	return list.save()
+}
+
+// active returns the bans that haven't expired, the expired ones are dropped
+func (list *banList) active(now time.Time) []Ban {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	bans := make([]Ban, 0, len(list.bans))
+	expired := false
+	for peer, ban := range list.bans {
+		if !now.Before(ban.Until) {
+			delete(list.bans, peer)
+			expired = true
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	if expired {
+		if err := list.save(); err != nil {
+			fmt.Println("can not save ban list: ", err)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Peer < bans[j].Peer
+	})
+	return bans
+}
+
+// decay forgives the intervals elapsed since the score was updated
+func (s *score) decay(now time.Time) {
+	// only whole intervals are forgiven, the partial one keeps counting
+	intervals := now.Sub(s.updatedAt) / scoreDecayInterval
+	s.value -= int(intervals)
+	s.updatedAt = s.updatedAt.Add(intervals * scoreDecayInterval)
+	if s.value < 0 {
+		s.value = 0
+		s.updatedAt = now
+	}
+}
+
+// penalize adds the penalty to the score of the peer and returns the new score
+func (list *banList) penalize(peer string, penalty int, now time.Time) int {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	s, ok := list.scores[peer]
+	if !ok {
+		if len(list.scores) >= maxScores {
+			list.prune(now)
+		}
+		s = &score{updatedAt: now}
+		list.scores[peer] = s
+	}
+	s.decay(now)
+	s.value += penalty
+	return s.value
+}
+
+// prune forgets the scores that decayed to zero, the lowest score if none did,
+// so peers making up keys can't grow the scores without limit
+func (list *banList) prune(now time.Time) {
+	var lowest string
+	for peer, s := range list.scores {
+		s.decay(now)
+		if s.value == 0 {
+			delete(list.scores, peer)
+			continue
+		}
+		if lowest == "" || s.value < list.scores[lowest].value {
+			lowest = peer
+		}
+	}
+	if len(list.scores) >= maxScores {
+		delete(list.scores, lowest)
+	}
+}
+
+// peerOf identifies the peer that scores and bans apply to: the node id of the key that secured
+// its connection, or its address if the connection isn't authenticated. A peer can make up a new
+// key, so its host is scored and banned as well, see hostOf.
+func peerOf(id *blockchain.SHA256Type, address string) string {
+	if id == nil {
+		return address
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// remotePeer is the end of a stream or a connection
+type remotePeer interface {
+	RemoteKey() *crypto.PublicKey
+	RemoteAddress() string
+}
+
+// remoteNodeId returns the node id of the key that secured the stream, nil if it isn't authenticated
+func remoteNodeId(remote remotePeer) *blockchain.SHA256Type {
+	key := remote.RemoteKey()
+	if key == nil {
+		return nil
+	}
+	id := nodeId(key)
+	return &id
+}
+
+func connectionPeer(c *network.Connection) string {
+	return peerOf(remoteNodeId(c), c.RemoteAddress())
+}
+
+// hostOf returns the host of the address, the address itself if it has no port
+func hostOf(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+// isKnownNode reports whether the node id belongs to a producer of the genesis. Those keys can't
+// be made up, so the producers are only scored and banned by their node id and never by their host.
+func (node *Node) isKnownNode(id *blockchain.SHA256Type) bool {
+	if id == nil {
+		return false
+	}
+	for _, producer := range node.producers {
+		if nodeId(producer.PublicKey) == *id {
+			return true
+		}
+	}
+	return false
+}
+
+// misbehaved scores the offense of the peer and of its host, both are banned once either score
+// reaches the threshold
+func (node *Node) misbehaved(c *network.Connection, err *network.MisbehaviorError) {
+	now := time.Now()
+	id := remoteNodeId(c)
+	peer := peerOf(id, c.RemoteAddress())
+	value := node.bans.penalize(peer, err.Offense.Penalty(), now)
+	host := ""
+	if !node.isKnownNode(id) {
+		host = hostOf(c.RemoteAddress())
+		if hostValue := node.bans.penalize(host, err.Offense.Penalty(), now); hostValue > value {
+			value = hostValue
+		}
+	}
+	fmt.Println("peer ", c.RemoteAddress(), " misbehaved (", err, "), score ", value)
+	if value < node.peers.config.BanThreshold {
+		return
+	}
+	if err := node.BanPeer(peer, node.peers.config.BanDuration, err.Error()); err != nil {
+		fmt.Println(err)
+	}
+	if host == "" || host == peer {
+		return
+	}
+	if err := node.BanPeer(host, node.peers.config.BanDuration, err.Error()); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Bans lists the active bans
+func (node *Node) Bans() []Ban {
+	return node.bans.active(time.Now())
+}
+
+// BanPeer bans the peer, a hex node id, a host or an address, for the duration and disconnects it
+func (node *Node) BanPeer(peer string, duration time.Duration, reason string) error {
+	if peer == "" || duration <= 0 {
+		return fmt.Errorf("invalid ban of %s for %s", peer, duration)
+	}
+	err := node.bans.add(Ban{
+		Peer: 	peer,
+		Until: 	time.Now().Add(duration),
+		Reason: reason,
+	})
+	fmt.Println("banned ", peer, " for ", duration, ": ", reason)
+	node.mutex.Lock()
+	conns := make([]*network.Connection, 0)
+	for _, c := range node.conns {
+		if node.matchesBan(remoteNodeId(c), c.RemoteAddress(), peer) {
+			conns = append(conns, c)
+		}
+	}
+	node.mutex.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+	return err
+}
+
+// UnbanPeer lifts the ban of the peer
+func (node *Node) UnbanPeer(peer string) error {
+	return node.bans.remove(peer)
+}
+
+// matchesBan reports whether the ban of peer applies to the node id and address
+func (node *Node) matchesBan(id *blockchain.SHA256Type, address string, peer string) bool {
+	if id != nil && peerOf(id, address) == peer || address == peer {
+		return true
+	}
+	return hostOf(address) == peer && !node.isKnownNode(id)
+}
+
+// isBanned reports whether the peer is banned by its node id, if it's known, by its address
+// or by its host
+func (node *Node) isBanned(id *blockchain.SHA256Type, address string) bool {
+	now := time.Now()
+	if id != nil && node.bans.isBanned(peerOf(id, address), now) || node.bans.isBanned(address, now) {
+		return true
+	}
+	return !node.isKnownNode(id) && node.bans.isBanned(hostOf(address), now)
+}
+
+func (node *Node) isBannedStream(remote remotePeer) bool {
+	return node.isBanned(remoteNodeId(remote), remote.RemoteAddress())
+}
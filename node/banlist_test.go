@@ -0,0 +1,135 @@
+package node
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"consensus_layer/consensus"
+	"consensus_layer/crypto"
+	"consensus_layer/network"
+)
+
+func TestBanList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "banlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bans.json")
+	list, err := loadBanList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if value := list.penalize("10.0.0.1", network.InvalidBlock.Penalty(), now); value != 50 {
+		t.Fatal("score should be the penalty, got ", value)
+	}
+	if value := list.penalize("10.0.0.1", network.UnsolicitedMessage.Penalty(), now.Add(10 * scoreDecayInterval)); value != 45 {
+		t.Fatal("score should decay over time, got ", value)
+	}
+	// penalties closer than the decay interval don't restart it
+	list.penalize("10.0.0.3", network.UnsolicitedMessage.Penalty(), now)
+	for i := 1; i < 5; i++ {
+		list.penalize("10.0.0.3", 0, now.Add(time.Duration(i) * scoreDecayInterval / 2))
+	}
+	if value := list.penalize("10.0.0.3", 0, now.Add(5 * scoreDecayInterval / 2)); value != 3 {
+		t.Fatal("score should decay by the whole intervals elapsed, got ", value)
+	}
+	if err := list.add(Ban{Peer: "10.0.0.1", Until: now.Add(time.Hour), Reason: "invalid signature"}); err != nil {
+		t.Fatal(err)
+	}
+	list.add(Ban{Peer: "10.0.0.2", Until: now.Add(time.Minute)})
+	reloaded, err := loadBanList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.isBanned("10.0.0.1", now) || !reloaded.isBanned("10.0.0.2", now) {
+		t.Fatal("bans should survive a restart")
+	}
+	if bans := reloaded.active(now.Add(2 * time.Minute)); len(bans) != 1 || bans[0].Peer != "10.0.0.1" {
+		t.Fatal("expired ban should be dropped")
+	}
+	if err := reloaded.remove("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.isBanned("10.0.0.1", now) {
+		t.Fatal("removed ban should be lifted")
+	}
+	if err := reloaded.remove("10.0.0.1"); err == nil {
+		t.Fatal("peer that isn't banned can't be removed")
+	}
+}
+
+// keyedStream is a test stream secured with the key of the peer
+type keyedStream struct {
+	testStream
+	key *crypto.PublicKey
+}
+
+func (s *keyedStream) RemoteKey() *crypto.PublicKey { return s.key }
+
+func TestMisbehavedBansPeer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "banlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bans, err := loadBanList(filepath.Join(dir, "bans.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	producerKey, _ := crypto.NewRandomPrivateKey()
+	node := &Node{
+		bans: 		bans,
+		peers: 		newPeerManager(PeerConfig{}, nil),
+		conns: 		make(map[string]*network.Connection, 0),
+		producers: 	[]consensus.Producer{{Address: "producer1", PublicKey: producerKey.PublicKey()}},
+	}
+	// nodes behind the same host, one of them is a producer
+	offenderKey, _ := crypto.NewRandomPrivateKey()
+	newKey, _ := crypto.NewRandomPrivateKey()
+	offender := &keyedStream{testStream{address: "10.0.0.1:40000"}, offenderKey.PublicKey()}
+	neighbor := &keyedStream{testStream{address: "10.0.0.1:40001"}, producerKey.PublicKey()}
+	c := network.NewIncomingConnection(offender, nil, nil)
+	c.SetMisbehaveFunc(node.misbehaved)
+	c.Misbehave(network.Misbehavior(network.ProtocolViolation, "message before handshake"))
+	if node.isBannedStream(offender) {
+		t.Fatal("a single protocol violation shouldn't ban the peer")
+	}
+	c.Misbehave(network.Misbehavior(network.InvalidSignature, "forged vote"))
+	if !node.isBannedStream(offender) {
+		t.Fatal("forged signature should ban the peer")
+	}
+	if !node.isBannedStream(&keyedStream{testStream{address: "10.0.0.2:40002"}, offenderKey.PublicKey()}) {
+		t.Fatal("the ban should follow the key of the peer to another address")
+	}
+	if !node.isBannedStream(&keyedStream{testStream{address: "10.0.0.1:40003"}, newKey.PublicKey()}) {
+		t.Fatal("the ban should follow the host of the peer to a new key")
+	}
+	if node.isBannedStream(neighbor) {
+		t.Fatal("producer behind the host of the peer shouldn't be banned")
+	}
+}
+
+func TestBanListScoresBounded(t *testing.T) {
+	list := &banList{
+		bans: 		make(map[string]Ban, 0),
+		scores: 	make(map[string]*score, 0),
+	}
+	now := time.Now()
+	for i := 0; i < maxScores; i++ {
+		list.penalize(fmt.Sprintf("peer%d", i), network.UnsolicitedMessage.Penalty(), now)
+	}
+	list.penalize("offender", network.InvalidBlock.Penalty(), now)
+	if len(list.scores) != maxScores || list.scores["offender"] == nil {
+		t.Fatal("the scores should be capped, got ", len(list.scores))
+	}
+	// the scores that decayed are forgotten
+	list.penalize("late", network.UnsolicitedMessage.Penalty(), now.Add(time.Duration(network.UnsolicitedMessage.Penalty()) * scoreDecayInterval))
+	if len(list.scores) != 2 {
+		t.Fatal("decayed scores should be pruned, got ", len(list.scores))
+	}
+}
@@ -0,0 +1,111 @@
+package node
+
+import (
+	"consensus_layer/blockchain"
+	"consensus_layer/network"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const addressRequestInterval = 10 * time.Minute // a peer is asked for its addresses at most once per interval
+
+// addressRequests tracks the GetAddresses sent to the peers. Only the reply to an outstanding
+// request is accepted, so a peer can't push addresses into the book whenever it wants.
+type addressRequests struct {
+	pending 	map[*network.Connection]bool
+	last 		map[blockchain.SHA256Type]time.Time // by node id, a peer that reconnects isn't asked again right away
+	mutex 		sync.Mutex
+}
+
+func newAddressRequests() *addressRequests {
+	return &addressRequests{
+		pending: 	make(map[*network.Connection]bool, 0),
+		last: 		make(map[blockchain.SHA256Type]time.Time, 0),
+	}
+}
+
+// request returns false if the peer was asked less than an interval ago
+func (r *addressRequests) request(c *network.Connection, peer blockchain.SHA256Type, now time.Time) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if last, ok := r.last[peer]; ok && now.Sub(last) < addressRequestInterval {
+		return false
+	}
+	for id, last := range r.last {
+		if now.Sub(last) >= addressRequestInterval {
+			delete(r.last, id)
+		}
+	}
+	r.last[peer] = now
+	r.pending[c] = true
+	return true
+}
+
+// answer consumes the outstanding request of the connection, it returns false if there's none
+func (r *addressRequests) answer(c *network.Connection) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.pending[c] {
+		return false
+	}
+	delete(r.pending, c)
+	return true
+}
+
+func (r *addressRequests) forget(c *network.Connection) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.pending, c)
+}
+
+// requestAddresses asks a newly established peer for the addresses it knows,
+// so a node started with a single seed learns the rest of the network
+func (node *Node) requestAddresses(c *network.Connection) {
+	info := c.PeerInfo()
+	if info == nil || !node.addressRequests.request(c, info.NodeId, time.Now()) {
+		return
+	}
+	if err := c.Send(network.GetAddressesPacket{Max: MaxAddresses}); err != nil {
+		node.addressRequests.forget(c)
+		fmt.Println(err)
+	}
+}
+
+func (node *Node) handleGetAddresses(c *network.Connection, packet network.GetAddressesPacket) {
+	max := int(packet.Max)
+	if max <= 0 || max > MaxAddresses {
+		max = MaxAddresses
+	}
+	requester := ""
+	if info := c.PeerInfo(); info != nil {
+		requester = advertisedAddress(info.OriginAddress, c.RemoteAddress())
+	}
+	addresses := make([]network.PeerAddress, 0, max)
+	for _, address := range node.addresses.recent(max + 1) {
+		if address.Address != requester && len(addresses) < max {
+			addresses = append(addresses, address)
+		}
+	}
+	if err := c.Send(network.AddressesPacket{Addresses: addresses}); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (node *Node) handleAddresses(c *network.Connection, packet network.AddressesPacket) {
+	if !node.addressRequests.answer(c) {
+		c.Misbehave(network.Misbehavior(network.UnsolicitedMessage, "addresses without a request"))
+		return
+	}
+	if len(packet.Addresses) > MaxAddresses {
+		c.Misbehave(network.Misbehavior(network.OversizedMessage, "%d addresses", len(packet.Addresses)))
+		return
+	}
+	now := time.Now()
+	for _, address := range packet.Addresses {
+		if address.Address == node.p2pAddress {
+			continue
+		}
+		node.addresses.add(address.Address, address.LastSeen, now)
+	}
+}
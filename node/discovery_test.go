@@ -0,0 +1,56 @@
+package node
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"consensus_layer/blockchain"
+	"consensus_layer/network"
+)
+
+func TestHandleAddresses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	book, err := loadAddressBook(filepath.Join(dir, "peers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &Node{
+		p2pAddress: 		"10.0.0.1:2000",
+		addresses: 			book,
+		addressRequests: 	newAddressRequests(),
+	}
+	c := newTestConnection("10.0.0.2:40000")
+	c.SetPeerInfo(network.HandshakeInfo{NodeId: blockchain.SHA256Type{2}})
+	packet := network.AddressesPacket{Addresses: []network.PeerAddress{{Address: "10.0.0.3:2000", LastSeen: time.Now()}}}
+	node.handleAddresses(c, packet)
+	if len(book.recent(10)) != 0 || c.Offenses() != 1 {
+		t.Fatal("addresses nobody asked for should be rejected")
+	}
+	node.requestAddresses(c)
+	node.handleAddresses(c, packet)
+	if len(book.recent(10)) != 1 {
+		t.Fatal("reply to a request should be accepted")
+	}
+	packet.Addresses[0].Address = "10.0.0.4:2000"
+	node.handleAddresses(c, packet)
+	if len(book.recent(10)) != 1 || c.Offenses() != 2 {
+		t.Fatal("a request should be answered only once")
+	}
+	// the peer reconnects right away
+	reconnected := newTestConnection("10.0.0.2:40001")
+	reconnected.SetPeerInfo(network.HandshakeInfo{NodeId: blockchain.SHA256Type{2}})
+	node.requestAddresses(reconnected)
+	node.handleAddresses(reconnected, packet)
+	if len(book.recent(10)) != 1 {
+		t.Fatal("a peer should be asked for its addresses at most once per interval")
+	}
+	if !node.addressRequests.request(reconnected, blockchain.SHA256Type{2}, time.Now().Add(addressRequestInterval)) {
+		t.Fatal("a peer should be asked again after the interval")
+	}
+}
@@ -0,0 +1,157 @@
+package node
+
+import (
+	"consensus_layer/blockchain"
+	"consensus_layer/network"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+const DefaultGossipCacheSize = 10000
+
+type GossipConfig struct {
+	CacheSize 		int // number of message hashes remembered to drop duplicates
+	SkipKnownPeers 	bool // relay only to the peers that neither sent nor were sent the message
+}
+
+// the messages that are relayed to the whole network, the others only concern the two peers
+var gossipTypes = map[network.MessageType]bool{
+	network.Block: 				true,
+	network.RequestNewTerm: 	true,
+	network.RequestVote: 		true,
+	network.GrantVote: 			true,
+	network.Commit: 			true,
+}
+
+// seenCache remembers the hashes of the last gossiped messages and the peers known to have them
+type seenCache struct {
+	capacity 	int
+	entries 	map[blockchain.SHA256Type]*seenEntry
+	order 		[]blockchain.SHA256Type // ring of the remembered hashes, the oldest is evicted first
+	next 		int
+	mutex 		sync.Mutex
+}
+
+type seenEntry struct {
+	peers 	map[*network.Connection]bool
+	slot 	int // position of the hash in the ring, a forgotten hash seen again gets a new one
+}
+
+func newSeenCache(capacity int) *seenCache {
+	if capacity <= 0 {
+		capacity = DefaultGossipCacheSize
+	}
+	return &seenCache{
+		capacity: 	capacity,
+		entries: 	make(map[blockchain.SHA256Type]*seenEntry, 0),
+		order: 		make([]blockchain.SHA256Type, 0, capacity),
+	}
+}
+
+// observe records that the peers have the message, it returns true the first time the message is seen
+func (cache *seenCache) observe(hash blockchain.SHA256Type, conns ...*network.Connection) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	entry, ok := cache.entries[hash]
+	if !ok {
+		entry = &seenEntry{peers: make(map[*network.Connection]bool, 0)}
+		if len(cache.order) < cache.capacity {
+			entry.slot = len(cache.order)
+			cache.order = append(cache.order, hash)
+		} else {
+			entry.slot = cache.next
+			evicted := cache.order[cache.next]
+			// the slot of a forgotten hash doesn't evict the hash when it was seen again
+			if e, ok := cache.entries[evicted]; ok && e.slot == cache.next {
+				delete(cache.entries, evicted)
+			}
+			cache.order[cache.next] = hash
+			cache.next = (cache.next + 1) % cache.capacity
+		}
+		cache.entries[hash] = entry
+	}
+	for _, c := range conns {
+		entry.peers[c] = true
+	}
+	return !ok
+}
+
+// forget drops the message so it's handled again when a peer sends it, its hash stays in the
+// ring until its turn to be evicted comes
+func (cache *seenCache) forget(hash blockchain.SHA256Type) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	delete(cache.entries, hash)
+}
+
+func (cache *seenCache) knownBy(hash blockchain.SHA256Type, c *network.Connection) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	entry, ok := cache.entries[hash]
+	return ok && entry.peers[c]
+}
+
+func messageHash(message network.Message) blockchain.SHA256Type {
+	data := make([]byte, 0, 1 + len(message.Payload))
+	data = append(data, byte(message.Header.Type))
+	return sha256.Sum256(append(data, message.Payload...))
+}
+
+// receiveGossip records a gossiped message, it returns false if the message was seen before
+func (node *Node) receiveGossip(c *network.Connection, message network.Message) bool {
+	return node.seen.observe(messageHash(message), c)
+}
+
+// rejectGossip forgets a message that was rejected without the fault of the peer, e.g. a block
+// ahead of this node, so it's accepted once it's relayed again after it became valid. Invalid
+// messages stay seen and their copies are dropped.
+func (node *Node) rejectGossip(message network.Message, misbehavior bool) {
+	if !misbehavior {
+		node.seen.forget(messageHash(message))
+	}
+}
+
+// relay forwards a gossiped message to the other peers
+func (node *Node) relay(from *network.Connection, message network.Message) {
+	hash := messageHash(message)
+	node.send(message, func(c *network.Connection) bool {
+		return c != from && !(node.gossip.SkipKnownPeers && node.seen.knownBy(hash, c))
+	})
+}
+
+// Broadcast sends the packet to every established peer
+func (node *Node) Broadcast(packet interface{}) {
+	node.BroadcastTo(packet, nil)
+}
+
+// BroadcastTo sends the packet to the established peers accepted by filter, every peer if it's nil
+func (node *Node) BroadcastTo(packet interface{}, filter network.PeerFilter) {
+	message, err := network.EncodeMessage(packet)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if gossipTypes[message.Header.Type] {
+		// echoes of the message relayed back by the peers are dropped
+		node.seen.observe(messageHash(message))
+	}
+	node.send(message, filter)
+}
+
+func (node *Node) send(message network.Message, filter network.PeerFilter) {
+	gossiped := gossipTypes[message.Header.Type]
+	hash := messageHash(message)
+	for _, c := range node.establishedConnections() {
+		if filter != nil && !filter(c) {
+			continue
+		}
+		if err := c.SendMessage(message); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if gossiped {
+			node.seen.observe(hash, c)
+		}
+	}
+}
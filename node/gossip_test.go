@@ -0,0 +1,86 @@
+package node
+
+import (
+	"fmt"
+	"testing"
+	"consensus_layer/blockchain"
+	"consensus_layer/network"
+)
+
+func TestSeenCache(t *testing.T) {
+	cache := newSeenCache(2)
+	peer1, peer2 := &network.Connection{}, &network.Connection{}
+	if !cache.observe(blockchain.SHA256Type{1}, peer1) {
+		t.Fatal("first message should be new")
+	}
+	if cache.observe(blockchain.SHA256Type{1}, peer2) {
+		t.Fatal("duplicated message should be dropped")
+	}
+	if !cache.knownBy(blockchain.SHA256Type{1}, peer1) || !cache.knownBy(blockchain.SHA256Type{1}, peer2) {
+		t.Fatal("both peers have the message")
+	}
+	cache.observe(blockchain.SHA256Type{2})
+	cache.observe(blockchain.SHA256Type{3})
+	if len(cache.entries) != 2 || cache.knownBy(blockchain.SHA256Type{1}, peer1) {
+		t.Fatal("oldest message should be evicted")
+	}
+	if !cache.observe(blockchain.SHA256Type{1}) {
+		t.Fatal("evicted message should be new again")
+	}
+}
+
+func TestSeenCacheForget(t *testing.T) {
+	cache := newSeenCache(3)
+	cache.observe(blockchain.SHA256Type{1})
+	cache.observe(blockchain.SHA256Type{2})
+	cache.forget(blockchain.SHA256Type{1})
+	if !cache.observe(blockchain.SHA256Type{1}) {
+		t.Fatal("forgotten message should be new again")
+	}
+	// the slot the message had before it was forgotten is evicted first
+	cache.observe(blockchain.SHA256Type{3})
+	if cache.observe(blockchain.SHA256Type{1}) {
+		t.Fatal("message seen again shouldn't be evicted with its old slot")
+	}
+	cache.observe(blockchain.SHA256Type{4})
+	if len(cache.entries) != 3 || !cache.observe(blockchain.SHA256Type{2}) {
+		t.Fatal("oldest message should be evicted")
+	}
+}
+
+func TestMessageHash(t *testing.T) {
+	commit := network.Message{Header: network.MessageHeader{Type: network.Commit}, Payload: []byte{1, 2}}
+	block := network.Message{Header: network.MessageHeader{Type: network.Block}, Payload: []byte{1, 2}}
+	if messageHash(commit) == messageHash(block) {
+		t.Fatal("same payload of another type should be another message")
+	}
+}
+
+func TestGossipRejected(t *testing.T) {
+	manager := &testManager{types: []network.MessageType{network.Commit}}
+	node := &Node{
+		router: 	newRouter(),
+		seen: 		newSeenCache(0),
+		quit: 		make(chan struct{}),
+	}
+	if err := node.AddManager(manager, "commit"); err != nil {
+		t.Fatal(err)
+	}
+	c := &network.Connection{}
+	early := network.Message{Header: network.MessageHeader{Type: network.Commit}, Payload: []byte{1}}
+	manager.reject = fmt.Errorf("commit of an unknown block")
+	node.dispatch(c, early)
+	manager.reject = nil
+	node.dispatch(c, early)
+	node.dispatch(c, early)
+	if len(manager.received) != 2 {
+		t.Fatal("message rejected without the fault of the peer should be handled again, only its accepted copy is a duplicate")
+	}
+	forged := network.Message{Header: network.MessageHeader{Type: network.Commit}, Payload: []byte{2}}
+	manager.reject = network.Misbehavior(network.InvalidSignature, "forged commit")
+	node.dispatch(c, forged)
+	node.dispatch(c, forged)
+	if len(manager.received) != 3 {
+		t.Fatal("copies of an invalid message should be dropped")
+	}
+}
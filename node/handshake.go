@@ -0,0 +1,121 @@
+package node
+
+import (
+	"bytes"
+	"consensus_layer/blockchain"
+	"consensus_layer/crypto"
+	"consensus_layer/network"
+	"crypto/sha256"
+	"fmt"
+	"time"
+)
+
+// handshakes whose timestamp differs from the local clock by more than this are rejected
+const MaxHandshakeClockSkew = 30 * time.Second
+
+func loadPrivateKey(wif string) (*crypto.PrivateKey, error) {
+	if wif == "" {
+		return crypto.NewRandomPrivateKey()
+	}
+	return crypto.NewPrivateKey(wif)
+}
+
+// the id of a node is bound to its key
+func nodeId(publicKey *crypto.PublicKey) blockchain.SHA256Type {
+	return sha256.Sum256(publicKey.Data)
+}
+
+// both sides send their handshake as soon as the connection is started
+func (node *Node) sendHandshake(c *network.Connection) {
+	handshake, err := node.newHandshakePacket()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := c.Send(handshake); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (node *Node) handleHandshake(c *network.Connection, handshake network.HandshakePacket) {
+	if c.IsEstablished() {
+		fmt.Println("duplicated handshake from ", c.RemoteAddress())
+		return
+	}
+	// the key of the handshake must be the one that secured the connection
+	if key := c.RemoteKey(); key == nil || !bytes.Equal(key.Data, handshake.Info.Key.Data) {
+		fmt.Println("handshake from ", c.RemoteAddress(), " is rejected: key doesn't match the secure channel")
+		c.Misbehave(network.Misbehavior(network.ProtocolViolation, "handshake key doesn't match the secure channel"))
+		c.Close()
+		return
+	}
+	if err := node.validateHandshake(handshake, time.Now()); err != nil {
+		fmt.Println("handshake from ", c.RemoteAddress(), " is rejected: ", err)
+		c.Misbehave(err)
+		c.Close()
+		return
+	}
+	info := handshake.Info
+	version, _ := network.NegotiateVersion(node.minVersion, node.version, info.MinVersion, info.Version)
+	c.SetProtocol(version, node.capabilities & info.Capabilities)
+	c.SetPeerInfo(info)
+	if node.peerEstablished(c) {
+		fmt.Println("established session with ", info.OriginAddress, " on version ", version)
+		// optional features are only used when both peers support them
+		if c.Supports(network.CapabilityAddressExchange) {
+			node.requestAddresses(c)
+		}
+		node.peerConnected(c)
+	}
+}
+
+// validateHandshake returns a MisbehaviorError for a forged handshake. A peer of another
+// network, chain or version, or with a skewed clock, may be honest and isn't scored.
+func (node *Node) validateHandshake(handshake network.HandshakePacket, now time.Time) error {
+	info := handshake.Info
+	if err := handshake.Verify(); err != nil {
+		return network.Misbehavior(network.InvalidSignature, "handshake signature: %s", err)
+	}
+	if info.NodeId != nodeId(&info.Key) {
+		return network.Misbehavior(network.ProtocolViolation, "node id doesn't match the key")
+	}
+	if info.NodeId == node.id {
+		return fmt.Errorf("self connection")
+	}
+	if info.Network != node.network {
+		return fmt.Errorf("wrong network %d", info.Network)
+	}
+	if info.ChainId != node.chainId {
+		return fmt.Errorf("wrong chain id")
+	}
+	if _, err := network.NegotiateVersion(node.minVersion, node.version, info.MinVersion, info.Version); err != nil {
+		return err
+	}
+	if !info.Capabilities.Has(network.RequiredCapabilities) {
+		return fmt.Errorf("required capabilities %d aren't supported", network.RequiredCapabilities)
+	}
+	skew := now.Sub(info.Timestamp)
+	if skew > MaxHandshakeClockSkew || skew < -MaxHandshakeClockSkew {
+		return fmt.Errorf("timestamp is off by %s", skew)
+	}
+	return nil
+}
+
+func (node *Node) newHandshakePacket() (network.HandshakePacket, error) {
+	info := network.HandshakeInfo{
+		Network:				node.network,
+		Version:				node.version,
+		MinVersion:				node.minVersion,
+		Capabilities: 			node.capabilities,
+		ChainId: 				node.chainId,
+		NodeId: 				node.id,
+		Key: 					*node.keyPair.publicKey,
+		OriginAddress: 			node.p2pAddress,
+		LastCommitBlockHeight: 	uint32(node.blockLog.TopBlockHeight()),
+		LastCommitBlockId: 		node.blockLog.TopBlockId(),
+		TopBlockHeight: 		uint32(node.forkTree.HeadHeight()),
+		TopBlockId:				node.forkTree.HeadId(),
+		Timestamp:				time.Now(),
+	}
+	return network.NewHandshakePacket(info, node.keyPair.privateKey)
+}
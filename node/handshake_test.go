@@ -0,0 +1,121 @@
+package node
+
+import (
+	"testing"
+	"time"
+	"consensus_layer/blockchain"
+	"consensus_layer/crypto"
+	"consensus_layer/network"
+)
+
+func newTestHandshake(t *testing.T, chainId blockchain.SHA256Type, timestamp time.Time) network.HandshakePacket {
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	info := network.HandshakeInfo{
+		Network: 	network.TestNet,
+		Version: 	network.ProtocolVersion,
+		Capabilities: network.SupportedCapabilities,
+		ChainId: 	chainId,
+		NodeId: 	nodeId(privateKey.PublicKey()),
+		Key: 		*privateKey.PublicKey(),
+		Timestamp: 	timestamp,
+	}
+	packet, err := network.NewHandshakePacket(info, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+func TestValidateHandshake(t *testing.T) {
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	node := &Node{
+		id: 		nodeId(privateKey.PublicKey()),
+		chainId: 	blockchain.SHA256Type{1},
+		network: 	network.TestNet,
+		version: 	network.ProtocolVersion,
+	}
+	now := time.Now()
+	if err := node.validateHandshake(newTestHandshake(t, node.chainId, now), now); err != nil {
+		t.Fatal(err)
+	}
+	// honest peers of another chain or with a skewed clock aren't scored
+	err := node.validateHandshake(newTestHandshake(t, blockchain.SHA256Type{2}, now), now)
+	if _, ok := err.(*network.MisbehaviorError); err == nil || ok {
+		t.Fatal("handshake of another chain should be rejected without a score, got ", err)
+	}
+	err = node.validateHandshake(newTestHandshake(t, node.chainId, now.Add(-time.Hour)), now)
+	if _, ok := err.(*network.MisbehaviorError); err == nil || ok {
+		t.Fatal("stale handshake should be rejected without a score, got ", err)
+	}
+	tampered := newTestHandshake(t, node.chainId, now)
+	tampered.Info.OriginAddress = "localhost:2000"
+	err = node.validateHandshake(tampered, now)
+	if misbehavior, ok := err.(*network.MisbehaviorError); !ok || misbehavior.Offense != network.InvalidSignature {
+		t.Fatal("tampered handshake should be scored, got ", err)
+	}
+	peerKey, _ := crypto.NewRandomPrivateKey()
+	forged, _ := network.NewHandshakePacket(network.HandshakeInfo{
+		Network: 	network.TestNet,
+		Version: 	network.ProtocolVersion,
+		Capabilities: network.SupportedCapabilities,
+		ChainId: 	node.chainId,
+		NodeId: 	blockchain.SHA256Type{9},
+		Key: 		*peerKey.PublicKey(),
+		Timestamp: 	now,
+	}, peerKey)
+	err = node.validateHandshake(forged, now)
+	if misbehavior, ok := err.(*network.MisbehaviorError); !ok || misbehavior.Offense != network.ProtocolViolation {
+		t.Fatal("node id of another key should be scored, got ", err)
+	}
+	silent, _ := network.NewHandshakePacket(network.HandshakeInfo{
+		Network: 	network.TestNet,
+		Version: 	network.ProtocolVersion,
+		Capabilities: network.CapabilityHeaderSync,
+		ChainId: 	node.chainId,
+		NodeId: 	nodeId(peerKey.PublicKey()),
+		Key: 		*peerKey.PublicKey(),
+		Timestamp: 	now,
+	}, peerKey)
+	if err := node.validateHandshake(silent, now); err == nil {
+		t.Fatal("handshake without keepalive should be rejected")
+	}
+	self, _ := network.NewHandshakePacket(network.HandshakeInfo{
+		Network: 	network.TestNet,
+		Version: 	network.ProtocolVersion,
+		Capabilities: network.SupportedCapabilities,
+		ChainId: 	node.chainId,
+		NodeId: 	node.id,
+		Key: 		*privateKey.PublicKey(),
+		Timestamp: 	now,
+	}, privateKey)
+	if err := node.validateHandshake(self, now); err == nil {
+		t.Fatal("self connection should be rejected")
+	}
+}
+
+func TestValidateHandshakeVersion(t *testing.T) {
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	node := &Node{
+		id: 		nodeId(privateKey.PublicKey()),
+		network: 	network.TestNet,
+		version: 	2,
+		minVersion: 1,
+	}
+	now := time.Now()
+	for _, versions := range [][2]uint16{{2, 3}, {1, 1}, {3, 4}} {
+		peerKey, _ := crypto.NewRandomPrivateKey()
+		packet, _ := network.NewHandshakePacket(network.HandshakeInfo{
+			Network: 	network.TestNet,
+			MinVersion: versions[0],
+			Version: 	versions[1],
+			Capabilities: network.SupportedCapabilities,
+			NodeId: 	nodeId(peerKey.PublicKey()),
+			Key: 		*peerKey.PublicKey(),
+			Timestamp: 	now,
+		}, peerKey)
+		err := node.validateHandshake(packet, now)
+		if compatible := versions[0] <= node.version; compatible != (err == nil) {
+			t.Fatal("versions ", versions, " compatible ", compatible, " got ", err)
+		}
+	}
+}
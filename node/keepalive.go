@@ -0,0 +1,103 @@
+package node
+
+import (
+	"consensus_layer/blockchain"
+	"consensus_layer/network"
+	"fmt"
+	"time"
+)
+
+// PeerStatus describes an established session with a peer
+type PeerStatus struct {
+	Info 		network.HandshakeInfo
+	Address 	string
+	Outgoing 	bool
+	RTT 		time.Duration // moving average of the round trip time, 0 until the first pong
+	Queue 		network.QueueStats
+}
+
+// keepAlive pings every established peer and disconnects the ones that stopped answering,
+// a half-open connection would otherwise block its read loop forever
+func (node *Node) keepAlive() {
+	ticker := time.NewTicker(node.peers.config.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			node.pingPeers(time.Now())
+		case <-node.quit:
+			return
+		}
+	}
+}
+
+// pingPeers pings every established peer, keepalive is required by the handshake
+func (node *Node) pingPeers(now time.Time) {
+	for _, c := range node.establishedConnections() {
+		missed, err := c.Ping(now)
+		if missed >= node.peers.config.MaxMissedPongs {
+			fmt.Println("peer ", c.RemoteAddress(), " missed ", missed, " pongs, disconnecting")
+			c.Close()
+			continue
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// expireHandshakes closes the connections whose peer didn't send an acceptable handshake in time,
+// they hold a slot but aren't pinged
+func (node *Node) expireHandshakes(now time.Time) {
+	node.mutex.Lock()
+	expired := make([]*network.Connection, 0)
+	for _, c := range node.conns {
+		if !c.IsEstablished() && now.Sub(c.OpenedAt()) > node.peers.config.HandshakeTimeout {
+			expired = append(expired, c)
+		}
+	}
+	node.mutex.Unlock()
+	for _, c := range expired {
+		fmt.Println("no handshake from ", c.RemoteAddress(), " in ", node.peers.config.HandshakeTimeout, ", disconnecting")
+		c.Close()
+	}
+}
+
+func (node *Node) establishedConnections() []*network.Connection {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	conns := make([]*network.Connection, 0, len(node.conns))
+	for _, c := range node.conns {
+		if c.IsEstablished() {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+// Peers returns the status of every established session
+func (node *Node) Peers() []PeerStatus {
+	conns := node.establishedConnections()
+	peers := make([]PeerStatus, 0, len(conns))
+	for _, c := range conns {
+		peers = append(peers, PeerStatus{
+			Info: 		*c.PeerInfo(),
+			Address: 	c.RemoteAddress(),
+			Outgoing: 	c.IsOutgoing(),
+			RTT: 		c.RTT(),
+			Queue: 		c.QueueStats(),
+		})
+	}
+	return peers
+}
+
+// IsReachable reports whether there is a live session with the node,
+// consensus timeouts use it to tell an unreachable producer from a slow one
+func (node *Node) IsReachable(id blockchain.SHA256Type) bool {
+	for _, peer := range node.Peers() {
+		if peer.Info.NodeId == id {
+			return true
+		}
+	}
+	return false
+}
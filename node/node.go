@@ -3,13 +3,14 @@ package node
 import (
 	"sync"
 	"consensus_layer/crypto"
-	"net"
 	"fmt"
-	"time"
-	"crypto/sha256"
 	"consensus_layer/blockchain"
 	"consensus_layer/consensus"
 	"consensus_layer/network"
+	"consensus_layer/mempool"
+	"consensus_layer/application"
+	"path/filepath"
+	"time"
 )
 
 //type receiveBlock struct {
@@ -21,6 +22,23 @@ type keyPair struct {
 	privateKey *crypto.PrivateKey
 }
 
+type Config struct {
+	P2PAddress 	string
+	Targets 	[]string // addresses of specific peers that this node try to connect
+	DataDir 	string
+	GenesisFile string
+	ForkChoice 	blockchain.ForkChoice
+	Mempool 	mempool.Config
+	Application application.Application // defaults to the in-memory key-value store
+	ProducerAddress string // address of this node in the producer set, empty if it isn't a producer
+	PrivateKey 	string // WIF private key of this node, a random key is used if it's empty
+	Peers 		PeerConfig
+	Gossip 		GossipConfig
+	Capabilities network.Capability // defaults to every supported capability, the required ones are always added
+	Transport 	network.Transport // defaults to secured TCP
+	MaxConcurrentRequests int // requests of the peers served at the same time
+}
+
 type Node struct {
 	id 					blockchain.SHA256Type
 	chainId 			blockchain.SHA256Type
@@ -29,82 +47,269 @@ type Node struct {
 	keyPair				keyPair
 	conns 				map[string]*network.Connection
 	network 			network.NetworkType
-	version 			uint16
+	version 			uint16 // newest protocol version
+	minVersion 			uint16
+	capabilities 		network.Capability
+	transport 			network.Transport
 	newConn 			chan *network.Connection // trigger when a connection is accepted
 	doneConn 			chan *network.Connection // trigger when a connection is disconnected
 	//receiveBlockQueue 	[]receiveBlock
-	managers			map[string]network.BaseManager
+	router				*router // routes the messages of the managers
+	rpc					*network.RPCServer // answers the requests of the peers
+	sessions			map[*network.Connection]bool // established connections the managers were told about
+	listener			network.Listener
+	started				bool
+	quit				chan struct{} // closed when the node stops
+	stopOnce			sync.Once
 	walletAddress		string
+	peers				*peerManager
+	addresses			*addressBook // addresses of the peers of the network learned from handshakes and other peers
+	addressRequests		*addressRequests // GetAddresses waiting for the reply of the peers
+	bans				*banList
+	genesis				*blockchain.Genesis
+	blockLog			*blockchain.BlockLog // irreversible blocks
+	forkTree			*blockchain.ForkTree // reversible blocks on top of the block log
+	election			*consensus.ElectionManager
+	commitManager		*consensus.CommitManager
+	sync				*syncManager
+	gossip				GossipConfig
+	seen				*seenCache // gossiped messages that were already received or sent
+	scheduler			*consensus.Scheduler
+	producers			[]consensus.Producer
+	mempool				*mempool.Mempool
+	application			application.Application
+	appHashes			*appHashes // state of the application after the last irreversible blocks
+	finalizeMutex		sync.Mutex // blocks are finalized and executed by one goroutine at a time
 	mutex 				sync.Mutex
 }
 
-func NewNode(p2pAddress string, outbounds []string) *Node {
+func NewNode(config Config) (*Node, error) {
+	genesis, err := blockchain.LoadGenesis(config.GenesisFile)
+	if err != nil {
+		return nil, err
+	}
+	producers, err := consensus.GenesisProducers(genesis)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := loadPrivateKey(config.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkProducerKey(producers, config.ProducerAddress, privateKey); err != nil {
+		return nil, err
+	}
+	blockLog, err := blockchain.OpenBlockLog(filepath.Join(config.DataDir, "blocks"))
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := loadAddressBook(filepath.Join(config.DataDir, "peers.json"))
+	if err != nil {
+		return nil, err
+	}
+	bans, err := loadBanList(filepath.Join(config.DataDir, "bans.json"))
+	if err != nil {
+		return nil, err
+	}
 	node := &Node {
-		p2pAddress: p2pAddress,
-		targets: outbounds,
+		chainId: genesis.ChainId(),
+		genesis: genesis,
+		p2pAddress: config.P2PAddress,
+		targets: config.Targets,
+		peers: newPeerManager(config.Peers, config.Targets),
+		addresses: addresses,
+		addressRequests: newAddressRequests(),
+		bans: bans,
+		gossip: config.Gossip,
+		seen: newSeenCache(config.Gossip.CacheSize),
+		network: network.TestNet,
+		version: network.ProtocolVersion,
+		minVersion: network.MinProtocolVersion,
+		capabilities: config.Capabilities,
+		transport: config.Transport,
+		walletAddress: config.ProducerAddress,
+		producers: producers,
 		//keyPairs: make(map[string]*crypto.PrivateKey, 0),
 		conns: make(map[string]*network.Connection, 0),
 		newConn: make(chan *network.Connection),
 		doneConn: make(chan *network.Connection),
-		managers: make(map[string]network.BaseManager, 0),
+		router: newRouter(),
+		rpc: network.NewRPCServer(config.MaxConcurrentRequests),
+		sessions: make(map[*network.Connection]bool, 0),
+		quit: make(chan struct{}),
 		//newMessage: make(chan *network.ReceiveMessage),
+		blockLog: blockLog,
+		mempool: mempool.NewMempool(config.Mempool),
+		application: config.Application,
+		appHashes: newAppHashes(),
+	}
+	node.keyPair = keyPair{
+		publicKey: 	privateKey.PublicKey(),
+		privateKey: privateKey,
+	}
+	node.id = nodeId(node.keyPair.publicKey)
+	if node.capabilities == 0 {
+		node.capabilities = network.SupportedCapabilities
+	}
+	node.capabilities |= network.RequiredCapabilities
+	node.mempool.SetChain(blockLog)
+	if node.application == nil {
+		node.application = application.NewKVStore()
+	}
+	if node.transport == nil {
+		// a block larger than the genesis allows is rejected before its payload is read
+		_, maxBytes := node.blockLimits()
+		node.transport = &network.TCPTransport{
+			Network: 		node.network,
+			MaxBlockSize: 	uint32(maxBytes) + blockchain.MaxBlockOverhead,
+		}
+	}
+	if err := node.replayBlocks(); err != nil {
+		return nil, err
+	}
+	node.scheduler = consensus.NewScheduler(genesis.Timestamp, genesis.BlockInterval(), producers)
+	node.forkTree = blockchain.NewForkTree(blockLog.TopBlockId(), blockLog.TopBlockHeight(), config.ForkChoice, node.onReorg)
+	node.election = consensus.NewElectionManager(node.Signer, node.walletAddress, node.Broadcast)
+	node.election.SetProducers(producers)
+	if err := node.AddManager(node.election, network.ElectionManager); err != nil {
+		return nil, err
 	}
-	electionManager := consensus.NewElectionManager(node.Signer, node.walletAddress)
-	node.addManager(electionManager, network.ElectionManager)
-	return node
+	node.commitManager = consensus.NewCommitManager(node.Signer, node.walletAddress, node.Broadcast, node.finalize)
+	node.commitManager.SetProducers(producers)
+	node.commitManager.SetChain(node.forkTree)
+	// the locks are kept next to the block log
+	if err := node.commitManager.LoadLocks(filepath.Join(config.DataDir, "blocks", "locks.json")); err != nil {
+		return nil, err
+	}
+	node.commitManager.Finalized(blockLog.TopBlockHeight())
+	if err := node.AddManager(node.commitManager, network.CommitManager); err != nil {
+		return nil, err
+	}
+	node.sync = newSyncManager(node)
+	if err := node.AddManager(node.sync, network.SyncManager); err != nil {
+		return nil, err
+	}
+	return node, nil
 }
 
 func (node *Node) Start() {
-	go node.connectsToTargets()
+	node.mutex.Lock()
+	node.started = true
+	node.mutex.Unlock()
+	for _, manager := range node.router.all() {
+		manager.Start()
+	}
+	go node.maintainPeers()
+	go node.keepAlive()
 	go node.listen()
+	if node.walletAddress != "" {
+		go node.produceLoop()
+	}
 }
 
-func (node *Node) addManager(manager network.BaseManager, id string) {
-	node.mutex.Lock()
-	defer node.mutex.Unlock()
-	node.managers[id] = manager
+// Stop closes the listener and every connection, the managers are stopped in the reverse order
+func (node *Node) Stop() {
+	node.stopOnce.Do(func() {
+		close(node.quit)
+		node.mutex.Lock()
+		listener := node.listener
+		conns := make([]*network.Connection, 0, len(node.conns))
+		for _, c := range node.conns {
+			conns = append(conns, c)
+		}
+		node.mutex.Unlock()
+		if listener != nil {
+			listener.Close()
+		}
+		managers := node.router.all()
+		for i := len(managers) - 1; i >= 0; i-- {
+			managers[i].Stop()
+		}
+		if node.forkTree != nil {
+			node.forkTree.Close()
+		}
+		for _, c := range conns {
+			c.Close()
+		}
+	})
 }
 
-// connect to specific remote peers
-func (node *Node) connectsToTargets() {
-	fmt.Println("connecting to peers ...")
-	for _, addr := range node.targets {
-		c, err := network.NewOutgoingConnection(addr, node.OnReceive, node.OnFinish)
-		if err != nil {
-			continue
-		}
-		node.newConn <- c
+func (node *Node) stopped() bool {
+	select {
+	case <-node.quit:
+		return true
+	default:
+		return false
 	}
 }
 
 // listen from remote peers
 func (node *Node) listen() error {
-	listener, err := net.Listen(network.TCP, node.p2pAddress)
+	listener, err := node.transport.Listen(node.p2pAddress, node.keyPair.privateKey)
 	if err != nil {
+		fmt.Println(err)
 		return err
 	}
+	node.mutex.Lock()
+	node.listener = listener
+	node.mutex.Unlock()
+	if node.stopped() {
+		listener.Close()
+		return nil
+	}
 	go func() {
 		for {
-			conn, err := listener.Accept()
+			stream, err := listener.Accept()
 			if err != nil {
+				if node.stopped() {
+					return
+				}
 				panic(err)
 			}
-			c := network.NewIncomingConnection(conn, node.OnReceive, node.OnFinish)
-			node.newConn <- c
+			if node.isBannedStream(stream) {
+				stream.Close()
+				continue
+			}
+			select {
+			case node.newConn <- network.NewIncomingConnection(stream, node.OnReceive, node.OnFinish):
+			case <-node.quit:
+				stream.Close()
+				return
+			}
 		}
 	}()
 	for {
 		select {
+		case <-node.quit:
+			return nil
 		case connection := <-node.newConn:
+			// the dialed peers are only known by their key once the stream is secured
+			if connection.IsOutgoing() && node.isBannedStream(connection) {
+				connection.Close()
+				node.peerFinished(connection)
+				continue
+			}
+			// counted here, the connections accepted before are only added by this loop
+			if !connection.IsOutgoing() && !node.acceptInbound() {
+				fmt.Println("too many incoming connections, rejecting ", connection.RemoteAddress())
+				connection.Close()
+				continue
+			}
 			fmt.Println("accepted new client from address ", connection.RemoteAddress())
 			node.addConnection(connection)
+			connection.SetMisbehaveFunc(node.misbehaved)
+			connection.SetWriteQueueSize(node.peers.config.WriteQueueSize)
+			// the handshake is queued first, nothing the read loop answers can overtake it
+			node.sendHandshake(connection)
 			connection.Start()
 		case doneConnection := <-node.doneConn:
 			fmt.Println("disconnected client from address ", doneConnection.RemoteAddress())
 			node.removeConnection(doneConnection)
+			node.addressRequests.forget(doneConnection)
+			node.peerFinished(doneConnection)
+			node.peerDisconnected(doneConnection)
 		}
 	}
-	return nil
 }
 
 func (node *Node) addConnection(c *network.Connection) {
@@ -113,13 +318,6 @@ func (node *Node) addConnection(c *network.Connection) {
 	node.conns[c.RemoteAddress()] = c
 }
 
-func (node *Node) sendHandshake(c *network.Connection) {
-	if c.IsOutgoing() {
-		handshake := node.newHandshakePacket()
-		c.Send(handshake)
-	}
-}
-
 func (node *Node) removeConnection(c *network.Connection) {
 	node.mutex.Lock()
 	defer node.mutex.Unlock()
@@ -128,24 +326,78 @@ func (node *Node) removeConnection(c *network.Connection) {
 }
 
 func (node *Node) OnReceive(receiveMessage network.ReceiveMessage) {
-	messageType := receiveMessage.Message.Header.Type
+	message := receiveMessage.Message
 	c := receiveMessage.Conn
-	switch messageType {
-	case network.Handshake:
+	// nothing but the handshake is accepted before the session is established
+	if !c.IsEstablished() && message.Header.Type != network.Handshake {
+		fmt.Println("message before handshake from ", c.RemoteAddress())
+		c.Misbehave(network.Misbehavior(network.ProtocolViolation, "message %d before handshake", message.Header.Type))
+		c.Close()
+		return
+	}
+	if capability, ok := network.RequiredCapability(message.Header.Type); ok && !c.Supports(capability) {
+		c.Misbehave(network.Misbehavior(network.ProtocolViolation, "message %d wasn't negotiated", message.Header.Type))
+		return
+	}
+	if node.dispatch(c, message) {
+		return
+	}
+	payload, err := network.DecodeMessage(message)
+	if err != nil {
+		fmt.Println(err)
+		c.Misbehave(network.Misbehavior(network.MalformedMessage, "%s", err))
+		return
+	}
+	switch packet := payload.(type) {
+	case network.HandshakePacket:
 		fmt.Println("handshake")
-		handshake := network.HandshakePacket{}
-		network.UnmarshalBinary(receiveMessage.Message.Payload, &handshake)
-		node.handleHandshake(c, handshake)
-	case network.Notice:
-	case network.Request:
-	case network.Block:
-	case network.RequestNewTerm, network.RequestVote, network.GrantVote:
-		node.managers[network.ElectionManager].Receive(c, receiveMessage.Message)
+		node.handleHandshake(c, packet)
+	case network.GetAddressesPacket:
+		node.handleGetAddresses(c, packet)
+	case network.AddressesPacket:
+		node.handleAddresses(c, packet)
+	case network.PingPacket:
+		if err := c.Pong(packet); err != nil {
+			fmt.Println(err)
+		}
+	case network.PongPacket:
+		if err := c.ReceivedPong(packet, time.Now()); err != nil {
+			fmt.Println(err)
+		}
+	case network.RPCRequestPacket:
+		// a slow handler must not hold up the read loop
+		go node.rpc.Serve(c, packet)
+	case network.RPCResponsePacket:
+		if err := c.ReceivedResponse(packet); err != nil {
+			fmt.Println(err)
+			c.Misbehave(err)
+		}
+	case blockchain.SignedBlock:
+		// blocks requested by the sync are answers, not gossip
+		if node.sync.receivedBlock(c, &packet) || !node.receiveGossip(c, message) {
+			return
+		}
+		if err := node.acceptBlock(&packet); err != nil {
+			fmt.Println("block is rejected: ", err)
+			// a block on an unknown parent may just be ahead of this node,
+			// and an early one may be on time for a peer whose clock is ahead
+			_, early := err.(*consensus.EarlyBlockError)
+			misbehavior := !early && node.hasBlock(packet.Header.PreviousId)
+			if misbehavior {
+				c.Misbehave(network.Misbehavior(network.InvalidBlock, "%s", err))
+			}
+			node.rejectGossip(message, misbehavior)
+			return
+		}
+		node.relay(c, message)
 	}
 }
 
 func (node *Node) OnFinish(c *network.Connection) {
-	node.doneConn <- c
+	select {
+	case node.doneConn <- c:
+	case <-node.quit:
+	}
 }
 
 func (node *Node) Signer(hash blockchain.SHA256Type) crypto.Signature {
@@ -154,30 +406,99 @@ func (node *Node) Signer(hash blockchain.SHA256Type) crypto.Signature {
 	return sign
 }
 
-func (node *Node) handleHandshake(c *network.Connection, handshake network.HandshakePacket) {
+func (node *Node) onReorg(event blockchain.ReorgEvent) {
+	if len(event.Detached) > 0 {
+		fmt.Println("switching fork, detached ", len(event.Detached), " blocks, attached ", len(event.Attached), " blocks")
+	}
+	node.mempool.Update(event)
+	for _, block := range event.Attached {
+		// blocks the network finalized long ago don't need the vote of this node
+		if !node.sync.isBehind(block.Header.Height) {
+			node.commitManager.PreCommit(block.Header.Id, block.Header.Height)
+		}
+	}
+	if !node.sync.IsSynchronizing() {
+		node.BroadcastTo(node.notice(), func(c *network.Connection) bool {
+			return c.Supports(network.CapabilityHeaderSync)
+		})
+	}
 }
 
-func (node *Node) newHandshakePacket() network.HandshakePacket {
-	publicKey := node.keyPair.publicKey
-	privateKey := node.keyPair.privateKey
-	info := network.HandshakeInfo{
-		Network:				network.TestNet,
-		Version:				1,
-		ChainId: 				node.chainId,
-		NodeId: 				node.id,
-		Key: 					*publicKey,
-		OriginAddress: 			node.p2pAddress,
-		LastCommitBlockHeight: 	0,
-		LastCommitBlockId: 		blockchain.SHA256Type{},
-		TopBlockHeight: 		0,
-		TopBlockId:				blockchain.SHA256Type{},
-		Timestamp:				time.Now(),
-	}
-	buf, _ := network.MarshalBinary(info)
-	hash := sha256.Sum256(buf)
-	sign, _ := privateKey.Sign(hash[:])
-	return network.HandshakePacket{
-		Info: info,
-		Sign: sign,
+// IsSynchronizing reports whether this node is catching up with its peers
+func (node *Node) IsSynchronizing() bool {
+	return node.sync.IsSynchronizing()
+}
+
+// SubmitTransaction adds a transaction to the mempool of this node
+func (node *Node) SubmitTransaction(tx blockchain.Transaction) error {
+	if err := node.application.CheckTx(tx); err != nil {
+		return err
+	}
+	return node.mempool.Add(tx)
+}
+
+// replayBlocks executes the irreversible blocks that the application hasn't committed yet.
+// An application ahead of the log executed blocks whose append failed, finalize logs them
+// again without executing them.
+func (node *Node) replayBlocks() error {
+	node.appHashes.add(node.application.Height(), node.application.AppHash())
+	for height := node.application.Height() + 1; height <= node.blockLog.TopBlockHeight(); height++ {
+		block, err := node.blockLog.ReadBlockByHeight(height)
+		if err != nil {
+			return err
+		}
+		if err := node.checkAppHash(block.Header); err != nil {
+			return err
+		}
+		hash, err := application.ExecuteBlock(node.application, block)
+		if err != nil {
+			return err
+		}
+		node.appHashes.add(height, hash)
+	}
+	return nil
+}
+
+// finalize makes the committed block the last irreversible block,
+// it and its ancestors are executed and moved from the fork tree to the block log.
+// A block is appended to the log only once the application executed it, a block the
+// application is already past because its append failed isn't executed again.
+func (node *Node) finalize(blockId blockchain.SHA256Type) error {
+	node.finalizeMutex.Lock()
+	defer node.finalizeMutex.Unlock()
+	if node.blockLog.HasBlock(blockId) {
+		return nil
+	}
+	if err := node.forkTree.MarkCommitted(blockId); err != nil {
+		return err
 	}
-}
\ No newline at end of file
+	blocks, err := node.forkTree.Branch(blockId)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		node.commitManager.Finalized(node.blockLog.TopBlockHeight())
+	}()
+	for _, block := range blocks {
+		if err := node.checkAppHash(block.Header); err != nil {
+			return err
+		}
+		hash := node.application.AppHash()
+		if block.Header.Height > node.application.Height() {
+			if hash, err = application.ExecuteBlock(node.application, block); err != nil {
+				return err
+			}
+		}
+		if err := node.blockLog.Append(block); err != nil {
+			return err
+		}
+		node.appHashes.add(block.Header.Height, hash)
+		node.mempool.Commit(block)
+		// the fork tree keeps its root on the last block of the log
+		if _, err := node.forkTree.Prune(block.Header.Id); err != nil {
+			return err
+		}
+	}
+	fmt.Println("last irreversible block ", node.blockLog.TopBlockHeight())
+	return nil
+}
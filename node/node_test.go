@@ -0,0 +1,71 @@
+package node
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+	"consensus_layer/application"
+	"consensus_layer/blockchain"
+	"consensus_layer/consensus"
+	"consensus_layer/mempool"
+)
+
+func TestFinalizeAfterFailedAppend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "finalize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blockLog, err := blockchain.OpenBlockLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &Node{
+		blockLog: 		blockLog,
+		forkTree: 		blockchain.NewForkTree(blockchain.SHA256Type{}, 0, blockchain.LongestChain, nil),
+		commitManager: 	consensus.NewCommitManager(nil, "", nil, nil),
+		mempool: 		mempool.NewMempool(mempool.Config{}),
+		application: 	application.NewKVStore(),
+		appHashes: 		newAppHashes(),
+	}
+	node.appHashes.add(0, node.application.AppHash())
+	blocks := make([]*blockchain.SignedBlock, 0)
+	previousId := blockchain.SHA256Type{}
+	for height := uint64(1); height <= 2; height++ {
+		block := &blockchain.SignedBlock{}
+		block.Header.Height = height
+		block.Header.PreviousId = previousId
+		block.Header.Producer = "producer1"
+		block.Header.Timestamp = time.Unix(int64(1500000000 + height), 0).UTC()
+		block.Header.AppHash = node.application.AppHash()
+		block.Seal()
+		if err := node.forkTree.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+		previousId = block.Header.Id
+	}
+	// the application executes the first block, then the log can't be written
+	blockLog.Close()
+	if err := node.finalize(blocks[0].Header.Id); err == nil {
+		t.Fatal("finalize should fail when the block can't be logged")
+	}
+	if node.application.Height() != 1 || node.blockLog.TopBlockHeight() != 0 {
+		t.Fatal("the application should be one block ahead of the log")
+	}
+	node.blockLog, err = blockchain.OpenBlockLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.blockLog.Close()
+	if err := node.finalize(blocks[1].Header.Id); err != nil {
+		t.Fatal(err)
+	}
+	if node.application.Height() != 2 || node.blockLog.TopBlockHeight() != 2 {
+		t.Fatalf("application at %d and log at %d, both should be at 2", node.application.Height(), node.blockLog.TopBlockHeight())
+	}
+	if _, ok := node.appHashes.get(1); !ok {
+		t.Fatal("state after the block logged late should be remembered")
+	}
+}
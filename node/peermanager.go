@@ -0,0 +1,347 @@
+package node
+
+import (
+	"consensus_layer/blockchain"
+	"consensus_layer/network"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const DefaultMaxInbound = 32
+const DefaultMaxOutbound = 16
+const DefaultReconnectBaseDelay = time.Second
+const DefaultReconnectMaxDelay = time.Minute
+const DefaultPingInterval = 10 * time.Second
+const DefaultMaxMissedPongs = 3
+const DefaultHandshakeTimeout = 10 * time.Second
+const DefaultWriteQueueSize = network.DefaultWriteQueueSize
+const peerCheckInterval = 200 * time.Millisecond
+
+type PeerConfig struct {
+	MaxInbound 			int
+	MaxOutbound 		int
+	ReconnectBaseDelay 	time.Duration
+	ReconnectMaxDelay 	time.Duration
+	PingInterval 		time.Duration
+	MaxMissedPongs 		int // peers that miss this many pongs in a row are disconnected
+	HandshakeTimeout 	time.Duration // connections whose handshake isn't accepted by then are closed
+	BanThreshold 		int // misbehavior score at which a peer is banned
+	BanDuration 		time.Duration
+	WriteQueueSize 		int // messages of each priority waiting to be written to a peer
+}
+
+// target is a peer that the node dials, configured targets are kept connected
+// while discovered ones are dropped after a single session or failed attempt
+type target struct {
+	address 	string
+	persistent 	bool
+	conn 		*network.Connection
+	nodeId 		*blockchain.SHA256Type // learned from the first handshake
+	dialing 	bool
+	attempts 	int // failed attempts since the last established session
+	nextAttempt time.Time
+}
+
+type peerManager struct {
+	config 		PeerConfig
+	targets 	map[string]*target
+	known 		map[string]blockchain.SHA256Type // node ids of the discovered addresses whose session ended
+	random 		*rand.Rand
+	mutex 		sync.Mutex
+}
+
+func newPeerManager(config PeerConfig, addresses []string) *peerManager {
+	if config.MaxInbound <= 0 {
+		config.MaxInbound = DefaultMaxInbound
+	}
+	if config.MaxOutbound <= 0 {
+		config.MaxOutbound = DefaultMaxOutbound
+	}
+	if config.ReconnectBaseDelay <= 0 {
+		config.ReconnectBaseDelay = DefaultReconnectBaseDelay
+	}
+	if config.ReconnectMaxDelay <= 0 {
+		config.ReconnectMaxDelay = DefaultReconnectMaxDelay
+	}
+	if config.PingInterval <= 0 {
+		config.PingInterval = DefaultPingInterval
+	}
+	if config.MaxMissedPongs <= 0 {
+		config.MaxMissedPongs = DefaultMaxMissedPongs
+	}
+	if config.HandshakeTimeout <= 0 {
+		config.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	if config.BanThreshold <= 0 {
+		config.BanThreshold = DefaultBanThreshold
+	}
+	if config.BanDuration <= 0 {
+		config.BanDuration = DefaultBanDuration
+	}
+	if config.WriteQueueSize <= 0 {
+		config.WriteQueueSize = DefaultWriteQueueSize
+	}
+	pm := &peerManager{
+		config: 	config,
+		targets: 	make(map[string]*target, 0),
+		known: 		make(map[string]blockchain.SHA256Type, 0),
+		random: 	rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, address := range addresses {
+		pm.targets[address] = &target{address: address, persistent: true}
+	}
+	return pm
+}
+
+// backoff returns a random delay in [d/2, d] where d doubles with every failed attempt
+func (pm *peerManager) backoff(attempts int) time.Duration {
+	delay := pm.config.ReconnectBaseDelay
+	for i := 1; i < attempts && delay < pm.config.ReconnectMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > pm.config.ReconnectMaxDelay {
+		delay = pm.config.ReconnectMaxDelay
+	}
+	half := int64(delay / 2)
+	return time.Duration(half + pm.random.Int63n(half + 1))
+}
+
+func (pm *peerManager) failed(t *target) {
+	t.attempts++
+	t.nextAttempt = time.Now().Add(pm.backoff(t.attempts))
+}
+
+// maintainPeers dials the targets that aren't connected once their backoff has elapsed
+// and fills the remaining outgoing slots with addresses from the address book
+func (node *Node) maintainPeers() {
+	ticker := time.NewTicker(peerCheckInterval)
+	defer ticker.Stop()
+	lastSave := time.Now()
+	for {
+		now := time.Now()
+		node.dialTargets(now)
+		node.dialDiscovered(now)
+		node.expireHandshakes(now)
+		if now.Sub(lastSave) >= addressBookSaveInterval {
+			if err := node.addresses.save(); err != nil {
+				fmt.Println("can not save address book: ", err)
+			}
+			lastSave = now
+		}
+		select {
+		case <-ticker.C:
+		case <-node.quit:
+			if err := node.addresses.save(); err != nil {
+				fmt.Println("can not save address book: ", err)
+			}
+			return
+		}
+	}
+}
+
+func (node *Node) dialTargets(now time.Time) {
+	pm := node.peers
+	outbound := node.countConnections(true)
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	for _, t := range pm.targets {
+		if outbound >= pm.config.MaxOutbound {
+			return
+		}
+		if t.conn != nil || t.dialing || now.Before(t.nextAttempt) || node.isBanned(t.nodeId, t.address) {
+			continue
+		}
+		// the peer may already be connected through its own outgoing connection
+		if t.nodeId != nil && node.isConnectedTo(*t.nodeId) {
+			continue
+		}
+		t.dialing = true
+		outbound++
+		go node.dial(t)
+	}
+}
+
+func (node *Node) dialDiscovered(now time.Time) {
+	pm := node.peers
+	outbound := node.countConnections(true)
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	for _, t := range pm.targets {
+		if t.dialing {
+			outbound++
+		}
+	}
+	if outbound >= pm.config.MaxOutbound {
+		return
+	}
+	for _, address := range node.addresses.candidates(now, pm.backoff) {
+		if outbound >= pm.config.MaxOutbound {
+			return
+		}
+		if _, ok := pm.targets[address]; ok || address == node.p2pAddress || node.isBanned(nil, address) || node.isConnectedToAddress(address) {
+			continue
+		}
+		// e.g. a duplicate of a peer that is connected through its own outgoing connection
+		if id, ok := pm.known[address]; ok && node.isConnectedTo(id) {
+			continue
+		}
+		delete(pm.known, address)
+		t := &target{address: address, dialing: true}
+		pm.targets[address] = t
+		node.addresses.attempted(address, now)
+		outbound++
+		go node.dial(t)
+	}
+}
+
+func (node *Node) dial(t *target) {
+	c, err := network.NewOutgoingConnection(node.transport, t.address, node.keyPair.privateKey, node.OnReceive, node.OnFinish)
+	pm := node.peers
+	pm.mutex.Lock()
+	t.dialing = false
+	if err != nil {
+		if !t.persistent {
+			node.addresses.failed(t.address)
+			delete(pm.targets, t.address)
+			pm.mutex.Unlock()
+			return
+		}
+		pm.failed(t)
+		fmt.Println("can not connect to ", t.address, ", retry in ", t.nextAttempt.Sub(time.Now()))
+		pm.mutex.Unlock()
+		return
+	}
+	t.conn = c
+	pm.mutex.Unlock()
+	select {
+	case node.newConn <- c:
+	case <-node.quit:
+		c.Close()
+	}
+}
+
+// acceptInbound reports whether another incoming connection is allowed
+func (node *Node) acceptInbound() bool {
+	return node.countConnections(false) < node.peers.config.MaxInbound
+}
+
+func (node *Node) countConnections(outgoing bool) int {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	count := 0
+	for _, c := range node.conns {
+		if c.IsOutgoing() == outgoing {
+			count++
+		}
+	}
+	return count
+}
+
+// isConnectedTo reports whether there is an established session with the node
+func (node *Node) isConnectedTo(id blockchain.SHA256Type) bool {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	for _, c := range node.conns {
+		if info := c.PeerInfo(); info != nil && info.NodeId == id {
+			return true
+		}
+	}
+	return false
+}
+
+// isConnectedToAddress reports whether a peer is connected from or listening on the address
+func (node *Node) isConnectedToAddress(address string) bool {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	for _, c := range node.conns {
+		if c.RemoteAddress() == address {
+			return true
+		}
+		if info := c.PeerInfo(); info != nil && advertisedAddress(info.OriginAddress, c.RemoteAddress()) == address {
+			return true
+		}
+	}
+	return false
+}
+
+// peerEstablished is called after the handshake of c is accepted.
+// If the peer is already connected the duplicate is closed; both sides keep the connection
+// dialed by the node with the smaller id so they agree on which one survives. Of two connections
+// in the same direction the newer one is kept, the older one may be stale.
+func (node *Node) peerEstablished(c *network.Connection) bool {
+	info := c.PeerInfo()
+	node.mutex.Lock()
+	var existing *network.Connection
+	for _, other := range node.conns {
+		if other == c {
+			continue
+		}
+		if otherInfo := other.PeerInfo(); otherInfo != nil && otherInfo.NodeId == info.NodeId {
+			existing = other
+			break
+		}
+	}
+	node.mutex.Unlock()
+
+	pm := node.peers
+	pm.mutex.Lock()
+	for _, t := range pm.targets {
+		if t.conn == c {
+			id := info.NodeId
+			t.nodeId = &id
+			t.attempts = 0
+			node.addresses.seen(t.address, time.Now())
+		}
+	}
+	pm.mutex.Unlock()
+	if !c.IsOutgoing() {
+		now := time.Now()
+		node.addresses.add(advertisedAddress(info.OriginAddress, c.RemoteAddress()), now, now)
+	}
+
+	if existing == nil {
+		return true
+	}
+	keepOutgoing := bytes.Compare(node.id[:], info.NodeId[:]) < 0
+	duplicate := existing
+	if c.IsOutgoing() != existing.IsOutgoing() && c.IsOutgoing() != keepOutgoing {
+		duplicate = c
+	}
+	fmt.Println("closing duplicated connection to ", info.OriginAddress)
+	duplicate.Close()
+	return duplicate != c
+}
+
+// peerFinished schedules a reconnection if c was the connection to a configured target
+func (node *Node) peerFinished(c *network.Connection) {
+	pm := node.peers
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	for address, t := range pm.targets {
+		if t.conn != c {
+			continue
+		}
+		t.conn = nil
+		if t.persistent {
+			pm.failed(t)
+			continue
+		}
+		// a discovered address that never completed a handshake counts as a failure
+		if t.nodeId == nil {
+			node.addresses.failed(address)
+		} else {
+			// the addresses of the book are bounded, so are the ones remembered here
+			for old := range pm.known {
+				if len(pm.known) < MaxAddresses {
+					break
+				}
+				delete(pm.known, old)
+			}
+			pm.known[address] = *t.nodeId
+		}
+		delete(pm.targets, address)
+	}
+}
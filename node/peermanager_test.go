@@ -0,0 +1,139 @@
+package node
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"consensus_layer/blockchain"
+	"consensus_layer/network"
+)
+
+func TestPeerBackoff(t *testing.T) {
+	pm := newPeerManager(PeerConfig{
+		ReconnectBaseDelay: time.Second,
+		ReconnectMaxDelay: 	10 * time.Second,
+	}, []string{"localhost:2001"})
+	for attempts, max := range []time.Duration{1, 1, 2, 4, 8, 10, 10} {
+		if attempts == 0 {
+			continue
+		}
+		max *= time.Second
+		for i := 0; i < 20; i++ {
+			delay := pm.backoff(attempts)
+			if delay < max / 2 || delay > max {
+				t.Fatalf("delay of attempt %d should be in [%s, %s], got %s", attempts, max / 2, max, delay)
+			}
+		}
+	}
+	target := pm.targets["localhost:2001"]
+	pm.failed(target)
+	pm.failed(target)
+	if target.attempts != 2 || !target.nextAttempt.After(time.Now()) {
+		t.Fatal("next attempt should be delayed")
+	}
+}
+
+func TestSimultaneousDials(t *testing.T) {
+	sim := network.NewSimNetwork(network.SimConfig{Seed: 4, Latency: 5 * time.Millisecond})
+	// both nodes dial each other as soon as they start
+	nodes, dir := newSimNodesWith(t, sim, 2, func(i int, config *Config) {
+		config.Targets = []string{fmt.Sprintf("10.0.0.%d:9000", 2 - i)}
+	})
+	defer os.RemoveAll(dir)
+	defer stopNodes(nodes)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		conns0, conns1 := nodes[0].establishedConnections(), nodes[1].establishedConnections()
+		if len(conns0) == 1 && len(conns1) == 1 && nodes[0].countConnections(true) + nodes[0].countConnections(false) == 1 &&
+			nodes[1].countConnections(true) + nodes[1].countConnections(false) == 1 {
+			// both ends kept the same link
+			if conns0[0].LocalAddress() != conns1[0].RemoteAddress() || conns0[0].IsOutgoing() == conns1[0].IsOutgoing() {
+				t.Fatal("nodes kept different connections of the pair")
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("each node should keep a single connection, got %d and %d", len(conns0), len(conns1))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestInboundLimit(t *testing.T) {
+	sim := network.NewSimNetwork(network.SimConfig{Seed: 5, Latency: 5 * time.Millisecond})
+	// the second and the third node dial the first one, which accepts a single incoming connection
+	nodes, dir := newSimNodesWith(t, sim, 3, func(i int, config *Config) {
+		config.Targets = nil
+		if i > 0 {
+			config.Targets = []string{"10.0.0.1:9000"}
+		} else {
+			config.Peers.MaxInbound = 1
+		}
+	})
+	defer os.RemoveAll(dir)
+	defer stopNodes(nodes)
+	accepted := false
+	for end := time.Now().Add(2 * time.Second); time.Now().Before(end); {
+		inbound := nodes[0].countConnections(false)
+		if inbound > 1 {
+			t.Fatal("node should reject incoming connections over its limit, got ", inbound)
+		}
+		accepted = accepted || inbound == 1
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !accepted {
+		t.Fatal("node should accept an incoming connection under its limit")
+	}
+}
+
+func TestDuplicateConnections(t *testing.T) {
+	dir, err := ioutil.TempDir("", "duplicates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	addresses, err := loadAddressBook(filepath.Join(dir, "peers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bans, err := loadBanList(filepath.Join(dir, "bans.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := network.NewSimNetwork(network.SimConfig{Seed: 6})
+	node := &Node{
+		peers: 		newPeerManager(PeerConfig{}, nil),
+		addresses: 	addresses,
+		bans: 		bans,
+		conns: 		make(map[string]*network.Connection, 0),
+		transport: 	sim.Transport("10.0.0.1:9000"),
+	}
+	peerId := blockchain.SHA256Type{1}
+	newPeerConnection := func(address string) *network.Connection {
+		c := newTestConnection(address)
+		c.SetPeerInfo(network.HandshakeInfo{NodeId: peerId, OriginAddress: "10.0.0.9:9000"})
+		return c
+	}
+	// the peer reconnects before its stale connection is closed
+	stale, fresh := newPeerConnection("10.0.0.2:40000"), newPeerConnection("10.0.0.2:40001")
+	node.conns[stale.RemoteAddress()] = stale
+	node.conns[fresh.RemoteAddress()] = fresh
+	if !node.peerEstablished(fresh) || stale.IsAvailable() {
+		t.Fatal("newer connection in the same direction should replace the older one")
+	}
+	delete(node.conns, stale.RemoteAddress())
+
+	// a discovered address closed as a duplicate isn't dialed again while its node is connected
+	duplicate := newPeerConnection("10.0.0.3:9000")
+	node.peers.targets["10.0.0.3:9000"] = &target{address: "10.0.0.3:9000", conn: duplicate, nodeId: &peerId}
+	node.peerFinished(duplicate)
+	now := time.Now()
+	node.addresses.add("10.0.0.3:9000", now, now)
+	node.dialDiscovered(now)
+	if _, ok := node.peers.targets["10.0.0.3:9000"]; ok {
+		t.Fatal("address of a connected node shouldn't be dialed again")
+	}
+}
@@ -0,0 +1,214 @@
+package node
+
+import (
+	"bytes"
+	"consensus_layer/blockchain"
+	"consensus_layer/consensus"
+	"consensus_layer/mempool"
+	"consensus_layer/crypto"
+	"consensus_layer/serializer"
+	"fmt"
+	"time"
+)
+
+// produceLoop waits for every slot of this producer and produces a block in it
+func (node *Node) produceLoop() {
+	for {
+		slot, err := node.scheduler.NextSlot(node.walletAddress, time.Now())
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		slotTime := node.scheduler.SlotTime(slot)
+		select {
+		case <-time.After(time.Until(slotTime)):
+		case <-node.quit:
+			return
+		}
+		// a block on top of a stale head would only create a fork
+		if node.sync.blocksProduction(time.Now()) {
+			continue
+		}
+		block, err := node.produceBlock(slotTime)
+		if err != nil {
+			fmt.Println("can not produce block: ", err)
+			continue
+		}
+		if err := node.acceptBlock(block); err != nil {
+			fmt.Println("produced block is rejected: ", err)
+			continue
+		}
+		fmt.Println("produced block ", block.Header.Height, " in slot ", slot)
+		node.Broadcast(*block)
+	}
+}
+
+// produceBlock builds and signs a block on top of the head with the pending transactions
+func (node *Node) produceBlock(timestamp time.Time) (*blockchain.SignedBlock, error) {
+	maxCount, maxBytes := node.blockLimits()
+	block := &blockchain.SignedBlock{
+		Transactions: node.mempool.Pending(maxCount, maxBytes),
+	}
+	block.Header = blockchain.BlockHeader{
+		Height: 		node.forkTree.HeadHeight() + 1,
+		PreviousId: 	node.forkTree.HeadId(),
+		Producer: 		node.walletAddress,
+		Timestamp: 		timestamp.UTC(),
+		TransactionRoot: block.CalculateTransactionRoot(),
+	}
+	node.finalizeMutex.Lock()
+	block.Header.AppHeight = node.application.Height()
+	block.Header.AppHash = node.application.AppHash()
+	node.finalizeMutex.Unlock()
+	signedHeader, err := blockchain.SignHeader(block.Header, node.keyPair.privateKey)
+	if err != nil {
+		return nil, err
+	}
+	block.SignedHeader = signedHeader
+	return block, nil
+}
+
+// blockLimits returns the maximum number of transactions of a block and their maximum total size
+func (node *Node) blockLimits() (int, int) {
+	params := node.genesis.Consensus
+	maxCount, maxBytes := int(params.MaxBlockTransactions), int(params.MaxBlockBytes)
+	if maxCount == 0 {
+		maxCount = mempool.DefaultMaxCount
+	}
+	if maxBytes == 0 {
+		maxBytes = mempool.DefaultMaxBytes
+	}
+	return maxCount, maxBytes
+}
+
+// validateBlockSize checks the transactions of the block against the limits of the genesis,
+// their size is counted the way the mempool counts it when the block is produced
+func (node *Node) validateBlockSize(block *blockchain.SignedBlock) error {
+	maxCount, maxBytes := node.blockLimits()
+	if len(block.Transactions) > maxCount {
+		return fmt.Errorf("block has %d transactions, the limit is %d", len(block.Transactions), maxCount)
+	}
+	size := 0
+	for _, tx := range block.Transactions {
+		buf, err := serializer.MarshalBinary(tx)
+		if err != nil {
+			return err
+		}
+		size += len(buf)
+		if size > maxBytes {
+			return fmt.Errorf("transactions of the block exceed %d bytes", maxBytes)
+		}
+	}
+	return nil
+}
+
+// validateNonces checks that the nonces of every sender in the block follow its last nonce on the
+// branch of the parent without a gap, so a transaction can't be included twice
+func (node *Node) validateNonces(block *blockchain.SignedBlock) error {
+	if len(block.Transactions) == 0 {
+		return nil
+	}
+	// the branch is read before the log, a block pruned in between is already in the log
+	branch, err := node.forkTree.Branch(block.Header.PreviousId)
+	if err != nil {
+		return err
+	}
+	last := make(map[string]uint64, 0)
+	for _, tx := range block.Transactions {
+		sender := string(tx.Sender.Data)
+		if _, ok := last[sender]; !ok {
+			last[sender] = node.blockLog.LastNonce(tx.Sender)
+		}
+	}
+	for _, parent := range branch {
+		for _, tx := range parent.Transactions {
+			sender := string(tx.Sender.Data)
+			if nonce, ok := last[sender]; ok && tx.Nonce > nonce {
+				last[sender] = tx.Nonce
+			}
+		}
+	}
+	for i, tx := range block.Transactions {
+		sender := string(tx.Sender.Data)
+		if tx.Nonce != last[sender] + 1 {
+			return fmt.Errorf("transaction %d has nonce %d, the sender is at %d", i, tx.Nonce, last[sender])
+		}
+		last[sender] = tx.Nonce
+	}
+	return nil
+}
+
+// acceptBlock validates the block against the producer schedule and adds it to the fork tree
+func (node *Node) acceptBlock(block *blockchain.SignedBlock) error {
+	header := block.Header
+	if node.forkTree.HasBlock(header.Id) || node.blockLog.HasBlock(header.Id) {
+		return nil
+	}
+	var parent *blockchain.BlockHeader
+	if previous, err := node.getBlock(header.PreviousId); err == nil {
+		parent = &previous.Header
+	}
+	if err := node.scheduler.ValidateHeader(header, parent, time.Now()); err != nil {
+		return err
+	}
+	// an oversized block is rejected before its signature costs anything
+	if err := node.validateBlockSize(block); err != nil {
+		return err
+	}
+	producerKey := node.producerKey(header.Producer)
+	if producerKey == nil {
+		return fmt.Errorf("%s isn't a producer", header.Producer)
+	}
+	if err := block.SignedHeader.Verify(*producerKey); err != nil {
+		return err
+	}
+	if err := block.Validate(); err != nil {
+		return err
+	}
+	if err := node.validateNonces(block); err != nil {
+		return err
+	}
+	if err := node.checkAppHash(header); err != nil {
+		return err
+	}
+	if err := node.forkTree.AddBlock(block); err != nil {
+		return err
+	}
+	// the quorum may have committed the block before it arrived
+	node.commitManager.Retry()
+	return nil
+}
+
+func (node *Node) getBlock(id blockchain.SHA256Type) (*blockchain.SignedBlock, error) {
+	if block, err := node.forkTree.GetBlock(id); err == nil {
+		return block, nil
+	}
+	return node.blockLog.ReadBlockById(id)
+}
+
+// checkProducerKey makes sure a producer signs with the key the genesis has for its address,
+// with another key it would produce blocks that every node rejects
+func checkProducerKey(producers []consensus.Producer, address string, privateKey *crypto.PrivateKey) error {
+	if address == "" {
+		return nil
+	}
+	for _, p := range producers {
+		if p.Address != address {
+			continue
+		}
+		if !bytes.Equal(p.PublicKey.Data, privateKey.PublicKey().Data) {
+			return fmt.Errorf("private key doesn't match the genesis key of producer %s", address)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s isn't a producer of the genesis", address)
+}
+
+func (node *Node) producerKey(address string) *crypto.PublicKey {
+	for _, p := range node.producers {
+		if p.Address == address {
+			return p.PublicKey
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package node
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"consensus_layer/blockchain"
+	"consensus_layer/consensus"
+	"consensus_layer/crypto"
+)
+
+func TestAcceptBlockLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blocklimits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blockLog, err := blockchain.OpenBlockLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blockLog.Close()
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	producers := []consensus.Producer{{Address: "producer1", PublicKey: privateKey.PublicKey()}}
+	genesisTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	node := &Node{
+		genesis: 		&blockchain.Genesis{Consensus: blockchain.ConsensusParams{MaxBlockTransactions: 2, MaxBlockBytes: 1024}},
+		producers: 		producers,
+		scheduler: 		consensus.NewScheduler(genesisTime, time.Second, producers),
+		blockLog: 		blockLog,
+		forkTree: 		blockchain.NewForkTree(blockchain.SHA256Type{}, 0, blockchain.LongestChain, nil),
+		appHashes: 		newAppHashes(),
+		commitManager: 	consensus.NewCommitManager(nil, "", nil, nil),
+	}
+	newBlock := func(payloads ...[]byte) *blockchain.SignedBlock {
+		block := &blockchain.SignedBlock{}
+		for i, payload := range payloads {
+			tx := blockchain.Transaction{Payload: payload, Nonce: uint64(i + 1)}
+			if err := tx.Sign(privateKey); err != nil {
+				t.Fatal(err)
+			}
+			block.Transactions = append(block.Transactions, tx)
+		}
+		block.Header = blockchain.BlockHeader{
+			Height: 			1,
+			Producer: 			"producer1",
+			Timestamp: 			genesisTime.Add(time.Second).UTC(),
+			TransactionRoot: 	block.CalculateTransactionRoot(),
+		}
+		signed, err := blockchain.SignHeader(block.Header, privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.SignedHeader = signed
+		return block
+	}
+	if err := node.acceptBlock(newBlock([]byte("a"), []byte("b"), []byte("c"))); err == nil {
+		t.Fatal("block with more transactions than the genesis allows should be rejected")
+	}
+	if err := node.acceptBlock(newBlock(make([]byte, 2048))); err == nil {
+		t.Fatal("block larger than the genesis allows should be rejected")
+	}
+	// the size is checked before the signature
+	unsigned := newBlock([]byte("a"), []byte("b"), []byte("c"))
+	unsigned.SignedHeader.Signature = crypto.Signature{}
+	if err := node.acceptBlock(unsigned); err == nil || !strings.Contains(err.Error(), "transactions") {
+		t.Fatal("oversized block should be rejected for its size, got ", err)
+	}
+	if err := node.acceptBlock(newBlock([]byte("a"), []byte("b"))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcceptBlockNonces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blocknonces")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blockLog, err := blockchain.OpenBlockLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blockLog.Close()
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	sender, _ := crypto.NewRandomPrivateKey()
+	producers := []consensus.Producer{{Address: "producer1", PublicKey: privateKey.PublicKey()}}
+	genesisTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	node := &Node{
+		genesis: 		&blockchain.Genesis{},
+		producers: 		producers,
+		scheduler: 		consensus.NewScheduler(genesisTime, time.Second, producers),
+		blockLog: 		blockLog,
+		forkTree: 		blockchain.NewForkTree(blockchain.SHA256Type{}, 0, blockchain.LongestChain, nil),
+		appHashes: 		newAppHashes(),
+		commitManager: 	consensus.NewCommitManager(nil, "", nil, nil),
+	}
+	newBlock := func(height uint64, previousId blockchain.SHA256Type, nonces ...uint64) *blockchain.SignedBlock {
+		block := &blockchain.SignedBlock{}
+		for _, nonce := range nonces {
+			tx := blockchain.Transaction{Payload: []byte("payload"), Nonce: nonce}
+			if err := tx.Sign(sender); err != nil {
+				t.Fatal(err)
+			}
+			block.Transactions = append(block.Transactions, tx)
+		}
+		block.Header = blockchain.BlockHeader{
+			Height: 			height,
+			PreviousId: 		previousId,
+			Producer: 			"producer1",
+			Timestamp: 			genesisTime.Add(time.Duration(height) * time.Second).UTC(),
+			TransactionRoot: 	block.CalculateTransactionRoot(),
+		}
+		signed, err := blockchain.SignHeader(block.Header, privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.SignedHeader = signed
+		return block
+	}
+	if err := node.acceptBlock(newBlock(1, blockchain.SHA256Type{}, 1, 3)); err == nil {
+		t.Fatal("block with a gap in the nonces of a sender should be rejected")
+	}
+	if err := node.acceptBlock(newBlock(1, blockchain.SHA256Type{}, 1, 1)); err == nil {
+		t.Fatal("block with a repeated nonce should be rejected")
+	}
+	block1 := newBlock(1, blockchain.SHA256Type{}, 1, 2)
+	if err := node.acceptBlock(block1); err != nil {
+		t.Fatal(err)
+	}
+	// the nonces of the parent branch are used
+	if err := node.acceptBlock(newBlock(2, block1.Header.Id, 2)); err == nil {
+		t.Fatal("nonce of the parent branch shouldn't be included again")
+	}
+	if err := node.acceptBlock(newBlock(2, block1.Header.Id, 3)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckProducerKey(t *testing.T) {
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	otherKey, _ := crypto.NewRandomPrivateKey()
+	producers := []consensus.Producer{{Address: "producer1", PublicKey: privateKey.PublicKey()}}
+	if err := checkProducerKey(producers, "producer1", privateKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkProducerKey(producers, "producer1", otherKey); err == nil {
+		t.Fatal("key that isn't the genesis key of the producer should be rejected")
+	}
+	if err := checkProducerKey(producers, "producer2", privateKey); err == nil {
+		t.Fatal("address that isn't a producer should be rejected")
+	}
+	if err := checkProducerKey(producers, "", otherKey); err != nil {
+		t.Fatal("a node that doesn't produce can have any key")
+	}
+}
@@ -0,0 +1,151 @@
+package node
+
+import (
+	"consensus_layer/network"
+	"fmt"
+	"sync"
+)
+
+// the messages of the peer to peer protocol that the node handles itself
+var nodeMessageTypes = []network.MessageType{
+	network.Handshake,
+	network.Block,
+	network.GetAddresses,
+	network.Addresses,
+	network.Ping,
+	network.Pong,
+	network.RPCRequest,
+	network.RPCResponse,
+}
+
+// router dispatches the messages to the managers that declared their types
+type router struct {
+	ids 		[]string // registration order, the managers start in this order and stop in reverse
+	managers 	map[string]network.BaseManager
+	routes 		map[network.MessageType]string
+	mutex 		sync.RWMutex
+}
+
+func newRouter() *router {
+	r := &router{
+		ids: 		make([]string, 0),
+		managers: 	make(map[string]network.BaseManager, 0),
+		routes: 	make(map[network.MessageType]string, 0),
+	}
+	for _, messageType := range nodeMessageTypes {
+		r.routes[messageType] = ""
+	}
+	return r
+}
+
+// register adds the routes of the manager, nothing is registered if one of its types is taken
+func (r *router) register(id string, manager network.BaseManager) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.managers[id]; ok {
+		return fmt.Errorf("manager %s is already registered", id)
+	}
+	types := manager.MessageTypes()
+	for i, messageType := range types {
+		if owner, ok := r.routes[messageType]; ok {
+			if owner == "" {
+				return fmt.Errorf("message %d of manager %s is handled by the node", messageType, id)
+			}
+			return fmt.Errorf("message %d of manager %s is already routed to manager %s", messageType, id, owner)
+		}
+		for _, other := range types[:i] {
+			if other == messageType {
+				return fmt.Errorf("message %d is declared twice by manager %s", messageType, id)
+			}
+		}
+	}
+	for _, messageType := range types {
+		r.routes[messageType] = id
+	}
+	r.ids = append(r.ids, id)
+	r.managers[id] = manager
+	return nil
+}
+
+// route returns the manager of the message type, false if the type isn't routed to a manager
+func (r *router) route(messageType network.MessageType) (network.BaseManager, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	manager, ok := r.managers[r.routes[messageType]]
+	return manager, ok
+}
+
+// all returns the managers in the order of their registration
+func (r *router) all() []network.BaseManager {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	managers := make([]network.BaseManager, 0, len(r.ids))
+	for _, id := range r.ids {
+		managers = append(managers, r.managers[id])
+	}
+	return managers
+}
+
+// AddManager routes the message types of the manager to it, it must be called before Start
+func (node *Node) AddManager(manager network.BaseManager, id string) error {
+	node.mutex.Lock()
+	started := node.started
+	node.mutex.Unlock()
+	if started {
+		return fmt.Errorf("can not add manager %s after the node started", id)
+	}
+	return node.router.register(id, manager)
+}
+
+// HandleRPC answers the requests of the peers whose payload is of messageType with handler
+func (node *Node) HandleRPC(messageType network.MessageType, handler network.RPCHandler) error {
+	return node.rpc.Handle(messageType, handler)
+}
+
+// dispatch hands the message to its manager, gossiped messages are deduplicated and relayed
+// once the manager accepted them. It returns false if no manager handles the type.
+func (node *Node) dispatch(c *network.Connection, message network.Message) bool {
+	manager, ok := node.router.route(message.Header.Type)
+	if !ok {
+		return false
+	}
+	if !gossipTypes[message.Header.Type] {
+		manager.Receive(c, message)
+		return true
+	}
+	if node.receiveGossip(c, message) {
+		// rejected messages aren't relayed, honest peers would be penalized for them
+		err := manager.Receive(c, message)
+		if err == nil {
+			node.relay(c, message)
+			return true
+		}
+		_, misbehavior := err.(*network.MisbehaviorError)
+		node.rejectGossip(message, misbehavior)
+	}
+	return true
+}
+
+// peerConnected tells the managers about a new session
+func (node *Node) peerConnected(c *network.Connection) {
+	node.mutex.Lock()
+	node.sessions[c] = true
+	node.mutex.Unlock()
+	for _, manager := range node.router.all() {
+		manager.PeerConnected(c)
+	}
+}
+
+// peerDisconnected tells the managers that a session they were told about is gone
+func (node *Node) peerDisconnected(c *network.Connection) {
+	node.mutex.Lock()
+	connected := node.sessions[c]
+	delete(node.sessions, c)
+	node.mutex.Unlock()
+	if !connected {
+		return
+	}
+	for _, manager := range node.router.all() {
+		manager.PeerDisconnected(c)
+	}
+}
@@ -0,0 +1,106 @@
+package node
+
+import (
+	"testing"
+	"consensus_layer/network"
+)
+
+type testManager struct {
+	name 		string
+	types 		[]network.MessageType
+	events 		*[]string
+	received 	[]network.MessageType
+	reject 		error // returned by Receive
+}
+
+func (m *testManager) MessageTypes() []network.MessageType {
+	return m.types
+}
+
+func (m *testManager) Start() {
+	*m.events = append(*m.events, "start " + m.name)
+}
+
+func (m *testManager) Stop() {
+	*m.events = append(*m.events, "stop " + m.name)
+}
+
+func (m *testManager) PeerConnected(conn *network.Connection) {
+	*m.events = append(*m.events, "connected " + m.name)
+}
+
+func (m *testManager) PeerDisconnected(conn *network.Connection) {
+	*m.events = append(*m.events, "disconnected " + m.name)
+}
+
+func (m *testManager) Send(conn *network.Connection, messageType network.MessageType) {}
+
+func (m *testManager) Receive(conn *network.Connection, message network.Message) error {
+	m.received = append(m.received, message.Header.Type)
+	return m.reject
+}
+
+func TestRouter(t *testing.T) {
+	events := make([]string, 0)
+	first := &testManager{name: "first", types: []network.MessageType{network.Notice, network.Request}, events: &events}
+	second := &testManager{name: "second", types: []network.MessageType{network.Headers}, events: &events}
+	node := &Node{
+		router: 	newRouter(),
+		sessions: 	make(map[*network.Connection]bool, 0),
+		quit: 		make(chan struct{}),
+	}
+	if err := node.AddManager(first, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.AddManager(second, "second"); err != nil {
+		t.Fatal(err)
+	}
+	overlapping := &testManager{types: []network.MessageType{network.Commit, network.Request}, events: &events}
+	if err := node.AddManager(overlapping, "overlapping"); err == nil {
+		t.Fatal("manager declaring a routed type should be rejected")
+	}
+	if _, ok := node.router.route(network.Commit); ok {
+		t.Fatal("rejected manager shouldn't keep any route")
+	}
+	if err := node.AddManager(&testManager{types: []network.MessageType{network.Ping}}, "ping"); err == nil {
+		t.Fatal("manager declaring a type of the node should be rejected")
+	}
+	if err := node.AddManager(&testManager{}, "first"); err == nil {
+		t.Fatal("duplicated manager id should be rejected")
+	}
+
+	c := &network.Connection{}
+	for _, messageType := range []network.MessageType{network.Request, network.Headers, network.Notice} {
+		if !node.dispatch(c, network.Message{Header: network.MessageHeader{Type: messageType}}) {
+			t.Fatal("declared message should be dispatched")
+		}
+	}
+	if node.dispatch(c, network.Message{Header: network.MessageHeader{Type: network.Ping}}) {
+		t.Fatal("message of the node shouldn't be dispatched to a manager")
+	}
+	if len(first.received) != 2 || first.received[0] != network.Request || len(second.received) != 1 {
+		t.Fatal("messages should reach the manager that declared them")
+	}
+
+	for _, manager := range node.router.all() {
+		manager.Start()
+	}
+	node.peerConnected(c)
+	node.peerDisconnected(c)
+	node.peerDisconnected(c)
+	node.Stop()
+	expected := []string{"start first", "start second", "connected first", "connected second",
+		"disconnected first", "disconnected second", "stop second", "stop first"}
+	if len(events) != len(expected) {
+		t.Fatal("unexpected lifecycle ", events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Fatal("unexpected lifecycle ", events)
+		}
+	}
+	node.started = true
+	if err := node.AddManager(&testManager{}, "late"); err == nil {
+		t.Fatal("manager added after start should be rejected")
+	}
+}
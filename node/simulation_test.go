@@ -0,0 +1,183 @@
+package node
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"consensus_layer/consensus"
+	"consensus_layer/crypto"
+	"consensus_layer/network"
+)
+
+// newSimNodes starts n producers connected to each other over the simulated network,
+// their genesis and data are in the returned directory
+func newSimNodes(t *testing.T, sim *network.SimNetwork, n int) ([]*Node, string) {
+	return newSimNodesWith(t, sim, n, nil)
+}
+
+// newSimNodesWith lets configure change the config of every node before it starts,
+// by default each node dials the nodes started before it
+func newSimNodesWith(t *testing.T, sim *network.SimNetwork, n int, configure func(i int, config *Config)) ([]*Node, string) {
+	dir, err := ioutil.TempDir("", "simulation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]*crypto.PrivateKey, n)
+	addresses := make([]string, n)
+	producers := ""
+	for i := range keys {
+		keys[i], _ = crypto.NewRandomPrivateKey()
+		addresses[i] = fmt.Sprintf("10.0.0.%d:9000", i + 1)
+		if i > 0 {
+			producers += ","
+		}
+		producers += fmt.Sprintf(`{"address": "producer%d", "public_key": "%s"}`, i + 1, keys[i].PublicKey().String())
+	}
+	genesis := fmt.Sprintf(`{
+"chain_name": "simnet",
+"timestamp": "%s",
+"producers": [%s],
+"consensus": {"block_interval_ms": 200}
+}`, time.Now().UTC().Truncate(time.Second).Format(time.RFC3339), producers)
+	genesisFile := filepath.Join(dir, "genesis.json")
+	if err := ioutil.WriteFile(genesisFile, []byte(genesis), 0644); err != nil {
+		t.Fatal(err)
+	}
+	nodes := make([]*Node, n)
+	for i := range nodes {
+		dataDir := filepath.Join(dir, fmt.Sprintf("node%d", i + 1))
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		config := Config{
+			P2PAddress: 		addresses[i],
+			Targets: 			addresses[:i],
+			DataDir: 			dataDir,
+			GenesisFile: 		genesisFile,
+			ProducerAddress: 	fmt.Sprintf("producer%d", i + 1),
+			PrivateKey: 		keys[i].String(),
+			Transport: 			sim.Transport(addresses[i]),
+		}
+		if configure != nil {
+			configure(i, &config)
+		}
+		node, err := NewNode(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node.Start()
+		nodes[i] = node
+	}
+	return nodes, dir
+}
+
+func stopNodes(nodes []*Node) {
+	for _, node := range nodes {
+		node.Stop()
+	}
+}
+
+// waitIrreversible waits until every node finalized the height
+func waitIrreversible(t *testing.T, nodes []*Node, height uint64, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for _, node := range nodes {
+		for node.blockLog.TopBlockHeight() < height {
+			if time.Now().After(deadline) {
+				t.Fatalf("node %s finalized %d blocks, expected %d", node.p2pAddress, node.blockLog.TopBlockHeight(), height)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
+
+func TestSimulatedConsensus(t *testing.T) {
+	sim := network.NewSimNetwork(network.SimConfig{
+		Seed: 			1,
+		Latency: 		5 * time.Millisecond,
+		Jitter: 		5 * time.Millisecond,
+		ReorderRate: 	0.05,
+		ReorderDelay: 	20 * time.Millisecond,
+	})
+	nodes, dir := newSimNodes(t, sim, 3)
+	defer os.RemoveAll(dir)
+	defer stopNodes(nodes)
+	waitIrreversible(t, nodes, 3, 20 * time.Second)
+	for _, node := range nodes {
+		block, err := node.blockLog.ReadBlockByHeight(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, _ := nodes[0].blockLog.ReadBlockByHeight(3)
+		if block.Header.Id != expected.Header.Id {
+			t.Fatal("nodes finalized different blocks")
+		}
+	}
+}
+
+func TestSimulatedPartition(t *testing.T) {
+	sim := network.NewSimNetwork(network.SimConfig{Seed: 2, Latency: 5 * time.Millisecond})
+	nodes, dir := newSimNodes(t, sim, 3)
+	defer os.RemoveAll(dir)
+	defer stopNodes(nodes)
+	waitIrreversible(t, nodes, 1, 20 * time.Second)
+	// a minority can't finalize blocks on its own
+	sim.Partition([]string{nodes[0].p2pAddress, nodes[1].p2pAddress}, []string{nodes[2].p2pAddress})
+	time.Sleep(time.Second)
+	isolated := nodes[2].blockLog.TopBlockHeight()
+	time.Sleep(time.Second)
+	if nodes[2].blockLog.TopBlockHeight() != isolated {
+		t.Fatal("isolated node shouldn't finalize blocks")
+	}
+	sim.Heal()
+	waitIrreversible(t, nodes, nodes[0].blockLog.TopBlockHeight() + 2, 20 * time.Second)
+}
+
+// waitPeers waits until every node established a session with every other node
+func waitPeers(t *testing.T, nodes []*Node, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for _, node := range nodes {
+		for len(node.establishedConnections()) < len(nodes) - 1 {
+			if time.Now().After(deadline) {
+				t.Fatalf("node %s established %d sessions, expected %d", node.p2pAddress, len(node.establishedConnections()), len(nodes) - 1)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
+
+func TestSimulatedElection(t *testing.T) {
+	sim := network.NewSimNetwork(network.SimConfig{
+		Seed: 			3,
+		Latency: 		5 * time.Millisecond,
+		Jitter: 		5 * time.Millisecond,
+		ReorderRate: 	0.1,
+		ReorderDelay: 	20 * time.Millisecond,
+	})
+	nodes, dir := newSimNodes(t, sim, 4)
+	defer os.RemoveAll(dir)
+	defer stopNodes(nodes)
+	waitPeers(t, nodes, 20 * time.Second)
+	for _, node := range nodes {
+		node.election.StartNewTerm()
+	}
+	// the producer of the next term collects the requests of the others and asks for their votes
+	candidate := nodes[1]
+	deadline := time.Now().Add(20 * time.Second)
+	for candidate.election.Role() != consensus.Leader {
+		if time.Now().After(deadline) {
+			t.Fatalf("producer of term 1 should be elected, its role is %d", candidate.election.Role())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if candidate.election.Term() != 1 {
+		t.Fatal("leader should be elected for term 1, got ", candidate.election.Term())
+	}
+	for i, node := range nodes {
+		if i != 1 && node.election.Role() != consensus.Follower {
+			t.Fatalf("producer %d should follow the leader, its role is %d", i + 1, node.election.Role())
+		}
+	}
+}
@@ -0,0 +1,601 @@
+package node
+
+import (
+	"consensus_layer/blockchain"
+	"consensus_layer/consensus"
+	"consensus_layer/network"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const MaxSyncHeaders = 200 // headers per request
+const MaxSyncBlocks = 20 // blocks per request
+const syncRequestTimeout = 10 * time.Second
+const syncCheckInterval = time.Second
+const syncPeerCooldown = time.Minute // a peer that didn't serve its announced head isn't followed again before it
+const MaxSyncStall = 30 * time.Second // the production only waits for a sync that made progress within it
+
+type peerHead struct {
+	topHeight uint64
+}
+
+type blockRequest struct {
+	conn 	*network.Connection
+	sentAt 	time.Time
+}
+
+// syncManager downloads the blocks this node is missing. The headers are followed first
+// on the peer with the highest head, then the bodies are fetched in batches from every peer
+// that has them and applied in order on top of the last irreversible block. The blocks are
+// only finalized with the commits of a quorum the header peer serves with the headers.
+type syncManager struct {
+	node 				*Node
+	heads 				map[*network.Connection]peerHead
+	failed 				map[*network.Connection]time.Time // peers ignored until the time, they didn't serve what they announced
+	synchronizing 		bool
+	progressAt 			time.Time // last time headers or blocks arrived
+	headerPeer 			*network.Connection // the peer whose headers are followed
+	headerRequested 	bool
+	headerRequestedAt 	time.Time
+	targetHeight 		uint64 // top height of the header peer
+	commitHeight 		uint64 // a quorum committed a block at this height
+	lastHeader 			*blockchain.BlockHeader // last validated header, nil at genesis
+	lastHeight 			uint64
+	lastId 				blockchain.SHA256Type
+	headers 			[]blockchain.SignedHeader // validated headers whose block isn't applied yet, ascending
+	requested 			map[blockchain.SHA256Type]blockRequest
+	received 			map[blockchain.SHA256Type]*blockchain.SignedBlock
+	quit 				chan struct{}
+	mutex 				sync.Mutex
+	applyMutex 			sync.Mutex // blocks are taken and applied by one goroutine at a time, in order
+}
+
+func newSyncManager(node *Node) *syncManager {
+	return &syncManager{
+		node: 		node,
+		quit: 		make(chan struct{}),
+		heads: 		make(map[*network.Connection]peerHead, 0),
+		failed: 	make(map[*network.Connection]time.Time, 0),
+		requested: 	make(map[blockchain.SHA256Type]blockRequest, 0),
+		received: 	make(map[blockchain.SHA256Type]*blockchain.SignedBlock, 0),
+	}
+}
+
+// sync manager inherit base manager interface
+var _ network.BaseManager = (*syncManager)(nil)
+
+func (sm *syncManager) MessageTypes() []network.MessageType {
+	return []network.MessageType{network.Notice, network.Request, network.Headers}
+}
+
+func (sm *syncManager) Start() {
+	go sm.syncLoop()
+}
+
+func (sm *syncManager) Stop() {
+	close(sm.quit)
+}
+
+func (sm *syncManager) Receive(conn *network.Connection, message network.Message) error {
+	payload, err := network.DecodeMessage(message)
+	if err != nil {
+		fmt.Println(err)
+		err = network.Misbehavior(network.MalformedMessage, "%s", err)
+		conn.Misbehave(err)
+		return err
+	}
+	switch packet := payload.(type) {
+	case network.NoticePacket:
+		sm.updateHead(conn, uint64(packet.TopBlockHeight))
+		sm.check(time.Now())
+	case network.RequestPacket:
+		sm.serve(conn, packet)
+	case network.HeadersPacket:
+		sm.receivedHeaders(conn, packet, time.Now())
+		// the block of the commits may already be in the fork tree
+		sm.node.commitManager.Retry()
+	default:
+		break
+	}
+	return nil
+}
+
+func (sm *syncManager) Send(conn *network.Connection, messageType network.MessageType) {
+	switch messageType {
+	case network.Notice:
+		if err := conn.Send(sm.node.notice()); err != nil {
+			fmt.Println(err)
+		}
+	default:
+		break
+	}
+}
+
+func (sm *syncManager) IsSynchronizing() bool {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	return sm.synchronizing
+}
+
+// blocksProduction reports whether the producer should wait for the sync. A sync that made
+// no progress for MaxSyncStall doesn't hold the production back, so a peer can't stall it.
+func (sm *syncManager) blocksProduction(now time.Time) bool {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	return sm.synchronizing && now.Sub(sm.progressAt) < MaxSyncStall
+}
+
+// unserved penalizes the peer for data it announced but didn't serve and ignores it for a while,
+// so repeating the announcement doesn't restart the sync right away
+func (sm *syncManager) unserved(conn *network.Connection, now time.Time, format string, args ...interface{}) {
+	err := network.Misbehavior(network.UnservedRequest, format, args...)
+	fmt.Println("peer ", conn.RemoteAddress(), " is ignored by the sync: ", err)
+	conn.Misbehave(err)
+	sm.failed[conn] = now.Add(syncPeerCooldown)
+}
+
+// isIgnored reports whether the peer failed to serve the sync recently
+func (sm *syncManager) isIgnored(conn *network.Connection, now time.Time) bool {
+	until, ok := sm.failed[conn]
+	return ok && now.Before(until)
+}
+
+// isBehind reports whether a quorum already committed the block at height or a descendant of it
+func (sm *syncManager) isBehind(height uint64) bool {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	return sm.synchronizing && height <= sm.commitHeight
+}
+
+func (sm *syncManager) updateHead(conn *network.Connection, topHeight uint64) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.heads[conn] = peerHead{topHeight: topHeight}
+	if conn == sm.headerPeer && topHeight > sm.targetHeight {
+		sm.targetHeight = topHeight
+	}
+}
+
+// PeerConnected starts tracking the head the peer announced in its handshake
+func (sm *syncManager) PeerConnected(conn *network.Connection) {
+	if !conn.Supports(network.CapabilityHeaderSync) {
+		return
+	}
+	info := conn.PeerInfo()
+	sm.updateHead(conn, uint64(info.TopBlockHeight))
+	sm.check(time.Now())
+}
+
+// PeerDisconnected forgets the peer, its outstanding requests are sent to other peers
+func (sm *syncManager) PeerDisconnected(conn *network.Connection) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	delete(sm.heads, conn)
+	delete(sm.failed, conn)
+	if !sm.synchronizing {
+		return
+	}
+	if conn == sm.headerPeer {
+		sm.abort()
+		return
+	}
+	for id, r := range sm.requested {
+		if r.conn == conn {
+			delete(sm.requested, id)
+		}
+	}
+}
+
+// syncLoop retries timed out requests and starts a new sync when a peer is ahead
+func (sm *syncManager) syncLoop() {
+	ticker := time.NewTicker(syncCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sm.check(time.Now())
+		case <-sm.quit:
+			return
+		}
+	}
+}
+
+func (sm *syncManager) check(now time.Time) {
+	sm.applyMutex.Lock()
+	defer sm.applyMutex.Unlock()
+	sm.mutex.Lock()
+	if !sm.synchronizing && !sm.start(now) {
+		sm.mutex.Unlock()
+		return
+	}
+	if sm.headerRequested && now.Sub(sm.headerRequestedAt) > syncRequestTimeout {
+		sm.unserved(sm.headerPeer, now, "headers after height %d timed out", sm.lastHeight)
+		sm.abort()
+		sm.mutex.Unlock()
+		return
+	}
+	for id, r := range sm.requested {
+		if now.Sub(r.sentAt) > syncRequestTimeout {
+			if !sm.isIgnored(r.conn, now) {
+				sm.unserved(r.conn, now, "block %x timed out", id[:4])
+			}
+			delete(sm.requested, id)
+		}
+	}
+	sm.requestBlocks(now)
+	sm.requestHeaders(now)
+	ready := sm.takeReady()
+	sm.mutex.Unlock()
+	sm.apply(ready)
+}
+
+// start follows the peer with the highest head if it's ahead of this node
+func (sm *syncManager) start(now time.Time) bool {
+	headHeight := sm.node.forkTree.HeadHeight()
+	var best *network.Connection
+	for conn, until := range sm.failed {
+		if !now.Before(until) {
+			delete(sm.failed, conn)
+		}
+	}
+	for conn, head := range sm.heads {
+		if sm.isIgnored(conn, now) {
+			continue
+		}
+		if head.topHeight > headHeight && (best == nil || head.topHeight > sm.heads[best].topHeight) {
+			best = conn
+		}
+	}
+	if best == nil {
+		return false
+	}
+	sm.synchronizing = true
+	sm.progressAt = now
+	sm.headerPeer = best
+	sm.targetHeight = sm.heads[best].topHeight
+	sm.commitHeight = sm.node.blockLog.TopBlockHeight()
+	// blocks above the last irreversible block may be on another branch, so headers are followed from there
+	sm.lastHeight = sm.node.blockLog.TopBlockHeight()
+	sm.lastId = sm.node.blockLog.TopBlockId()
+	sm.lastHeader = nil
+	if block, err := sm.node.blockLog.ReadBlockById(sm.lastId); err == nil {
+		sm.lastHeader = &block.Header
+	}
+	fmt.Println("synchronizing from height ", sm.lastHeight, " to ", sm.targetHeight, " with ", best.RemoteAddress())
+	return true
+}
+
+func (sm *syncManager) abort() {
+	if sm.headerPeer != nil {
+		sm.headerPeer.SetSynchronizing(false)
+	}
+	sm.synchronizing = false
+	sm.headerPeer = nil
+	sm.headerRequested = false
+	sm.headers = nil
+	sm.requested = make(map[blockchain.SHA256Type]blockRequest, 0)
+	sm.received = make(map[blockchain.SHA256Type]*blockchain.SignedBlock, 0)
+	sm.updateAvailability()
+}
+
+func (sm *syncManager) finish() {
+	fmt.Println("synchronized to height ", sm.lastHeight)
+	sm.abort()
+}
+
+func (sm *syncManager) requestHeaders(now time.Time) {
+	if sm.headerRequested || sm.lastHeight >= sm.targetHeight || len(sm.headers) >= MaxSyncHeaders {
+		return
+	}
+	count := sm.targetHeight - sm.lastHeight
+	if count > MaxSyncHeaders {
+		count = MaxSyncHeaders
+	}
+	request := network.RequestPacket{
+		Type: 			network.HeadersRequest,
+		StartHeight: 	uint32(sm.lastHeight + 1),
+		Count: 			uint32(count),
+	}
+	if err := sm.headerPeer.Send(request); err != nil {
+		fmt.Println(err)
+		return
+	}
+	sm.headerRequested = true
+	sm.headerRequestedAt = now
+	sm.updateAvailability()
+}
+
+// requestBlocks hands out the missing bodies in batches to the available peers that have them
+func (sm *syncManager) requestBlocks(now time.Time) {
+	batches := make(map[*network.Connection][]blockchain.SHA256Type, 0)
+	for _, header := range sm.headers {
+		id := header.Header.Id
+		if _, ok := sm.requested[id]; ok {
+			continue
+		}
+		if _, ok := sm.received[id]; ok || sm.node.hasBlock(id) {
+			continue
+		}
+		conn := sm.pickPeer(header.Header.Height, batches, now)
+		if conn == nil {
+			break
+		}
+		batches[conn] = append(batches[conn], id)
+	}
+	for conn, ids := range batches {
+		request := network.RequestPacket{
+			Type: 	network.BlocksRequest,
+			Ids: 	ids,
+		}
+		if err := conn.Send(request); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		for _, id := range ids {
+			sm.requested[id] = blockRequest{conn: conn, sentAt: now}
+		}
+	}
+	sm.updateAvailability()
+}
+
+// pickPeer returns the available peer with the fewest assigned blocks that has the height
+func (sm *syncManager) pickPeer(height uint64, batches map[*network.Connection][]blockchain.SHA256Type, now time.Time) *network.Connection {
+	var best *network.Connection
+	for conn, head := range sm.heads {
+		if head.topHeight < height || !conn.IsAvailable() || len(batches[conn]) >= MaxSyncBlocks || sm.isIgnored(conn, now) {
+			continue
+		}
+		if best == nil || len(batches[conn]) < len(batches[best]) {
+			best = conn
+		}
+	}
+	return best
+}
+
+// updateAvailability marks the peers that have outstanding requests as synchronizing
+func (sm *syncManager) updateAvailability() {
+	busy := make(map[*network.Connection]bool, 0)
+	if sm.headerRequested {
+		busy[sm.headerPeer] = true
+	}
+	for _, r := range sm.requested {
+		busy[r.conn] = true
+	}
+	for conn := range sm.heads {
+		conn.SetSynchronizing(busy[conn])
+	}
+}
+
+func (sm *syncManager) receivedHeaders(conn *network.Connection, packet network.HeadersPacket, now time.Time) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if !sm.synchronizing || conn != sm.headerPeer || !sm.headerRequested {
+		conn.Misbehave(network.Misbehavior(network.UnsolicitedMessage, "headers"))
+		return
+	}
+	sm.headerRequested = false
+	if len(packet.Commits) > 0 {
+		_, height, err := sm.node.commitManager.VerifyEvidence(packet.Commits)
+		if err != nil {
+			fmt.Println("commits from ", conn.RemoteAddress(), " are rejected: ", err)
+			conn.Misbehave(err)
+			delete(sm.heads, conn)
+			sm.abort()
+			return
+		}
+		if height > sm.commitHeight {
+			sm.commitHeight = height
+		}
+	}
+	if len(packet.Headers) > MaxSyncHeaders {
+		conn.Misbehave(network.Misbehavior(network.OversizedMessage, "%d headers", len(packet.Headers)))
+		delete(sm.heads, conn)
+		sm.abort()
+		return
+	}
+	if len(packet.Headers) == 0 {
+		// the peer can't serve the head it announced, the headers it served are still applied
+		sm.unserved(conn, now, "announced height %d but has no header after %d", sm.targetHeight, sm.lastHeight)
+		sm.targetHeight = sm.lastHeight
+	} else {
+		sm.progressAt = now
+	}
+	for _, header := range packet.Headers {
+		if err := sm.validateHeader(header, now); err != nil {
+			fmt.Println("header ", header.Header.Height, " from ", conn.RemoteAddress(), " is rejected: ", err)
+			if _, early := err.(*consensus.EarlyBlockError); !early {
+				conn.Misbehave(network.Misbehavior(network.InvalidBlock, "header %d: %s", header.Header.Height, err))
+			}
+			delete(sm.heads, conn)
+			sm.abort()
+			return
+		}
+		h := header.Header
+		sm.headers = append(sm.headers, header)
+		sm.lastHeader = &h
+		sm.lastHeight = h.Height
+		sm.lastId = h.Id
+	}
+	sm.requestBlocks(now)
+	sm.requestHeaders(now)
+}
+
+// validateHeader checks that the header extends the last header and is signed in the slot of its producer
+func (sm *syncManager) validateHeader(header blockchain.SignedHeader, now time.Time) error {
+	h := header.Header
+	if h.Height != sm.lastHeight + 1 || h.PreviousId != sm.lastId {
+		return fmt.Errorf("header doesn't extend height %d", sm.lastHeight)
+	}
+	id, err := h.CalculateId()
+	if err != nil {
+		return err
+	}
+	if id != h.Id {
+		return fmt.Errorf("block id doesn't match the header")
+	}
+	if err := sm.node.scheduler.ValidateHeader(h, sm.lastHeader, now); err != nil {
+		return err
+	}
+	producerKey := sm.node.producerKey(h.Producer)
+	if producerKey == nil {
+		return fmt.Errorf("%s isn't a producer", h.Producer)
+	}
+	return header.Verify(*producerKey)
+}
+
+// receivedBlock takes the block if it was requested by the sync, other blocks are left to the node
+func (sm *syncManager) receivedBlock(conn *network.Connection, block *blockchain.SignedBlock) bool {
+	sm.applyMutex.Lock()
+	defer sm.applyMutex.Unlock()
+	sm.mutex.Lock()
+	if _, ok := sm.requested[block.Header.Id]; !ok {
+		sm.mutex.Unlock()
+		return false
+	}
+	delete(sm.requested, block.Header.Id)
+	now := time.Now()
+	if err := sm.validateBody(block); err != nil {
+		sm.rejectBody(conn, block, err, now)
+		sm.mutex.Unlock()
+		return true
+	}
+	sm.received[block.Header.Id] = block
+	sm.progressAt = now
+	sm.requestBlocks(now)
+	ready := sm.takeReady()
+	sm.mutex.Unlock()
+	sm.apply(ready)
+	return true
+}
+
+// validateBody checks that the block is the one of its queued header, so a peer serving another
+// body is caught when it arrives instead of when the block is applied
+func (sm *syncManager) validateBody(block *blockchain.SignedBlock) error {
+	for _, header := range sm.headers {
+		if header.Header.Id != block.Header.Id {
+			continue
+		}
+		if block.Header.TransactionRoot != header.Header.TransactionRoot {
+			return fmt.Errorf("transaction root doesn't match the header")
+		}
+		return block.Validate()
+	}
+	return fmt.Errorf("block isn't in the synchronized headers")
+}
+
+// rejectBody penalizes the peer for the invalid block and stops requesting from it,
+// its outstanding blocks are requested from the other peers
+func (sm *syncManager) rejectBody(conn *network.Connection, block *blockchain.SignedBlock, err error, now time.Time) {
+	fmt.Println("synchronized block ", block.Header.Height, " from ", conn.RemoteAddress(), " is rejected: ", err)
+	conn.Misbehave(network.Misbehavior(network.InvalidBlock, "block %d: %s", block.Header.Height, err))
+	sm.failed[conn] = now.Add(syncPeerCooldown)
+	for id, r := range sm.requested {
+		if r.conn == conn {
+			delete(sm.requested, id)
+		}
+	}
+	sm.requestBlocks(now)
+}
+
+// takeReady pops the blocks that can be applied in order
+func (sm *syncManager) takeReady() []*blockchain.SignedBlock {
+	ready := make([]*blockchain.SignedBlock, 0)
+	for len(sm.headers) > 0 {
+		header := sm.headers[0].Header
+		block, ok := sm.received[header.Id]
+		if !ok {
+			if !sm.node.hasBlock(header.Id) {
+				break
+			}
+			block, _ = sm.node.getBlock(header.Id)
+		}
+		delete(sm.received, header.Id)
+		sm.headers = sm.headers[1:]
+		ready = append(ready, block)
+	}
+	if !sm.headerRequested && sm.lastHeight >= sm.targetHeight && len(sm.headers) == 0 && len(ready) == 0 {
+		sm.finish()
+	}
+	return ready
+}
+
+// apply adds the blocks to the fork tree, the commit manager finalizes the ones a quorum committed.
+// It's called with applyMutex held so the batches are applied in the order they were taken.
+// The bodies already match their validated headers, so a rejected block condemns the headers.
+func (sm *syncManager) apply(ready []*blockchain.SignedBlock) {
+	for _, block := range ready {
+		if err := sm.node.acceptBlock(block); err != nil {
+			fmt.Println("synchronized block ", block.Header.Height, " is rejected: ", err)
+			sm.mutex.Lock()
+			sm.abort()
+			sm.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// serve answers the sync requests of a peer
+func (sm *syncManager) serve(conn *network.Connection, request network.RequestPacket) {
+	switch request.Type {
+	case network.HeadersRequest:
+		count := request.Count
+		if count > MaxSyncHeaders {
+			count = MaxSyncHeaders
+		}
+		headers := make([]blockchain.SignedHeader, 0, count)
+		for height := uint64(request.StartHeight); height < uint64(request.StartHeight) + uint64(count); height++ {
+			block, err := sm.node.blockAt(height)
+			if err != nil {
+				break
+			}
+			headers = append(headers, block.SignedHeader)
+		}
+		packet := network.HeadersPacket{
+			Headers: 	headers,
+			Commits: 	sm.node.commitManager.Evidence(),
+		}
+		if err := conn.Send(packet); err != nil {
+			fmt.Println(err)
+		}
+	case network.BlocksRequest:
+		if len(request.Ids) > MaxSyncBlocks {
+			conn.Misbehave(network.Misbehavior(network.OversizedMessage, "%d blocks requested", len(request.Ids)))
+			return
+		}
+		for _, id := range request.Ids {
+			block, err := sm.node.getBlock(id)
+			if err != nil {
+				continue
+			}
+			if err := conn.SendWithPriority(*block, network.SyncPriority); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+	default:
+		fmt.Println("unknown request type ", request.Type)
+	}
+}
+
+// notice describes the head of this node
+func (node *Node) notice() network.NoticePacket {
+	return network.NoticePacket{
+		LastCommitBlockHeight: 	uint32(node.blockLog.TopBlockHeight()),
+		LastCommitBlockId: 		node.blockLog.TopBlockId(),
+		TopBlockHeight: 		uint32(node.forkTree.HeadHeight()),
+		TopBlockId: 			node.forkTree.HeadId(),
+	}
+}
+
+func (node *Node) hasBlock(id blockchain.SHA256Type) bool {
+	return node.forkTree.HasBlock(id) || node.blockLog.HasBlock(id)
+}
+
+// blockAt returns the block at height on the irreversible chain or the head branch
+func (node *Node) blockAt(height uint64) (*blockchain.SignedBlock, error) {
+	if height <= node.blockLog.TopBlockHeight() {
+		return node.blockLog.ReadBlockByHeight(height)
+	}
+	return node.forkTree.HeadBranchBlock(height)
+}
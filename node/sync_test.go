@@ -0,0 +1,196 @@
+package node
+
+import (
+	"testing"
+	"time"
+	"io"
+	"io/ioutil"
+	"os"
+	"consensus_layer/blockchain"
+	"consensus_layer/consensus"
+	"consensus_layer/crypto"
+	"consensus_layer/network"
+)
+
+// testStream is a stream without a peer, what is written to it is dropped
+type testStream struct {
+	address string
+}
+
+func (s *testStream) ReadMessage() (network.Message, error) { return network.Message{}, io.EOF }
+func (s *testStream) WriteMessage(message network.Message) error { return nil }
+func (s *testStream) Close() error { return nil }
+func (s *testStream) LocalAddress() string { return "localhost:2000" }
+func (s *testStream) RemoteAddress() string { return s.address }
+func (s *testStream) RemoteKey() *crypto.PublicKey { return nil }
+
+func newTestConnection(address string) *network.Connection {
+	return network.NewIncomingConnection(&testStream{address: address}, nil, nil)
+}
+
+func TestSyncValidateHeader(t *testing.T) {
+	privateKey, _ := crypto.NewRandomPrivateKey()
+	producers := []consensus.Producer{{Address: "producer1", PublicKey: privateKey.PublicKey()}}
+	now := time.Now()
+	genesisTime := now.Add(-time.Hour).Truncate(time.Second)
+	node := &Node{
+		producers: 	producers,
+		scheduler: 	consensus.NewScheduler(genesisTime, time.Second, producers),
+	}
+	sm := newSyncManager(node)
+	newHeader := func(height uint64, previousId blockchain.SHA256Type, key *crypto.PrivateKey) blockchain.SignedHeader {
+		header := blockchain.BlockHeader{
+			Height: 	height,
+			PreviousId: previousId,
+			Producer: 	"producer1",
+			Timestamp: 	genesisTime.Add(time.Duration(height) * time.Second).UTC(),
+		}
+		signed, err := blockchain.SignHeader(header, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	header1 := newHeader(1, blockchain.SHA256Type{}, privateKey)
+	if err := sm.validateHeader(header1, now); err != nil {
+		t.Fatal(err)
+	}
+	sm.lastHeight, sm.lastId, sm.lastHeader = 1, header1.Header.Id, &header1.Header
+	if err := sm.validateHeader(newHeader(2, blockchain.SHA256Type{1}, privateKey), now); err == nil {
+		t.Fatal("header that doesn't extend the last header should be rejected")
+	}
+	otherKey, _ := crypto.NewRandomPrivateKey()
+	if err := sm.validateHeader(newHeader(2, header1.Header.Id, otherKey), now); err == nil {
+		t.Fatal("header signed by another key should be rejected")
+	}
+	tampered := newHeader(2, header1.Header.Id, privateKey)
+	tampered.Header.AppHash = blockchain.SHA256Type{1}
+	if err := sm.validateHeader(tampered, now); err == nil {
+		t.Fatal("tampered header should be rejected")
+	}
+	if err := sm.validateHeader(newHeader(2, header1.Header.Id, privateKey), now); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncUnservedHead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blockLog, err := blockchain.OpenBlockLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blockLog.Close()
+	node := &Node{
+		blockLog: 		blockLog,
+		forkTree: 		blockchain.NewForkTree(blockLog.TopBlockId(), 0, blockchain.LongestChain, nil),
+		commitManager: 	consensus.NewCommitManager(nil, "", nil, nil),
+	}
+	sm := newSyncManager(node)
+	conn := newTestConnection("10.0.0.1:2000")
+	now := time.Now()
+
+	// the peer announces a head it can't serve
+	sm.updateHead(conn, 100)
+	sm.mutex.Lock()
+	if !sm.start(now) {
+		t.Fatal("sync should follow the peer ahead of this node")
+	}
+	sm.headerRequested = true
+	sm.mutex.Unlock()
+	if !sm.blocksProduction(now) || sm.blocksProduction(now.Add(MaxSyncStall)) {
+		t.Fatal("sync should only hold the production back while it makes progress")
+	}
+	sm.receivedHeaders(conn, network.HeadersPacket{}, now)
+	if conn.Offenses() != 1 {
+		t.Fatal("empty headers for an announced head should be scored")
+	}
+	sm.mutex.Lock()
+	sm.takeReady()
+	synchronizing := sm.synchronizing
+	sm.mutex.Unlock()
+	if synchronizing {
+		t.Fatal("sync should finish with what the peer served")
+	}
+
+	// announcing the head again doesn't restart the sync before the cooldown
+	sm.updateHead(conn, 100)
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if sm.start(now.Add(time.Second)) {
+		t.Fatal("peer that didn't serve its head should be ignored")
+	}
+	if !sm.start(now.Add(syncPeerCooldown)) {
+		t.Fatal("peer should be followed again after the cooldown")
+	}
+}
+
+func TestSyncMalformedPayload(t *testing.T) {
+	sm := newSyncManager(&Node{})
+	conn := newTestConnection("10.0.0.1:2000")
+	message := network.Message{Header: network.MessageHeader{Type: network.Notice}, Payload: []byte{1}}
+	if err := sm.Receive(conn, message); err == nil || conn.Offenses() != 1 {
+		t.Fatal("payload that can't be decoded should be scored")
+	}
+}
+
+func TestSyncInvalidBody(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blockLog, err := blockchain.OpenBlockLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blockLog.Close()
+	node := &Node{
+		blockLog: 		blockLog,
+		forkTree: 		blockchain.NewForkTree(blockLog.TopBlockId(), 0, blockchain.LongestChain, nil),
+		commitManager: 	consensus.NewCommitManager(nil, "", nil, nil),
+	}
+	sm := newSyncManager(node)
+	bad := newTestConnection("10.0.0.1:2000")
+	good := newTestConnection("10.0.0.2:2000")
+	now := time.Now()
+
+	block := &blockchain.SignedBlock{}
+	block.Header.Height = 1
+	block.Header.PreviousId = blockLog.TopBlockId()
+	block.Header.Producer = "producer1"
+	if err := block.Seal(); err != nil {
+		t.Fatal(err)
+	}
+	sm.updateHead(bad, 1)
+	sm.updateHead(good, 1)
+	sm.mutex.Lock()
+	sm.synchronizing = true
+	sm.headers = []blockchain.SignedHeader{block.SignedHeader}
+	sm.requested[block.Header.Id] = blockRequest{conn: bad, sentAt: now}
+	sm.mutex.Unlock()
+
+	// the peer serves another body for the header
+	forged := *block
+	forged.Transactions = []blockchain.Transaction{{}}
+	if !sm.receivedBlock(bad, &forged) {
+		t.Fatal("requested block should be taken by the sync")
+	}
+	if bad.Offenses() != 1 {
+		t.Fatal("body that doesn't match its header should be scored")
+	}
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if !sm.synchronizing || len(sm.headers) != 1 {
+		t.Fatal("invalid body shouldn't abort the sync")
+	}
+	if r, ok := sm.requested[block.Header.Id]; !ok || r.conn != good {
+		t.Fatal("block should be requested from another peer")
+	}
+	if !sm.isIgnored(bad, now) {
+		t.Fatal("peer that served an invalid body should be ignored")
+	}
+}
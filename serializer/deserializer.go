@@ -4,6 +4,7 @@ import (
 	"reflect"
 	"fmt"
 	"encoding/binary"
+	"time"
 )
 
 type Deserializer struct {
@@ -50,6 +51,8 @@ func (d *Deserializer) Deserialize(v interface{}) error {
 		return d.bytesDeserializer(reflectValue)
 	case *string:
 		return d.stringDeserializer(reflectValue)
+	case *time.Time:
+		return d.timeDeserializer(reflectValue)
 	default:
 		return d.recursiveDeserializer(v, reflectValue)
 	}
@@ -64,6 +67,11 @@ func (d *Deserializer) ReadBytes(l int) ([]byte, error)  {
 	return bytes, nil
 }
 
+// Remaining returns the number of bytes that haven't been read
+func (d *Deserializer) Remaining() int {
+	return len(d.buffer) - d.pos
+}
+
 func (d *Deserializer) byteDeserializer(v reflect.Value) error {
 	if err := d.checkBufferLength(1); err != nil {
 		return err
@@ -149,9 +157,6 @@ func (d *Deserializer) bytesDeserializer(v reflect.Value) error {
 	if err != nil {
 		return err
 	}
-	if err := d.checkBufferLength(int(l)); err != nil {
-		return err
-	}
 	bytes := d.buffer[d.pos : d.pos+int(l)]
 	d.pos += int(l)
 	v.SetBytes(bytes)
@@ -163,24 +168,81 @@ func (d *Deserializer) stringDeserializer(v reflect.Value) error {
 	if err != nil {
 		return err
 	}
-	if err := d.checkBufferLength(int(l)); err != nil {
-		return err
-	}
 	value := string(d.buffer[d.pos : d.pos+int(l)])
 	d.pos += int(l)
 	v.SetString(value)
 	return nil
 }
 
+func (d *Deserializer) timeDeserializer(v reflect.Value) error {
+	if err := d.checkBufferLength(Uint64Size + Uint32Size); err != nil {
+		return err
+	}
+	sec := int64(binary.BigEndian.Uint64(d.buffer[d.pos:]))
+	d.pos += Uint64Size
+	nsec := int64(binary.BigEndian.Uint32(d.buffer[d.pos:]))
+	d.pos += Uint32Size
+	v.Set(reflect.ValueOf(time.Unix(sec, nsec).UTC()))
+	return nil
+}
+
+// readLength reads the length of a byte string, which can't be longer than the rest of the buffer
 func (d *Deserializer) readLength() (uint64, error) {
+	return d.readCount(1)
+}
+
+// readCount reads the number of elements of a slice or map whose elements take at least
+// minSize bytes each, so a count that can't fit in the rest of the buffer is rejected before
+// anything is allocated
+func (d *Deserializer) readCount(minSize int) (uint64, error) {
 	l, n := binary.Uvarint(d.buffer[d.pos:])
 	if n <= 0 {
 		return l, fmt.Errorf("can not read length")
 	}
 	d.pos += n
+	if minSize < 1 {
+		minSize = 1
+	}
+	if l > uint64(d.Remaining() / minSize) {
+		return l, fmt.Errorf("length %d exceeds the %d remaining bytes", l, d.Remaining())
+	}
 	return l, nil
 }
 
+var timeType = reflect.TypeOf(time.Time{})
+
+// minEncodedSize is the fewest bytes a value of the type is encoded with
+func minEncodedSize(t reflect.Type) int {
+	switch t.Kind() {
+	case reflect.Uint8, reflect.Int8, reflect.Bool:
+		return 1
+	case reflect.Uint16, reflect.Int16:
+		return Uint16Size
+	case reflect.Uint32, reflect.Int32:
+		return Uint32Size
+	case reflect.Uint64, reflect.Int64:
+		return Uint64Size
+	case reflect.String, reflect.Slice, reflect.Map:
+		return 1 // the length
+	case reflect.Array:
+		return t.Len() * minEncodedSize(t.Elem())
+	case reflect.Struct:
+		if t == timeType {
+			return Uint64Size + Uint32Size
+		}
+		size := 0
+		for i := 0; i < t.NumField(); i++ {
+			// unexported fields are skipped
+			if t.Field(i).PkgPath == "" {
+				size += minEncodedSize(t.Field(i).Type)
+			}
+		}
+		return size
+	default:
+		return 0
+	}
+}
+
 func (d *Deserializer) checkBufferLength(l int) error {
 	if len(d.buffer) - d.pos < l {
 		return fmt.Errorf("exceeding buffer's length")
@@ -200,8 +262,45 @@ func (d *Deserializer) recursiveDeserializer(v interface{}, rv reflect.Value) er
 		return d.mapDeserializer(rv)
 	default:
 		if d.Extension != nil {
-			return d.Extension(v)
+			pos := d.pos
+			if err := d.Extension(v); err == nil {
+				return nil
+			}
+			d.pos = pos
 		}
+		return d.kindDeserializer(rv)
+	}
+}
+
+// named types (e.g. type MessageType byte) are deserialized by their underlying kind
+func (d *Deserializer) kindDeserializer(rv reflect.Value) error {
+	switch rv.Kind() {
+	case reflect.Uint8:
+		return d.byteDeserializer(rv)
+	case reflect.Int8:
+		if err := d.checkBufferLength(1); err != nil {
+			return err
+		}
+		rv.SetInt(int64(int8(d.buffer[d.pos])))
+		d.pos += 1
+		return nil
+	case reflect.Int16:
+		return d.int16Deserializer(rv)
+	case reflect.Uint16:
+		return d.uint16Deserializer(rv)
+	case reflect.Int32:
+		return d.int32Deserializer(rv)
+	case reflect.Uint32:
+		return d.uint32Deserializer(rv)
+	case reflect.Int64:
+		return d.int64Deserializer(rv)
+	case reflect.Uint64:
+		return d.uint64Deserializer(rv)
+	case reflect.Bool:
+		return d.boolDeserializer(rv)
+	case reflect.String:
+		return d.stringDeserializer(rv)
+	default:
 		return fmt.Errorf("wrong type: %s", rv.Type().String())
 	}
 }
@@ -217,7 +316,7 @@ func (d *Deserializer) arrayDeserializer(rv reflect.Value) error {
 }
 
 func (d *Deserializer) sliceDeserializer(rv reflect.Value) error {
-	l, err := d.readLength()
+	l, err := d.readCount(minEncodedSize(rv.Type().Elem()))
 	if err != nil {
 		return err
 	}
@@ -244,7 +343,7 @@ func (d *Deserializer) structDeserializer(rv reflect.Value) error {
 }
 
 func (d *Deserializer) mapDeserializer(rv reflect.Value) error {
-	l, err := d.readLength()
+	l, err := d.readCount(minEncodedSize(rv.Type().Key()) + minEncodedSize(rv.Type().Elem()))
 	if err != nil {
 		return err
 	}
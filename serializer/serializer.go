@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"fmt"
 	"bytes"
+	"time"
 )
 
 const Uint16Size = 2
@@ -49,6 +50,8 @@ func (s *Serializer) Serialize(v interface{}) error {
 		return s.bytesSerializer(t)
 	case string:
 		return s.stringSerializer(t)
+	case time.Time:
+		return s.timeSerializer(t)
 	default:
 		return s.recursiveSerializer(v)
 	}
@@ -114,6 +117,15 @@ func (s *Serializer) stringSerializer(v string) error {
 	return s.bytesSerializer(bytes)
 }
 
+// time is written as unix seconds followed by nanoseconds, always in UTC
+func (s *Serializer) timeSerializer(v time.Time) error {
+	v = v.UTC()
+	if err := s.int64Serializer(v.Unix()); err != nil {
+		return err
+	}
+	return s.uint32Serializer(uint32(v.Nanosecond()))
+}
+
 func (s *Serializer) WriteBytes(bytes []byte) error {
 	s.pos += len(bytes)
 	_, err := s.writer.Write(bytes)
@@ -140,9 +152,42 @@ func (s *Serializer) recursiveSerializer(v interface{}) error {
 		return s.mapSerializer(reflectValue)
 	default:
 		if s.Extension != nil {
-			return s.Extension(v)
+			pos, length := s.pos, s.writer.Len()
+			if err := s.Extension(v); err == nil {
+				return nil
+			}
+			s.pos = pos
+			s.writer.Truncate(length)
 		}
-		return fmt.Errorf("wrong type: %s", reflectValue.Type().String())
+		return s.kindSerializer(reflectValue)
+	}
+}
+
+// named types (e.g. type MessageType byte) are serialized by their underlying kind
+func (s *Serializer) kindSerializer(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Uint8:
+		return s.byteSerializer(byte(v.Uint()))
+	case reflect.Int8:
+		return s.int8Serializer(int8(v.Int()))
+	case reflect.Int16:
+		return s.int16Serializer(int16(v.Int()))
+	case reflect.Uint16:
+		return s.uint16Serializer(uint16(v.Uint()))
+	case reflect.Int32:
+		return s.int32Serializer(int32(v.Int()))
+	case reflect.Uint32:
+		return s.uint32Serializer(uint32(v.Uint()))
+	case reflect.Int64:
+		return s.int64Serializer(v.Int())
+	case reflect.Uint64:
+		return s.uint64Serializer(v.Uint())
+	case reflect.Bool:
+		return s.boolSerializer(v.Bool())
+	case reflect.String:
+		return s.stringSerializer(v.String())
+	default:
+		return fmt.Errorf("wrong type: %s", v.Type().String())
 	}
 }
 
@@ -3,6 +3,7 @@ package serializer
 import (
 	"testing"
 	"fmt"
+	"time"
 )
 
 type User struct {
@@ -35,3 +36,42 @@ func TestMapSerializer(t *testing.T) {
 	UnmarshalBinary(buf, &users2)
 	fmt.Println(users2)
 }
+
+type Record struct {
+	Kind 		Kind
+	CreatedAt 	time.Time
+}
+
+type Kind byte
+
+func TestNamedKindAndTimeSerializer(t *testing.T) {
+	record1 := Record{
+		Kind: 		Kind(7),
+		CreatedAt: 	time.Unix(1500000000, 123).UTC(),
+	}
+	buf, err := MarshalBinary(record1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record2 := Record{}
+	if err := UnmarshalBinary(buf, &record2); err != nil {
+		t.Fatal(err)
+	}
+	if record2.Kind != record1.Kind || !record2.CreatedAt.Equal(record1.CreatedAt) {
+		t.Fatal("record should be the same")
+	}
+}
+
+func TestFailedExtensionIsRewound(t *testing.T) {
+	s := NewSerializer()
+	s.Extension = func(v interface{}) error {
+		s.WriteBytes([]byte{1, 2, 3})
+		return fmt.Errorf("not handled")
+	}
+	if err := s.Serialize(Kind(7)); err != nil {
+		t.Fatal(err)
+	}
+	if buf := s.Bytes(); len(buf) != 1 || buf[0] != 7 {
+		t.Fatal("bytes of the failed extension should be dropped, got ", buf)
+	}
+}